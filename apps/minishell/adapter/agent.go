@@ -0,0 +1,53 @@
+package adapter
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ConnectAgent 连接 SSH_AUTH_SOCK 指向的 ssh-agent, 未设置 SSH_AUTH_SOCK 时返回 nil
+func ConnectAgent() (agent.ExtendedAgent, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, nil
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, fmt.Errorf("connect ssh-agent failure, nest error: %v, sock: %v", err, sock)
+	}
+	return agent.NewClient(conn), nil
+}
+
+// forwardAgent 在 client 上处理远端打开的 auth-agent@openssh.com 通道, 转发到本地 ssh-agent
+func (d *Dialer) forwardAgent(client *ssh.Client, machine *assets.Machine) error {
+	if !machine.ForwardAgent {
+		return nil
+	}
+	if d.Agent == nil && d.AgentErr != nil {
+		return fmt.Errorf("forward-agent requires a running ssh-agent, nest error: %v, machine: %v", d.AgentErr, machine.IP)
+	}
+	if d.Agent == nil {
+		return fmt.Errorf("forward-agent requires a running ssh-agent, SSH_AUTH_SOCK is not set, machine: %v", machine.IP)
+	}
+	return agent.ForwardToAgent(client, d.Agent)
+}
+
+// NewSession 创建 session, machine 开启 forward-agent 时请求 agent 转发
+func (d *Dialer) NewSession(client *ssh.Client, machine *assets.Machine) (*ssh.Session, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	if machine.ForwardAgent && d.Agent != nil {
+		if err := agent.RequestAgentForwarding(session); err != nil {
+			session.Close()
+			return nil, fmt.Errorf("request agent forwarding failure, nest error: %v", err)
+		}
+	}
+	return session, nil
+}
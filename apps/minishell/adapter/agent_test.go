@@ -0,0 +1,72 @@
+package adapter
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestAgent(t *testing.T) {
+	assert := assert.New(t)
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(err)
+	keyring := agent.NewKeyring()
+	assert.Nil(keyring.Add(agent.AddedKey{PrivateKey: priv, Comment: "test@minishell"}))
+	signer, err := ssh.NewSignerFromKey(priv)
+	assert.Nil(err)
+
+	server := newTestServer(t, "root", "root-password")
+	server.AuthorizedKeys = []ssh.PublicKey{signer.PublicKey()}
+	machine := server.machine("root", "")
+
+	// 没有 agent 时无法认证
+	dialer := newTestDialer(t)
+	_, err = dialer.Dial(machine)
+	assert.NotNil(err)
+
+	dialer.Agent = keyring
+	var stdout, stderr bytes.Buffer
+	code, err := ExecWithSSH(dialer, machine, "ssh-add -l", &stdout, &stderr, 0)
+	assert.Nil(err)
+	assert.Equal(2, code)
+
+	machine.ForwardAgent = true
+	stdout.Reset()
+	code, err = ExecWithSSH(dialer, machine, "ssh-add -l", &stdout, &stderr, 0)
+	assert.Nil(err)
+	assert.Equal(0, code)
+	assert.Equal(ssh.FingerprintSHA256(signer.PublicKey())+" test@minishell\n", stdout.String())
+
+	dialer.Agent = nil
+	machine.Password = "root-password"
+	_, err = dialer.Dial(machine)
+	assert.NotNil(err)
+}
+
+func TestAgentUnavailable(t *testing.T) {
+	assert := assert.New(t)
+
+	t.Setenv("SSH_AUTH_SOCK", filepath.Join(t.TempDir(), "missing.sock"))
+	agent, agentErr := ConnectAgent()
+	assert.Nil(agent)
+	assert.NotNil(agentErr)
+
+	// ssh-agent 不可用时密码登录不受影响, 只有 forward-agent 返回错误
+	server := newTestServer(t, "root", "root-password")
+	dialer := newTestDialer(t)
+	dialer.AgentErr = agentErr
+	machine := server.machine("root", "root-password")
+	_, err := ExecWithSSH(dialer, machine, "uptime", nil, nil, 0)
+	assert.Nil(err)
+
+	machine.ForwardAgent = true
+	_, err = ExecWithSSH(dialer, machine, "uptime", nil, nil, 0)
+	assert.ErrorContains(err, "missing.sock")
+}
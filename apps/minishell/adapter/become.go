@@ -0,0 +1,188 @@
+package adapter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+	"golang.org/x/crypto/ssh"
+)
+
+var ErrBecomeFailed = errors.New("become failure")
+
+var (
+	// becomePasswordPrompt sudo/su 询问密码的提示, 如 "[sudo] password for ops: "、"Password: "、"密码："
+	becomePasswordPrompt = regexp.MustCompile(`(?i)(password|密码)[^\r\n]*[:：]\s*$`)
+	// becomeFailure sudo/su 失败时的输出
+	becomeFailure = regexp.MustCompile(`(?i)[^\r\n]*(sorry, try again|authentication failure|incorrect password|is not in the sudoers|is not allowed to|does not exist|unknown id|no passwd entry|鉴定故障|认证失败)[^\r\n]*`)
+	shellPrompt   = regexp.MustCompile(assets.DefaultPrompt)
+)
+
+// becomeInteractive 在交互式会话中切换用户: 等待登录后的提示符, 发送 sudo/su 命令, 回答密码提示, 直到出现新的提示符
+func becomeInteractive(w *promptWatcher, stdin io.Writer, machine *assets.Machine) error {
+	become := machine.Become
+	if err := w.wait(shellPrompt, assets.DefaultStepTimeout); err != nil {
+		return fmt.Errorf("%w, nest error: %v", ErrBecomeFailed, err)
+	}
+	if _, err := io.WriteString(stdin, become.Command()+"\r"); err != nil {
+		return err
+	}
+
+	var answered bool
+	for {
+		i, match, err := w.waitAny([]*regexp.Regexp{becomeFailure, becomePasswordPrompt, shellPrompt}, assets.DefaultStepTimeout)
+		if err != nil {
+			return fmt.Errorf("%w, nest error: %v", ErrBecomeFailed, err)
+		}
+		switch i {
+		case 0:
+			return fmt.Errorf("%w, %s: %s", ErrBecomeFailed, become, strings.TrimSpace(match))
+		case 1:
+			if answered {
+				return fmt.Errorf("%w, %s: password rejected", ErrBecomeFailed, become)
+			}
+			password, err := machine.BecomePassword()
+			if err != nil {
+				return err
+			}
+			if password == "" {
+				return fmt.Errorf("%w, %s: password required", ErrBecomeFailed, become)
+			}
+			if _, err := io.WriteString(stdin, password+"\r"); err != nil {
+				return err
+			}
+			answered = true
+		default:
+			// 保留提示符, 供之后的登录步骤匹配
+			w.Write([]byte(match))
+			return nil
+		}
+	}
+}
+
+const (
+	// becomeExecPrompt exec 时 sudo 询问密码的提示, 使用固定内容以免与命令输出混淆
+	becomeExecPrompt = "[minishell-become] password: "
+	// becomeExecOK 切换用户成功后、执行命令前输出的标记, 之前的输出(密码提示、sudo 的提醒等)不会写入 stdout
+	becomeExecOK = "__minishell_become_ok__"
+)
+
+var becomeExecOKLine = regexp.MustCompile(becomeExecOK + `\r?\n`)
+
+// runBecome 以 machine.Become 的目标用户执行 command. su 需要终端读取密码, 因此在 pty 中执行, stderr 合并到 stdout
+func runBecome(session *ssh.Session, machine *assets.Machine, command string, stdout io.Writer) error {
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          0,
+		ssh.ONLCR:         0,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err := session.RequestPty("dumb", 40, 200, modes); err != nil {
+		return err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	// 切换失败时 sudo/su 可能仍在等待输入, 关闭会话使命令结束
+	filter := &becomeFilter{machine: machine, stdin: stdin, stdout: stdout, abort: func() { session.Close() }}
+	session.Stdout = filter
+	session.Stderr = filter
+
+	err = session.Run(machine.Become.WrapCommand("echo "+becomeExecOK+"; "+command, becomeExecPrompt))
+	if ferr := filter.result(); ferr != nil {
+		return ferr
+	}
+	return err
+}
+
+// becomeFilter 在切换用户成功前缓存输出并回答密码提示, 成功后将输出写入 stdout
+type becomeFilter struct {
+	machine *assets.Machine
+	stdin   io.Writer
+	stdout  io.Writer
+	abort   func()
+
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	ok       bool
+	answered bool
+	err      error
+}
+
+func (f *becomeFilter) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.ok {
+		return f.stdout.Write(b)
+	}
+	if f.err != nil {
+		return len(b), nil
+	}
+
+	f.buf.Write(b)
+	data := f.buf.Bytes()
+	if loc := becomeExecOKLine.FindIndex(data); loc != nil {
+		f.ok = true
+		if _, err := f.stdout.Write(data[loc[1]:]); err != nil {
+			return 0, err
+		}
+		f.buf.Reset()
+		return len(b), nil
+	}
+
+	if match := becomeFailure.Find(data); match != nil {
+		f.fail(fmt.Errorf("%w, %s: %s", ErrBecomeFailed, f.machine.Become, strings.TrimSpace(string(match))))
+		return len(b), nil
+	}
+	if bytes.HasSuffix(data, []byte(becomeExecPrompt)) || becomePasswordPrompt.Match(data) {
+		f.buf.Reset()
+		if f.answered {
+			f.fail(fmt.Errorf("%w, %s: password rejected", ErrBecomeFailed, f.machine.Become))
+			return len(b), nil
+		}
+		password, err := f.machine.BecomePassword()
+		if err != nil {
+			f.fail(err)
+			return len(b), nil
+		}
+		if password == "" {
+			f.fail(fmt.Errorf("%w, %s: password required", ErrBecomeFailed, f.machine.Become))
+			return len(b), nil
+		}
+		f.answered = true
+		io.WriteString(f.stdin, password+"\n")
+	}
+	return len(b), nil
+}
+
+func (f *becomeFilter) fail(err error) {
+	f.err = err
+	if f.abort != nil {
+		go f.abort()
+	}
+}
+
+// result 命令结束后检查是否切换成功
+func (f *becomeFilter) result() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.ok {
+		return nil
+	}
+	if f.err != nil {
+		return f.err
+	}
+	if msg := strings.TrimSpace(f.buf.String()); msg != "" {
+		return fmt.Errorf("%w, %s: %s", ErrBecomeFailed, f.machine.Become, msg)
+	}
+	return fmt.Errorf("%w, %s", ErrBecomeFailed, f.machine.Become)
+}
@@ -0,0 +1,57 @@
+package adapter
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBecomeExec(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newTestServer(t, "ops", testBecomePassword)
+
+	// sudo 默认使用登录密码
+	machine := server.machine("ops", testBecomePassword)
+	machine.Become = &assets.Become{Method: assets.BecomeSudo}
+	var stdout bytes.Buffer
+	code, err := ExecWithSSH(newTestDialer(t), machine, "cat /etc/shadow | grep 'root'", &stdout, io.Discard, 5*time.Second)
+	assert.Nil(err)
+	assert.Equal(0, code)
+	assert.Equal("exec: cat /etc/shadow | grep 'root'\n", stdout.String())
+
+	machine.Become = &assets.Become{Method: assets.BecomeSu, User: "oracle", Password: "wrong"}
+	stdout.Reset()
+	code, err = ExecWithSSH(newTestDialer(t), machine, "id", &stdout, io.Discard, 5*time.Second)
+	assert.True(errors.Is(err, ErrBecomeFailed))
+	assert.Contains(err.Error(), "Authentication failure")
+	assert.Equal(-1, code)
+	assert.Empty(stdout.String())
+}
+
+func TestBecomeInteractive(t *testing.T) {
+	assert := assert.New(t)
+
+	machine := &assets.Machine{IP: "10.0.0.1", Username: "ops", Password: "login", Become: &assets.Become{Method: assets.BecomeSu, Password: "root"}}
+	watcher := newPromptWatcher()
+	shell := &fakeShell{out: watcher, replies: []string{"\r\nPassword: ", "\r\n[root@web ~]# "}}
+	watcher.Write([]byte("Last login: Mon Oct 12\r\n[ops@web ~]$ "))
+	assert.Nil(becomeInteractive(watcher, shell, machine))
+	assert.Equal([]string{"su - root", "root"}, shell.lines)
+	// 提示符保留给之后的登录步骤
+	assert.Nil(runSteps(watcher, shell, machine, []*assets.Step{{Send: "cd /data"}}))
+
+	watcher = newPromptWatcher()
+	machine.Become = &assets.Become{Method: assets.BecomeSudo}
+	shell = &fakeShell{out: watcher, replies: []string{"\r\n[sudo] password for ops: ", "\r\nSorry, try again.\r\n[sudo] password for ops: "}}
+	watcher.Write([]byte("[ops@web ~]$ "))
+	err := becomeInteractive(watcher, shell, machine)
+	assert.True(errors.Is(err, ErrBecomeFailed))
+	assert.Contains(err.Error(), "Sorry, try again.")
+	assert.Equal([]string{"sudo -i -u root", "login"}, shell.lines)
+}
@@ -0,0 +1,212 @@
+package adapter
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// asciicast v2 格式, 参见 https://docs.asciinema.org/manual/asciicast/v2/
+//
+//	{"version": 2, "width": 80, "height": 24, "timestamp": 1504467315, ...}
+//	[0.248848, "o", "\u001b[1;31mHello \u001b[32mWorld!\u001b[0m\n"]
+//	[1.001376, "r", "100x40"]
+const (
+	CastEventOutput = "o"
+	CastEventInput  = "i"
+	CastEventResize = "r"
+)
+
+type CastHeader struct {
+	Version       int               `json:"version"`
+	Width         int               `json:"width"`
+	Height        int               `json:"height"`
+	Timestamp     int64             `json:"timestamp,omitempty"`
+	IdleTimeLimit float64           `json:"idle_time_limit,omitempty"`
+	Title         string            `json:"title,omitempty"`
+	Env           map[string]string `json:"env,omitempty"`
+}
+
+type CastEvent struct {
+	Time float64
+	Type string
+	Data string
+}
+
+// Recorder 将终端输出按 asciicast v2 格式写入 w, 可并发调用
+type Recorder struct {
+	w     *bufio.Writer
+	mu    sync.Mutex
+	begin time.Time
+
+	// pending 上次写入末尾不完整的 UTF-8 字符, 与下一次输出合并后再记录
+	pending []byte
+	// err 第一次写入失败的错误, 之后不再记录, 由 Close 返回
+	err error
+	// warn 写入失败时输出一次提示
+	warn io.Writer
+}
+
+func NewRecorder(w io.Writer, width, height int, title string) (*Recorder, error) {
+	r := &Recorder{w: bufio.NewWriter(w), begin: time.Now(), warn: os.Stderr}
+
+	header, err := json.Marshal(&CastHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: r.begin.Unix(),
+		Title:     title,
+		Env:       map[string]string{"TERM": os.Getenv("TERM"), "SHELL": os.Getenv("SHELL")},
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.w.Write(header)
+	if err := r.w.WriteByte('\n'); err != nil {
+		return nil, err
+	}
+	return r, r.w.Flush()
+}
+
+// Write 记录一次输出事件, 实现 io.Writer, 便于与 io.MultiWriter 组合.
+// 记录失败(如磁盘已满)时不返回错误, 以免中断终端输出, 错误由 Close 返回
+func (r *Recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data := append(r.pending, p...)
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	r.pending = append([]byte(nil), data[cut:]...)
+	if cut != 0 {
+		r.event(CastEventOutput, string(data[:cut]))
+	}
+	return len(p), nil
+}
+
+// Resize 记录终端尺寸变化
+func (r *Recorder) Resize(width, height int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.event(CastEventResize, fmt.Sprintf("%dx%d", width, height))
+	return nil
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.pending) != 0 {
+		r.event(CastEventOutput, string(r.pending))
+		r.pending = nil
+	}
+	if err := r.w.Flush(); err != nil {
+		r.fail(err)
+	}
+	return r.err
+}
+
+func (r *Recorder) event(kind, data string) {
+	if r.err != nil {
+		return
+	}
+	elapsed := time.Since(r.begin).Seconds()
+	line, err := json.Marshal([]interface{}{json.Number(fmt.Sprintf("%.6f", elapsed)), kind, data})
+	if err != nil {
+		r.fail(err)
+		return
+	}
+	r.w.Write(line)
+	if err := r.w.WriteByte('\n'); err != nil {
+		r.fail(err)
+		return
+	}
+	// 每个事件都刷新, 会话异常中断时也能保留已记录的内容
+	if err := r.w.Flush(); err != nil {
+		r.fail(err)
+	}
+}
+
+// fail 记录第一次失败的错误并提示, 调用方需持有 mu
+func (r *Recorder) fail(err error) {
+	if r.err != nil {
+		return
+	}
+	r.err = err
+	if r.warn != nil {
+		fmt.Fprintf(r.warn, "\r\n==> Warning: recording stopped, nest error: %v\r\n", err)
+	}
+}
+
+// CastReader 逐条读取 asciicast v2 事件
+type CastReader struct {
+	Header CastHeader
+
+	scanner *bufio.Scanner
+	line    int
+}
+
+func NewCastReader(r io.Reader) (*CastReader, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	c := &CastReader{scanner: scanner}
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("empty asciicast file")
+	}
+	c.line++
+	if err := json.Unmarshal(scanner.Bytes(), &c.Header); err != nil {
+		return nil, fmt.Errorf("parse asciicast header failure, nest error: %v", err)
+	}
+	if c.Header.Version != 2 {
+		return nil, fmt.Errorf("unsupported asciicast version: %d", c.Header.Version)
+	}
+	return c, nil
+}
+
+// Next 返回下一条事件, 读取完毕时返回 io.EOF
+func (c *CastReader) Next() (*CastEvent, error) {
+	for c.scanner.Scan() {
+		c.line++
+		line := strings.TrimSpace(c.scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var fields []interface{}
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			return nil, fmt.Errorf("parse asciicast event failure, nest error: %v, line: %d", err, c.line)
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("parse asciicast event failure, want 3 fields, line: %d", c.line)
+		}
+		t, ok1 := fields[0].(float64)
+		kind, ok2 := fields[1].(string)
+		data, ok3 := fields[2].(string)
+		if !ok1 || !ok2 || !ok3 {
+			return nil, fmt.Errorf("parse asciicast event failure, bad field type, line: %d", c.line)
+		}
+		return &CastEvent{Time: t, Type: kind, Data: data}, nil
+	}
+	if err := c.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
@@ -0,0 +1,80 @@
+package adapter
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorder(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	recorder, err := NewRecorder(&buf, 80, 24, "root@127.0.0.1")
+	assert.Nil(err)
+
+	// "中" 被拆成两次写入时不应产生乱码
+	chinese := []byte("中")
+	recorder.Write([]byte("hello\r\n"))
+	recorder.Write(chinese[:1])
+	recorder.Write(chinese[1:])
+	assert.Nil(recorder.Resize(120, 40))
+	assert.Nil(recorder.Close())
+
+	reader, err := NewCastReader(&buf)
+	assert.Nil(err)
+	assert.Equal(2, reader.Header.Version)
+	assert.Equal(80, reader.Header.Width)
+	assert.Equal(24, reader.Header.Height)
+	assert.Equal("root@127.0.0.1", reader.Header.Title)
+
+	var events []*CastEvent
+	for {
+		event, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(err)
+		events = append(events, event)
+	}
+	assert.Len(events, 3)
+	assert.Equal(CastEventOutput, events[0].Type)
+	assert.Equal("hello\r\n", events[0].Data)
+	assert.Equal("中", events[1].Data)
+	assert.Equal(CastEventResize, events[2].Type)
+	assert.Equal("120x40", events[2].Data)
+	assert.True(events[1].Time <= events[2].Time)
+}
+
+func TestRecorderWriteFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		writes int
+		warn   bytes.Buffer
+	)
+	recorder, err := NewRecorder(writerFunc(func(b []byte) (int, error) {
+		if writes++; writes > 1 {
+			return 0, errors.New("no space left on device")
+		}
+		return len(b), nil
+	}), 80, 24, "")
+	assert.Nil(err)
+	recorder.warn = &warn
+
+	// 记录失败不影响终端输出
+	var out bytes.Buffer
+	w := io.MultiWriter(&out, recorder)
+	for i := 0; i < 3; i++ {
+		n, err := w.Write([]byte("hello\r\n"))
+		assert.Nil(err)
+		assert.Equal(7, n)
+	}
+	assert.Equal(strings.Repeat("hello\r\n", 3), out.String())
+	assert.Equal(1, strings.Count(warn.String(), "no space left on device"))
+	assert.ErrorContains(recorder.Close(), "no space left on device")
+}
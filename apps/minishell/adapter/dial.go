@@ -0,0 +1,267 @@
+package adapter
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var defaultConfig = ssh.Config{
+	Ciphers: []string{
+		"aes128-ctr",
+		"aes192-ctr",
+		"aes256-ctr",
+		"aes128-gcm@openssh.com",
+		"arcfour256",
+		"arcfour128",
+		"aes128-cbc",
+	},
+	KeyExchanges: []string{
+		"diffie-hellman-group-exchange-sha1",
+		"diffie-hellman-group1-sha1",
+		"diffie-hellman-group-exchange-sha256",
+		"diffie-hellman-group16-sha512",
+		"diffie-hellman-group18-sha512",
+		"diffie-hellman-group14-sha256",
+		"diffie-hellman-group14-sha1",
+		"curve25519-sha256",
+		"kex-strict-s-v00@openssh.com",
+	},
+}
+
+type Dialer struct {
+	KnownHosts *KnownHosts
+	// Timeout 默认的连接超时, machine 配置了 timeout 时以 machine 为准
+	Timeout time.Duration
+	// Retries 网络错误时的重试次数, 第 n 次重试前等待 Backoff*2^(n-1), 最长 30s
+	Retries int
+	Backoff time.Duration
+	// Keepalive 大于 0 时每隔 Keepalive 发送 keepalive@openssh.com, 连续 KeepaliveMax 次无响应时断开
+	Keepalive    time.Duration
+	KeepaliveMax int
+	// Agent 不为 nil 时使用 ssh-agent 中的 key 认证, 并用于 forward-agent
+	Agent agent.Agent
+	// AgentErr 连接 ssh-agent 失败的原因, 仅在 machine 开启 forward-agent 时返回
+	AgentErr error
+	// PassphrasePrompt 私钥有密码且 machine 未配置 passphrase 时用于输入密码, 为 nil 时返回错误
+	PassphrasePrompt func(prompt string) ([]byte, error)
+
+	keys keyCache
+}
+
+// Dial 依次通过 machine.JumpChain 中的跳板机建立 direct-tcpip 隧道, 最后与目标机器握手,
+// 每一跳都使用该机器自身的认证信息. 关闭返回的 client 时会一并关闭所有跳板机连接.
+// 网络错误时按 Retries、Backoff 重试
+func (d *Dialer) Dial(machine *assets.Machine) (*ssh.Client, error) {
+	for attempt := 0; ; attempt++ {
+		client, err := d.dial(machine)
+		if err == nil {
+			if d.Keepalive > 0 {
+				go keepalive(client, d.Keepalive, d.KeepaliveMax)
+			}
+			return client, nil
+		}
+		if attempt >= d.Retries || !retryable(err) {
+			return nil, err
+		}
+		time.Sleep(backoff(d.Backoff, attempt))
+	}
+}
+
+func (d *Dialer) dial(machine *assets.Machine) (*ssh.Client, error) {
+	if machine.IsTelnet() {
+		return nil, fmt.Errorf("machine %s uses telnet, only interactive login is supported", machine.IP)
+	}
+	via, err := d.dialChain(machine.JumpChain)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := d.clientConfig(machine, machine.Addr())
+	if err != nil {
+		if via != nil {
+			via.Close()
+		}
+		return nil, err
+	}
+	client, err := dialVia(via, machine.Addr(), config)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.forwardAgent(client, machine); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// ScanHostKey 与目标机器完成密钥交换并返回其 host key, 不对目标机器进行认证
+func (d *Dialer) ScanHostKey(machine *assets.Machine) (ssh.PublicKey, error) {
+	via, err := d.dialChain(machine.JumpChain)
+	if err != nil {
+		return nil, err
+	}
+
+	var hostKey ssh.PublicKey
+	client, err := dialVia(via, machine.Addr(), &ssh.ClientConfig{
+		User:    machine.Username,
+		Config:  defaultConfig,
+		Timeout: d.timeout(machine),
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return errHostKeyScanned
+		},
+	})
+	if err == nil {
+		client.Close()
+	}
+	if via != nil {
+		via.Close()
+	}
+	if hostKey != nil {
+		return hostKey, nil
+	}
+	return nil, err
+}
+
+// dialChain 依次连接跳板机, 返回最后一跳的 client; chain 为空时返回 nil
+func (d *Dialer) dialChain(chain []*assets.Machine) (*ssh.Client, error) {
+	var client *ssh.Client
+	for i, hop := range chain {
+		if hop.IsTelnet() {
+			if client != nil {
+				client.Close()
+			}
+			return nil, fmt.Errorf("jump host %s uses telnet, jump host must use ssh", hop.IP)
+		}
+		addr := net.JoinHostPort(hop.Host(), strconv.Itoa(hop.Port))
+		if i != 0 {
+			addr = net.JoinHostPort(hop.IP, strconv.Itoa(hop.Port))
+		}
+
+		config, err := d.clientConfig(hop, addr)
+		if err != nil {
+			if client != nil {
+				client.Close()
+			}
+			return nil, err
+		}
+		next, err := dialVia(client, addr, config)
+		if err != nil {
+			return nil, err
+		}
+		client = next
+	}
+	return client, nil
+}
+
+// dialVia 经 via 建立 direct-tcpip 隧道后握手, via 为 nil 时直连; 返回的 client 关闭时一并关闭 via
+func dialVia(via *ssh.Client, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if via == nil {
+		client, err := ssh.Dial("tcp", addr, config)
+		if err != nil {
+			return nil, fmt.Errorf("dial %s failure, nest error: %w", addr, err)
+		}
+		return client, nil
+	}
+
+	conn, err := via.Dial("tcp", addr)
+	if err != nil {
+		via.Close()
+		return nil, fmt.Errorf("tunnel to %s failure, nest error: %w", addr, err)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		via.Close()
+		return nil, fmt.Errorf("handshake with %s failure, nest error: %w", addr, err)
+	}
+	client := ssh.NewClient(c, chans, reqs)
+	go func() {
+		client.Wait()
+		via.Close()
+	}()
+	return client, nil
+}
+
+func (d *Dialer) clientConfig(machine *assets.Machine, addr string) (*ssh.ClientConfig, error) {
+	authMethods, err := d.authMethods(machine)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ssh.ClientConfig{
+		User:              machine.Username,
+		Auth:              authMethods,
+		Config:            defaultConfig,
+		Timeout:           d.timeout(machine),
+		HostKeyCallback:   d.KnownHosts.HostKeyCallback(),
+		HostKeyAlgorithms: d.KnownHosts.HostKeyAlgorithms(addr),
+	}, nil
+}
+
+// timeout 返回 machine 的连接超时, 未配置时使用 Dialer.Timeout
+func (d *Dialer) timeout(machine *assets.Machine) time.Duration {
+	if machine.Timeout > 0 {
+		return machine.Timeout
+	}
+	return d.Timeout
+}
+
+func (d *Dialer) authMethods(machine *assets.Machine) ([]ssh.AuthMethod, error) {
+	authMethods := make([]ssh.AuthMethod, 0, 4)
+
+	var signers []ssh.Signer
+	if machine.PrivateKeyPath != "" && machine.PrivateKeyPath != assets.NotExist {
+		signer, err := d.signer(machine)
+		if err != nil {
+			return nil, err
+		}
+		// 与 OpenSSH 一样先使用证书认证, 服务端不信任证书时再使用私钥
+		cert, err := certSigner(machine, signer)
+		if err != nil {
+			return nil, err
+		}
+		if cert != nil {
+			signers = append(signers, cert)
+		}
+		signers = append(signers, signer)
+	}
+	// 同一认证方式失败后不会再次尝试, 私钥文件与 ssh-agent 中的 key 需放在同一个 publickey 认证中
+	if len(signers) != 0 || d.Agent != nil {
+		authMethods = append(authMethods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			if d.Agent == nil {
+				return signers, nil
+			}
+			agentSigners, err := d.Agent.Signers()
+			if err != nil {
+				return signers, nil
+			}
+			return append(append([]ssh.Signer(nil), signers...), agentSigners...), nil
+		}))
+	}
+	if machine.HasPassword() {
+		password, err := machine.RevealPassword()
+		if err != nil {
+			return nil, err
+		}
+		authMethods = append(authMethods, ssh.KeyboardInteractive(setKeyboard(password)))
+		authMethods = append(authMethods, ssh.Password(password))
+	}
+	return authMethods, nil
+}
+
+func setKeyboard(password string) func(user, instruction string, questions []string, echos []bool) (answers []string, err error) {
+	return func(_, _ string, questions []string, _ []bool) (answers []string, err error) {
+		answers = make([]string, len(questions))
+		for n := range questions {
+			answers[n] = password
+		}
+		return answers, nil
+	}
+}
@@ -0,0 +1,42 @@
+package adapter
+
+import (
+	"testing"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialJump(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		bastion = newTestServer(t, "jump", "jump-password")
+		target  = newTestServer(t, "root", "root-password")
+		hop     = bastion.machine("jump", "jump-password")
+		machine = target.machine("root", "root-password")
+	)
+	machine.Jump = []string{"1"}
+	machine.JumpChain = []*assets.Machine{hop}
+
+	dialer := newTestDialer(t)
+	client, err := dialer.Dial(machine)
+	assert.Nil(err)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	assert.Nil(err)
+	defer session.Close()
+
+	output, err := session.Output("hostname")
+	assert.Nil(err)
+	assert.Equal("exec: hostname\n", string(output))
+
+	hosts, err := dialer.KnownHosts.List()
+	assert.Nil(err)
+	assert.Len(hosts, 2)
+
+	hop.Password = "wrong"
+	_, err = dialer.Dial(machine)
+	assert.NotNil(err)
+}
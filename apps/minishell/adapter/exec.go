@@ -0,0 +1,155 @@
+package adapter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+	"golang.org/x/crypto/ssh"
+)
+
+var ErrExecTimeout = errors.New("exec timeout")
+
+type ExecOption struct {
+	Concurrency int
+	Timeout     time.Duration
+
+	// Output 返回 machine 的 stdout/stderr 输出目标, 为 nil 时丢弃输出
+	Output func(machine *assets.Machine) (stdout, stderr io.Writer)
+}
+
+type ExecResult struct {
+	Machine  *assets.Machine
+	ExitCode int
+	Duration time.Duration
+	Err      error
+}
+
+// ExecOnMachines 在多台机器上并发执行命令, 结果与 machines 顺序一致
+func ExecOnMachines(dialer *Dialer, machines []*assets.Machine, command string, option ExecOption) []*ExecResult {
+	results := make([]*ExecResult, len(machines))
+	runOnMachines(machines, option.Concurrency, func(i int, machine *assets.Machine) {
+		stdout, stderr := io.Discard, io.Discard
+		if option.Output != nil {
+			stdout, stderr = option.Output(machine)
+		}
+
+		begin := time.Now()
+		code, err := ExecWithSSH(dialer, machine, command, stdout, stderr, option.Timeout)
+		results[i] = &ExecResult{Machine: machine, ExitCode: code, Duration: time.Since(begin), Err: err}
+	})
+	return results
+}
+
+// runOnMachines 以不超过 concurrency 的并发度对每台机器执行 fn
+func runOnMachines(machines []*assets.Machine, concurrency int, fn func(i int, machine *assets.Machine)) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var (
+		sem = make(chan struct{}, concurrency)
+		wg  sync.WaitGroup
+	)
+	for i, machine := range machines {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(i, machine)
+		}()
+	}
+	wg.Wait()
+}
+
+// ExecWithSSH 执行命令并返回退出码; 连接或执行失败时退出码为 -1.
+// timeout 从连接开始计算, 包含连接、重试与执行的时间. 配置了 Become 时以目标用户执行, stderr 合并到 stdout
+func ExecWithSSH(dialer *Dialer, machine *assets.Machine, command string, stdout, stderr io.Writer, timeout time.Duration) (int, error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	type dialed struct {
+		client *ssh.Client
+		err    error
+	}
+	ch := make(chan dialed, 1)
+	go func() {
+		client, err := dialer.Dial(machine)
+		ch <- dialed{client: client, err: err}
+	}()
+
+	var connection *ssh.Client
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return -1, r.err
+		}
+		connection = r.client
+	case <-expired:
+		// 超时后连接成功时直接关闭
+		go func() {
+			if r := <-ch; r.client != nil {
+				r.client.Close()
+			}
+		}()
+		return -1, fmt.Errorf("%w after %v", ErrExecTimeout, timeout)
+	}
+	defer connection.Close()
+
+	var (
+		timedOut atomic.Bool
+		done     = make(chan struct{})
+	)
+	defer close(done)
+	if expired != nil {
+		go func() {
+			select {
+			case <-expired:
+				timedOut.Store(true)
+				connection.Close()
+			case <-done:
+			}
+		}()
+	}
+
+	session, err := dialer.NewSession(connection, machine)
+	if err != nil {
+		if timedOut.Load() {
+			return -1, fmt.Errorf("%w after %v", ErrExecTimeout, timeout)
+		}
+		return -1, err
+	}
+	defer session.Close()
+
+	if machine.Become != nil {
+		err = runBecome(session, machine, command, stdout)
+	} else {
+		session.Stdout = stdout
+		session.Stderr = stderr
+		err = session.Run(command)
+	}
+	if timedOut.Load() {
+		return -1, fmt.Errorf("%w after %v", ErrExecTimeout, timeout)
+	}
+
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		return 0, nil
+	case errors.As(err, &exitErr):
+		return exitErr.ExitStatus(), nil
+	default:
+		return -1, err
+	}
+}
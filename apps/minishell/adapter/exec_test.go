@@ -0,0 +1,71 @@
+package adapter
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecOnMachines(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		server   = newTestServer(t, "root", "root-password")
+		machines = []*assets.Machine{
+			server.machine("root", "root-password"),
+			server.machine("root", "wrong-password"),
+		}
+		outputs = make(map[*assets.Machine]*bytes.Buffer)
+	)
+	for _, machine := range machines {
+		outputs[machine] = &bytes.Buffer{}
+	}
+
+	results := ExecOnMachines(newTestDialer(t), machines, "uptime", ExecOption{
+		Concurrency: 2,
+		Timeout:     5 * time.Second,
+		Output: func(machine *assets.Machine) (io.Writer, io.Writer) {
+			return outputs[machine], io.Discard
+		},
+	})
+	assert.Len(results, 2)
+	assert.Nil(results[0].Err)
+	assert.Equal(0, results[0].ExitCode)
+	assert.Equal("exec: uptime\n", outputs[machines[0]].String())
+	assert.NotNil(results[1].Err)
+	assert.Equal(-1, results[1].ExitCode)
+
+	var stderr bytes.Buffer
+	code, err := ExecWithSSH(newTestDialer(t), machines[0], "exit 3", io.Discard, &stderr, 5*time.Second)
+	assert.Nil(err)
+	assert.Equal(3, code)
+	assert.Equal("exit with 3\n", stderr.String())
+
+	_, err = ExecWithSSH(newTestDialer(t), machines[0], "sleep", io.Discard, io.Discard, 100*time.Millisecond)
+	assert.True(errors.Is(err, ErrExecTimeout))
+
+	// 连接阶段的时间同样计入超时
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	addr := listener.Addr().(*net.TCPAddr)
+	begin := time.Now()
+	_, err = ExecWithSSH(newTestDialer(t), &assets.Machine{IP: "127.0.0.1", Port: addr.Port, Username: "root", Password: "root-password"}, "uptime", io.Discard, io.Discard, 200*time.Millisecond)
+	assert.True(errors.Is(err, ErrExecTimeout))
+	assert.True(time.Since(begin) < 2*time.Second)
+}
@@ -0,0 +1,226 @@
+package adapter
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+	"golang.org/x/crypto/ssh"
+)
+
+// Forward 端口转发规则, Remote 为 false 时等同于 ssh -L, 为 true 时等同于 ssh -R
+type Forward struct {
+	Remote   bool
+	BindAddr string
+	DestAddr string
+}
+
+func (f *Forward) String() string {
+	if f.Remote {
+		return fmt.Sprintf("-R %s -> %s", f.BindAddr, f.DestAddr)
+	}
+	return fmt.Sprintf("-L %s -> %s", f.BindAddr, f.DestAddr)
+}
+
+// ParseForward 解析 [bind_address:]port:host:hostport 格式的转发规则, IPv6 地址需使用 [] 包裹,
+// 未指定 bind_address 时监听 127.0.0.1
+func ParseForward(spec string, remote bool) (*Forward, error) {
+	fields, err := splitForwardSpec(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid forward spec: %q, nest error: %v", spec, err)
+	}
+
+	var bindHost string
+	switch len(fields) {
+	case 3:
+		bindHost = "127.0.0.1"
+	case 4:
+		bindHost, fields = fields[0], fields[1:]
+		if bindHost == "" || bindHost == "*" {
+			bindHost = "0.0.0.0"
+		}
+	default:
+		return nil, fmt.Errorf("invalid forward spec: %q, want [bind_address:]port:host:hostport", spec)
+	}
+
+	for _, port := range []string{fields[0], fields[2]} {
+		if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			return nil, fmt.Errorf("invalid forward spec: %q, bad port: %q", spec, port)
+		}
+	}
+	if fields[1] == "" {
+		return nil, fmt.Errorf("invalid forward spec: %q, empty host", spec)
+	}
+
+	return &Forward{
+		Remote:   remote,
+		BindAddr: net.JoinHostPort(bindHost, fields[0]),
+		DestAddr: net.JoinHostPort(fields[1], fields[2]),
+	}, nil
+}
+
+// splitForwardSpec 以 ':' 分割, 忽略 [] 内的 ':'
+func splitForwardSpec(spec string) ([]string, error) {
+	var (
+		fields  = make([]string, 0, 4)
+		current strings.Builder
+		bracket bool
+	)
+	for _, c := range spec {
+		switch {
+		case c == '[' && !bracket:
+			bracket = true
+		case c == ']' && bracket:
+			bracket = false
+		case c == ':' && !bracket:
+			fields = append(fields, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(c)
+		}
+	}
+	if bracket {
+		return nil, fmt.Errorf("unclosed '['")
+	}
+	return append(fields, current.String()), nil
+}
+
+// Forwarder 保持与 machine 的 SSH 连接(不打开 shell), 并按规则转发连接
+type Forwarder struct {
+	client    *ssh.Client
+	listeners []net.Listener
+	logf      func(format string, args ...interface{})
+
+	closed atomic.Bool
+}
+
+// StartForward 登录 machine 并开始监听所有转发规则, 任一规则监听失败时关闭连接并返回错误.
+// logf 用于记录每条转发连接, 为 nil 时不记录.
+func StartForward(dialer *Dialer, machine *assets.Machine, forwards []*Forward, logf func(format string, args ...interface{})) (*Forwarder, error) {
+	if len(forwards) == 0 {
+		return nil, fmt.Errorf("no forward specified")
+	}
+	if logf == nil {
+		logf = func(string, ...interface{}) {}
+	}
+
+	client, err := dialer.Dial(machine)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Forwarder{client: client, logf: logf}
+	for _, forward := range forwards {
+		var listener net.Listener
+		if forward.Remote {
+			listener, err = client.Listen("tcp", forward.BindAddr)
+		} else {
+			listener, err = net.Listen("tcp", forward.BindAddr)
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("listen failure, nest error: %v, forward: %v", err, forward)
+		}
+		f.listeners = append(f.listeners, listener)
+
+		go f.serve(listener, forward)
+		logf("listen %v", forward)
+	}
+	return f, nil
+}
+
+// Addrs 返回各规则实际监听的地址, 顺序与 forwards 一致
+func (f *Forwarder) Addrs() []net.Addr {
+	addrs := make([]net.Addr, 0, len(f.listeners))
+	for _, listener := range f.listeners {
+		addrs = append(addrs, listener.Addr())
+	}
+	return addrs
+}
+
+// Wait 阻塞至 SSH 连接断开或调用 Close
+func (f *Forwarder) Wait() error {
+	err := f.client.Wait()
+	closed := f.closed.Load()
+	f.Close()
+	if closed {
+		return nil
+	}
+	return err
+}
+
+func (f *Forwarder) Close() error {
+	if !f.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	for _, listener := range f.listeners {
+		listener.Close()
+	}
+	return f.client.Close()
+}
+
+func (f *Forwarder) serve(listener net.Listener, forward *Forward) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !f.closed.Load() {
+				f.logf("accept failure, nest error: %v, forward: %v", err, forward)
+			}
+			return
+		}
+
+		go f.handle(conn, forward)
+	}
+}
+
+func (f *Forwarder) handle(conn net.Conn, forward *Forward) {
+	defer conn.Close()
+
+	var (
+		dest net.Conn
+		err  error
+	)
+	if forward.Remote {
+		dest, err = net.Dial("tcp", forward.DestAddr)
+	} else {
+		dest, err = f.client.Dial("tcp", forward.DestAddr)
+	}
+	if err != nil {
+		f.logf("connect failure, nest error: %v, forward: %v, origin: %v", err, forward, conn.RemoteAddr())
+		return
+	}
+	defer dest.Close()
+
+	f.logf("open %v, origin: %v", forward, conn.RemoteAddr())
+	sent, received := pipe(conn, dest)
+	f.logf("close %v, origin: %v, sent: %d, received: %d", forward, conn.RemoteAddr(), sent, received)
+}
+
+// pipe 双向复制数据直至两个方向都结束, 返回 a->b 与 b->a 的字节数
+func pipe(a, b net.Conn) (int64, int64) {
+	var (
+		sent int64
+		done = make(chan struct{})
+	)
+	go func() {
+		sent, _ = io.Copy(b, a)
+		closeWrite(b)
+		close(done)
+	}()
+	received, _ := io.Copy(a, b)
+	closeWrite(a)
+	<-done
+	return sent, received
+}
+
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+		return
+	}
+	conn.Close()
+}
@@ -0,0 +1,81 @@
+package adapter
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseForward(t *testing.T) {
+	assert := assert.New(t)
+
+	forward, err := ParseForward("5432:db:5432", false)
+	assert.Nil(err)
+	assert.Equal(&Forward{BindAddr: "127.0.0.1:5432", DestAddr: "db:5432"}, forward)
+
+	forward, err = ParseForward("*:8080:[::1]:80", true)
+	assert.Nil(err)
+	assert.Equal(&Forward{Remote: true, BindAddr: "0.0.0.0:8080", DestAddr: "[::1]:80"}, forward)
+
+	for _, spec := range []string{"", "5432", "db:5432", "a:b:c:d:e", "x:db:5432", "5432:db:70000", "[::1:80:db:80"} {
+		_, err := ParseForward(spec, false)
+		assert.NotNil(err, spec)
+	}
+}
+
+func TestForward(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		server  = newTestServer(t, "root", "root-password")
+		_, port = newEchoServer(t)
+		mu      sync.Mutex
+		logs    []string
+	)
+	local, err := ParseForward("0:127.0.0.1:"+port, false)
+	assert.Nil(err)
+	remote, err := ParseForward("0:127.0.0.1:"+port, true)
+	assert.Nil(err)
+
+	forwarder, err := StartForward(newTestDialer(t), server.machine("root", "root-password"), []*Forward{local, remote}, func(format string, args ...interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		logs = append(logs, format)
+	})
+	assert.Nil(err)
+
+	for _, addr := range forwarder.Addrs() {
+		conn, err := net.Dial("tcp", addr.String())
+		assert.Nil(err)
+		_, err = conn.Write([]byte("hello"))
+		assert.Nil(err)
+		conn.(*net.TCPConn).CloseWrite()
+		data, err := io.ReadAll(conn)
+		assert.Nil(err)
+		assert.Equal("hello", string(data), addr.String())
+		conn.Close()
+	}
+
+	forwarder.Close()
+	assert.Nil(forwarder.Wait())
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Contains(logs, "open %v, origin: %v")
+}
+
+func TestForwardConnectionLost(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newTestServer(t, "root", "root-password")
+	forward, err := ParseForward("0:127.0.0.1:22", false)
+	assert.Nil(err)
+	forwarder, err := StartForward(newTestDialer(t), server.machine("root", "root-password"), []*Forward{forward}, nil)
+	assert.Nil(err)
+
+	server.drop()
+	assert.NotNil(forwarder.Wait())
+}
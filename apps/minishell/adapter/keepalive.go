@@ -0,0 +1,81 @@
+package adapter
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrConnectionLost 连接意外断开(网络中断或 keepalive 无响应), 而不是远端正常退出
+var ErrConnectionLost = errors.New("connection lost")
+
+const maxBackoff = 30 * time.Second
+
+// keepalive 每隔 interval 发送 keepalive@openssh.com, 连续 max 次无响应时关闭 client, 与 OpenSSH 的 ServerAliveInterval/ServerAliveCountMax 一致
+func keepalive(client *ssh.Client, interval time.Duration, max int) {
+	if max <= 0 {
+		max = 3
+	}
+
+	done := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var missed int
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		// 不认识该请求的服务端会回复失败, 同样说明连接正常
+		reply := make(chan error, 1)
+		go func() {
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			reply <- err
+		}()
+
+		select {
+		case <-done:
+			return
+		case err := <-reply:
+			if err != nil {
+				client.Close()
+				return
+			}
+			missed = 0
+		case <-time.After(interval):
+			if missed++; missed >= max {
+				client.Close()
+				return
+			}
+		}
+	}
+}
+
+// retryable 网络错误可以重试, 认证失败、host key 校验失败等不重试
+func retryable(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF)
+}
+
+// backoff 第 attempt 次重试前的等待时间, 从 base 开始翻倍, 最长 maxBackoff
+func backoff(base time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		base = time.Second
+	}
+	d := base << attempt
+	if d <= 0 || d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
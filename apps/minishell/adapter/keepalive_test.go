@@ -0,0 +1,125 @@
+package adapter
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newFreezeProxy 转发到 addr 的 tcp 代理, freeze 后丢弃双向数据, 模拟 NAT 超时后的静默断线
+func newFreezeProxy(t *testing.T, addr string) (int, *atomic.Bool) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var frozen atomic.Bool
+	copyUnlessFrozen := func(dst io.Writer, src io.Reader) {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := src.Read(buf)
+			if n > 0 && !frozen.Load() {
+				if _, err := dst.Write(buf[:n]); err != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", addr)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			go func() {
+				copyUnlessFrozen(upstream, conn)
+				upstream.Close()
+			}()
+			go func() {
+				copyUnlessFrozen(conn, upstream)
+				conn.Close()
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port, &frozen
+}
+
+func TestKeepalive(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newTestServer(t, "root", "root-password")
+	port, frozen := newFreezeProxy(t, net.JoinHostPort(server.Host, strconv.Itoa(server.Port)))
+	machine := server.machine("root", "root-password")
+	machine.Port = port
+
+	dialer := newTestDialer(t)
+	dialer.Keepalive, dialer.KeepaliveMax = 100*time.Millisecond, 2
+	client, err := dialer.Dial(machine)
+	assert.Nil(err)
+	defer client.Close()
+
+	// 连接正常时 keepalive 有响应, 不会断开
+	time.Sleep(500 * time.Millisecond)
+	session, err := client.NewSession()
+	assert.Nil(err)
+	output, err := session.Output("uptime")
+	assert.Nil(err)
+	assert.Equal("exec: uptime\n", string(output))
+
+	frozen.Store(true)
+	closed := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("client not closed after keepalive timeout")
+	}
+}
+
+func TestDialRetry(t *testing.T) {
+	assert := assert.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	server := newTestServer(t, "root", "root-password")
+	machine := server.machine("root", "root-password")
+	machine.Port = port
+
+	dialer := newTestDialer(t)
+	dialer.Retries, dialer.Backoff = 2, 300*time.Millisecond
+	begin := time.Now()
+	_, err = dialer.Dial(machine)
+	assert.NotNil(err)
+	assert.GreaterOrEqual(time.Since(begin), 900*time.Millisecond)
+
+	// 认证失败不重试
+	machine = server.machine("root", "wrong-password")
+	begin = time.Now()
+	_, err = dialer.Dial(machine)
+	assert.NotNil(err)
+	assert.Less(time.Since(begin), 300*time.Millisecond)
+
+	assert.Equal(time.Second, backoff(0, 0))
+	assert.Equal(4*time.Second, backoff(time.Second, 2))
+	assert.Equal(maxBackoff, backoff(time.Second, 10))
+}
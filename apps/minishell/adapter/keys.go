@@ -0,0 +1,222 @@
+package adapter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+	"golang.org/x/crypto/ssh"
+)
+
+// keyCache 缓存解析后的私钥, 加密的私钥在一次运行中只需输入一次密码.
+// 解析成功的私钥按路径缓存; 失败按路径与密码来源缓存, 避免一台 machine 错误的 passphrase 影响其它 machine
+type keyCache struct {
+	mu      sync.Mutex
+	signers map[string]ssh.Signer
+	errs    map[string]error
+}
+
+// passphraseSource 返回 machine 私钥密码的来源, 用于区分缓存的错误; 私钥密码只保存摘要
+func passphraseSource(machine *assets.Machine) (string, error) {
+	switch {
+	case machine.HasPassphrase():
+		passphrase, err := machine.RevealPassphrase()
+		if err != nil {
+			return "", err
+		}
+		sum := sha256.Sum256([]byte(passphrase))
+		return "passphrase\x00" + hex.EncodeToString(sum[:]), nil
+	case machine.PassphraseCommand != "" && machine.PassphraseCommand != assets.NotExist:
+		return "passphrase-command\x00" + machine.PassphraseCommand, nil
+	default:
+		return "prompt", nil
+	}
+}
+
+// signer 读取 machine 的私钥; 私钥有密码时依次使用 passphrase、passphrase-command、PassphrasePrompt 获取密码
+func (d *Dialer) signer(machine *assets.Machine) (ssh.Signer, error) {
+	path := assets.ExpandHome(machine.PrivateKeyPath)
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	// 加锁期间可能在终端等待输入, 并发登录多台机器时只提示一次
+	d.keys.mu.Lock()
+	defer d.keys.mu.Unlock()
+	if signer, ok := d.keys.signers[path]; ok {
+		return signer, nil
+	}
+	source, err := passphraseSource(machine)
+	if err != nil {
+		return nil, err
+	}
+	errKey := path + "\x00" + source
+	if err, ok := d.keys.errs[errKey]; ok {
+		return nil, err
+	}
+
+	signer, err := d.parsePrivateKey(path, machine)
+	if d.keys.signers == nil {
+		d.keys.signers, d.keys.errs = make(map[string]ssh.Signer), make(map[string]error)
+	}
+	if err != nil {
+		// 终端输入的密码不缓存失败, 下一台 machine 重新提示
+		if source != "prompt" {
+			d.keys.errs[errKey] = err
+		}
+		return nil, err
+	}
+	d.keys.signers[path] = signer
+	return signer, nil
+}
+
+func (d *Dialer) parsePrivateKey(path string, machine *assets.Machine) (ssh.Signer, error) {
+	pk, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(pk)
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return signer, err
+	}
+
+	var passphrase []byte
+	switch {
+	case machine.HasPassphrase():
+		revealed, err := machine.RevealPassphrase()
+		if err != nil {
+			return nil, err
+		}
+		passphrase = []byte(revealed)
+	case machine.PassphraseCommand != "" && machine.PassphraseCommand != assets.NotExist:
+		if passphrase, err = runPassphraseCommand(machine.PassphraseCommand); err != nil {
+			return nil, err
+		}
+	case d.PassphrasePrompt != nil:
+		return d.promptPrivateKey(path, pk)
+	default:
+		return nil, fmt.Errorf("private key is encrypted, passphrase required, path: %v", path)
+	}
+	defer func() {
+		for i := range passphrase {
+			passphrase[i] = 0
+		}
+	}()
+
+	signer, err = ssh.ParsePrivateKeyWithPassphrase(pk, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt private key failure, nest error: %v, path: %v", err, path)
+	}
+	return signer, nil
+}
+
+// passphrasePrompts 终端输入私钥密码的次数, 与 OpenSSH 的 NumberOfPasswordPrompts 默认值一致
+const passphrasePrompts = 3
+
+// promptPrivateKey 在终端输入私钥密码, 密码错误时重新提示
+func (d *Dialer) promptPrivateKey(path string, pk []byte) (ssh.Signer, error) {
+	var err error
+	for i := 0; i < passphrasePrompts; i++ {
+		prompt := fmt.Sprintf("Enter passphrase for key '%s': ", path)
+		if i != 0 {
+			prompt = fmt.Sprintf("Bad passphrase, try again for key '%s': ", path)
+		}
+		passphrase, perr := d.PassphrasePrompt(prompt)
+		if perr != nil {
+			return nil, perr
+		}
+
+		var signer ssh.Signer
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(pk, passphrase)
+		for i := range passphrase {
+			passphrase[i] = 0
+		}
+		if err == nil {
+			return signer, nil
+		}
+		if !errors.Is(err, x509.IncorrectPasswordError) {
+			break
+		}
+	}
+	return nil, fmt.Errorf("decrypt private key failure, nest error: %v, path: %v", err, path)
+}
+
+// certSigner 将私钥与 machine 的用户证书组合为 ssh.CertSigner. 未配置 certificate 时使用私钥路径加 -cert.pub 的文件,
+// 该文件不存在或无效时返回 nil
+func certSigner(machine *assets.Machine, signer ssh.Signer) (ssh.Signer, error) {
+	path, explicit := machine.Certificate, true
+	if path == "" || path == assets.NotExist {
+		path, explicit = machine.PrivateKeyPath+"-cert.pub", false
+	}
+	path = assets.ExpandHome(path)
+	if _, err := os.Stat(path); !explicit && err != nil {
+		return nil, nil
+	}
+
+	cert, err := parseUserCert(path, signer, time.Now())
+	if err != nil {
+		if !explicit {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("load certificate failure, nest error: %v, path: %v", err, path)
+	}
+	return ssh.NewCertSigner(cert, signer)
+}
+
+func parseUserCert(path string, signer ssh.Signer, now time.Time) (*ssh.Certificate, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(buf)
+	if err != nil {
+		return nil, err
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("not a certificate, type: %s", pub.Type())
+	}
+	if cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("not a user certificate")
+	}
+	if !bytes.Equal(cert.Key.Marshal(), signer.PublicKey().Marshal()) {
+		return nil, fmt.Errorf("certificate does not match the private key")
+	}
+	if unix := uint64(now.Unix()); unix < cert.ValidAfter {
+		return nil, fmt.Errorf("certificate is not yet valid, valid after: %v", time.Unix(int64(cert.ValidAfter), 0).Format(time.DateTime))
+	}
+	if unix := uint64(now.Unix()); cert.ValidBefore != ssh.CertTimeInfinity && unix >= cert.ValidBefore {
+		return nil, fmt.Errorf("certificate has expired, valid before: %v", time.Unix(int64(cert.ValidBefore), 0).Format(time.DateTime))
+	}
+	return cert, nil
+}
+
+// runPassphraseCommand 执行命令, 以标准输出的第一行作为密码
+func runPassphraseCommand(command string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("run passphrase-command failure, nest error: %v, stderr: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if i := bytes.IndexByte(output, '\n'); i >= 0 {
+		output = output[:i]
+	}
+	output = bytes.TrimSuffix(output, []byte("\r"))
+	if len(output) == 0 {
+		return nil, fmt.Errorf("passphrase-command returned empty output")
+	}
+	return output, nil
+}
@@ -0,0 +1,187 @@
+package adapter
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestEncryptedPrivateKey(t *testing.T) {
+	assert := assert.New(t)
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(err)
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("secret"))
+	assert.Nil(err)
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	assert.Nil(os.WriteFile(path, pem.EncodeToMemory(block), 0o600))
+	signer, err := ssh.NewSignerFromKey(priv)
+	assert.Nil(err)
+
+	server := newTestServer(t, "root", "root-password")
+	server.AuthorizedKeys = []ssh.PublicKey{signer.PublicKey()}
+	newMachine := func() *assets.Machine {
+		machine := server.machine("root", "")
+		machine.PrivateKeyPath = path
+		return machine
+	}
+
+	// 没有密码来源时返回错误
+	_, err = newTestDialer(t).Dial(newMachine())
+	assert.NotNil(err)
+
+	// 并发登录多台机器只提示一次
+	var (
+		prompts  atomic.Int32
+		dialer   = newTestDialer(t)
+		machines = []*assets.Machine{newMachine(), newMachine(), newMachine()}
+	)
+	dialer.PassphrasePrompt = func(string) ([]byte, error) {
+		prompts.Add(1)
+		return []byte("secret"), nil
+	}
+	results := ExecOnMachines(dialer, machines, "uptime", ExecOption{Concurrency: 3, Timeout: 5 * time.Second})
+	for _, result := range results {
+		assert.Nil(result.Err)
+	}
+	assert.Equal(int32(1), prompts.Load())
+
+	machine := newMachine()
+	machine.PassphraseCommand = "echo secret"
+	_, err = ExecWithSSH(newTestDialer(t), machine, "uptime", nil, nil, 5*time.Second)
+	assert.Nil(err)
+
+	machine = newMachine()
+	machine.Passphrase = "wrong"
+	_, err = newTestDialer(t).Dial(machine)
+	assert.NotNil(err)
+}
+
+func TestUserCertificate(t *testing.T) {
+	assert := assert.New(t)
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(err)
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	assert.Nil(err)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "id_ed25519")
+	assert.Nil(os.WriteFile(path, pem.EncodeToMemory(block), 0o600))
+	signer, err := ssh.NewSignerFromKey(priv)
+	assert.Nil(err)
+
+	ca := newTestSigner(t)
+	server := newTestServer(t, "root", "root-password")
+	server.UserCA = ca.PublicKey()
+	writeCert := func(name string, cert *ssh.Certificate) string {
+		certPath := filepath.Join(dir, name)
+		assert.Nil(os.WriteFile(certPath, ssh.MarshalAuthorizedKey(cert), 0o600))
+		return certPath
+	}
+	newMachine := func(certificate string) *assets.Machine {
+		machine := server.machine("root", "")
+		machine.PrivateKeyPath = path
+		machine.Certificate = certificate
+		return machine
+	}
+
+	// 私钥未被信任, 未配置证书时无法登录
+	_, err = newTestDialer(t).Dial(newMachine(""))
+	assert.NotNil(err)
+
+	// 默认使用私钥路径加 -cert.pub 的证书
+	writeCert("id_ed25519-cert.pub", newTestCert(t, ca, signer.PublicKey(), ssh.UserCert, []string{"root"}, ssh.CertTimeInfinity))
+	client, err := newTestDialer(t).Dial(newMachine(""))
+	assert.Nil(err)
+	if client != nil {
+		client.Close()
+	}
+
+	// 证书中没有登录用户
+	other := writeCert("other-cert.pub", newTestCert(t, ca, signer.PublicKey(), ssh.UserCert, []string{"oracle"}, ssh.CertTimeInfinity))
+	_, err = newTestDialer(t).Dial(newMachine(other))
+	assert.NotNil(err)
+
+	expired := writeCert("expired-cert.pub", newTestCert(t, ca, signer.PublicKey(), ssh.UserCert, []string{"root"}, uint64(time.Now().Add(-time.Hour).Unix())))
+	_, err = newTestDialer(t).Dial(newMachine(expired))
+	assert.ErrorContains(err, "expired")
+
+	mismatch := writeCert("mismatch-cert.pub", newTestCert(t, ca, newTestSigner(t).PublicKey(), ssh.UserCert, []string{"root"}, ssh.CertTimeInfinity))
+	_, err = newTestDialer(t).Dial(newMachine(mismatch))
+	assert.ErrorContains(err, "does not match")
+}
+
+func TestPrivateKeyCacheByPassphrase(t *testing.T) {
+	assert := assert.New(t)
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(err)
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("secret"))
+	assert.Nil(err)
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	assert.Nil(os.WriteFile(path, pem.EncodeToMemory(block), 0o600))
+
+	var (
+		dialer = newTestDialer(t)
+		stale  = &assets.Machine{PrivateKeyPath: path, Passphrase: "stale"}
+		good   = &assets.Machine{PrivateKeyPath: path, PassphraseCommand: "echo secret"}
+	)
+	_, err = dialer.signer(stale)
+	assert.NotNil(err)
+	_, err = dialer.signer(good)
+	assert.Nil(err)
+	_, err = dialer.signer(stale)
+	assert.Nil(err)
+}
+
+func TestPrivateKeyPromptRetry(t *testing.T) {
+	assert := assert.New(t)
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(err)
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("secret"))
+	assert.Nil(err)
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	assert.Nil(os.WriteFile(path, pem.EncodeToMemory(block), 0o600))
+
+	// 输入错误时重新提示
+	var (
+		dialer  = newTestDialer(t)
+		answers = []string{"typo", "secret"}
+		prompts []string
+	)
+	dialer.PassphrasePrompt = func(prompt string) ([]byte, error) {
+		prompts = append(prompts, prompt)
+		answer := answers[0]
+		answers = answers[1:]
+		return []byte(answer), nil
+	}
+	_, err = dialer.signer(&assets.Machine{PrivateKeyPath: path})
+	assert.Nil(err)
+	if assert.Len(prompts, 2) {
+		assert.Contains(prompts[1], "Bad passphrase")
+	}
+
+	// 多次输入错误后返回错误, 失败不缓存, 下一台 machine 重新提示
+	var count int
+	dialer = newTestDialer(t)
+	dialer.PassphrasePrompt = func(string) ([]byte, error) {
+		count++
+		return []byte("typo"), nil
+	}
+	_, err = dialer.signer(&assets.Machine{PrivateKeyPath: path})
+	assert.NotNil(err)
+	assert.Equal(passphrasePrompts, count)
+	_, err = dialer.signer(&assets.Machine{PrivateKeyPath: path})
+	assert.NotNil(err)
+	assert.Equal(2*passphrasePrompts, count)
+}
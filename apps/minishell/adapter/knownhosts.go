@@ -0,0 +1,421 @@
+package adapter
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eviltomorrow/toolbox/lib/fs"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+type HostKeyPolicy int
+
+const (
+	// HostKeyAsk 未知主机时询问用户是否信任(trust on first use)
+	HostKeyAsk HostKeyPolicy = iota
+	// HostKeyAcceptNew 未知主机时直接记录
+	HostKeyAcceptNew
+	// HostKeyStrict 未知主机时拒绝登录
+	HostKeyStrict
+)
+
+type KnownHosts struct {
+	Path   string
+	Policy HostKeyPolicy
+	Prompt func(question string) (bool, error)
+
+	mu sync.Mutex
+}
+
+type KnownHost struct {
+	Line    int
+	Marker  string
+	Hosts   []string
+	Key     ssh.PublicKey
+	Comment string
+}
+
+type HostKeyChangedError struct {
+	Host string
+	Key  ssh.PublicKey
+	Want []knownhosts.KnownKey
+}
+
+func (e *HostKeyChangedError) Error() string {
+	var buf bytes.Buffer
+	buf.WriteString("\r\n@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\r\n")
+	buf.WriteString("@    WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED!     @\r\n")
+	buf.WriteString("@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\r\n")
+	buf.WriteString("IT IS POSSIBLE THAT SOMEONE IS DOING SOMETHING NASTY!\r\n")
+	buf.WriteString(fmt.Sprintf("The %s host key for %s has fingerprint %s.\r\n", e.Key.Type(), e.Host, ssh.FingerprintSHA256(e.Key)))
+	for _, want := range e.Want {
+		buf.WriteString(fmt.Sprintf("Known %s key in %s:%d, fingerprint %s.\r\n", want.Key.Type(), want.Filename, want.Line, ssh.FingerprintSHA256(want.Key)))
+	}
+	buf.WriteString(fmt.Sprintf("Run `minishell hostkey forget %s` if the change is expected.", e.Host))
+	return buf.String()
+}
+
+func OpenKnownHosts(path string) (*KnownHosts, error) {
+	if err := fs.MkdirAll(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	return &KnownHosts{Path: path, Policy: HostKeyAsk, Prompt: PromptYesNo}, nil
+}
+
+func (k *KnownHosts) HostKeyCallback() ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		k.mu.Lock()
+		defer k.mu.Unlock()
+
+		callback, err := knownhosts.New(k.Path)
+		if err != nil {
+			return err
+		}
+		err = callback(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+		// 证书不是由 @cert-authority 信任的 CA 签发或校验失败时, 与 OpenSSH 一样按证书中的 host key 校验
+		var revoked *knownhosts.RevokedError
+		if cert, ok := key.(*ssh.Certificate); ok && !errors.As(err, &revoked) {
+			key = cert.Key
+			if err = callback(hostname, remote, key); err == nil {
+				return nil
+			}
+		}
+
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+		if len(keyErr.Want) != 0 {
+			return &HostKeyChangedError{Host: knownhosts.Normalize(hostname), Key: key, Want: keyErr.Want}
+		}
+
+		switch k.Policy {
+		case HostKeyStrict:
+			return fmt.Errorf("host key verification failed, unknown host: %s, fingerprint: %s", knownhosts.Normalize(hostname), ssh.FingerprintSHA256(key))
+
+		case HostKeyAsk:
+			question := fmt.Sprintf("The authenticity of host '%s' can't be established.\r\n%s key fingerprint is %s.\r\nAre you sure you want to continue connecting (yes/no)? ", knownhosts.Normalize(hostname), key.Type(), ssh.FingerprintSHA256(key))
+			ok, err := k.Prompt(question)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("host key verification failed, host: %s", knownhosts.Normalize(hostname))
+			}
+		}
+		return k.add(hostname, key, false)
+	}
+}
+
+// HostKeyAlgorithms 返回已记录的 host key 类型, 避免服务端优先使用其它类型的 key 被误判为变更.
+// 记录了 @cert-authority 时同时返回对应的证书类型, 证书不被信任时按已记录的 key 校验
+func (k *KnownHosts) HostKeyAlgorithms(hostname string) []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	callback, err := knownhosts.New(k.Path)
+	if err != nil {
+		return nil
+	}
+
+	var keyErr *knownhosts.KeyError
+	if err := callback(hostname, &net.TCPAddr{IP: net.IPv4zero}, placeholderKey{}); !errors.As(err, &keyErr) || len(keyErr.Want) == 0 {
+		return nil
+	}
+
+	var (
+		certs, keys []string
+		trustCA     = hasCertAuthority(k.Path)
+	)
+	for _, want := range keyErr.Want {
+		switch want.Key.Type() {
+		case ssh.KeyAlgoRSA:
+			if trustCA {
+				certs = append(certs, ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSAv01)
+			}
+			keys = append(keys, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		default:
+			if cert, ok := certAlgorithms[want.Key.Type()]; ok && trustCA {
+				certs = append(certs, cert)
+			}
+			keys = append(keys, want.Key.Type())
+		}
+	}
+	return append(certs, keys...)
+}
+
+func (k *KnownHosts) List() ([]*KnownHost, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	buf, err := os.ReadFile(k.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		hosts   = make([]*KnownHost, 0, 32)
+		scanner = bufio.NewScanner(bytes.NewReader(buf))
+		lineNum int
+	)
+	for scanner.Scan() {
+		lineNum++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		marker, patterns, key, comment, _, err := ssh.ParseKnownHosts(line)
+		if err != nil {
+			return nil, fmt.Errorf("parse known_hosts failure, nest error: %v, path: %v, line: %d", err, k.Path, lineNum)
+		}
+		hosts = append(hosts, &KnownHost{Line: lineNum, Marker: marker, Hosts: patterns, Key: key, Comment: comment})
+	}
+	return hosts, scanner.Err()
+}
+
+var certAlgorithms = map[string]string{
+	ssh.KeyAlgoDSA:        ssh.CertAlgoDSAv01,
+	ssh.KeyAlgoECDSA256:   ssh.CertAlgoECDSA256v01,
+	ssh.KeyAlgoECDSA384:   ssh.CertAlgoECDSA384v01,
+	ssh.KeyAlgoECDSA521:   ssh.CertAlgoECDSA521v01,
+	ssh.KeyAlgoSKECDSA256: ssh.CertAlgoSKECDSA256v01,
+	ssh.KeyAlgoED25519:    ssh.CertAlgoED25519v01,
+	ssh.KeyAlgoSKED25519:  ssh.CertAlgoSKED25519v01,
+}
+
+func hasCertAuthority(path string) bool {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	for _, line := range bytes.Split(buf, []byte("\n")) {
+		if bytes.HasPrefix(bytes.TrimSpace(line), []byte("@cert-authority")) {
+			return true
+		}
+	}
+	return false
+}
+
+// TrustCA 以 @cert-authority 记录 host CA, 由该 CA 签发的 host 证书在匹配 patterns(如 *.example.com)的主机上被信任
+func (k *KnownHosts) TrustCA(patterns []string, key ssh.PublicKey) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if len(patterns) == 0 {
+		return fmt.Errorf("no host pattern")
+	}
+	if _, ok := key.(*ssh.Certificate); ok {
+		return fmt.Errorf("a certificate can not be used as a host CA")
+	}
+
+	f, err := os.OpenFile(k.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString("@cert-authority " + knownhosts.Line(patterns, key) + "\n")
+	return err
+}
+
+// Pin 记录指定主机的 host key, 并替换该主机已有的记录
+func (k *KnownHosts) Pin(hostname string, key ssh.PublicKey, hash bool) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, err := k.remove(hostname); err != nil {
+		return err
+	}
+	return k.add(hostname, key, hash)
+}
+
+// Forget 删除指定主机的所有记录, 返回删除的条数
+func (k *KnownHosts) Forget(hostname string) (int, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.remove(hostname)
+}
+
+func (k *KnownHosts) add(hostname string, key ssh.PublicKey, hash bool) error {
+	// 证书有有效期, 记录证书中的 host key
+	if cert, ok := key.(*ssh.Certificate); ok {
+		key = cert.Key
+	}
+
+	host := knownhosts.Normalize(hostname)
+	if hash {
+		host = knownhosts.HashHostname(host)
+	}
+
+	f, err := os.OpenFile(k.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(knownhosts.Line([]string{host}, key) + "\n")
+	return err
+}
+
+func (k *KnownHosts) remove(hostname string) (int, error) {
+	buf, err := os.ReadFile(k.Path)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		host    = knownhosts.Normalize(hostname)
+		removed int
+		data    bytes.Buffer
+		scanner = bufio.NewScanner(bytes.NewReader(buf))
+	)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		rest, ok := removeKnownHostPattern(bytes.TrimSpace(line), host)
+		if !ok {
+			data.Write(line)
+			data.WriteByte('\n')
+			continue
+		}
+		removed++
+		if rest != "" {
+			data.WriteString(rest)
+			data.WriteByte('\n')
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+
+	tmp := fmt.Sprintf("%s.%d", k.Path, time.Now().UnixNano())
+	if err := os.WriteFile(tmp, data.Bytes(), 0o600); err != nil {
+		return 0, err
+	}
+	return removed, os.Rename(tmp, k.Path)
+}
+
+// removeKnownHostPattern 从记录中删除与 host 匹配的主机名, 返回删除后的记录, 没有剩余主机名时返回空.
+// 记录中没有与 host 匹配的主机名时返回 false
+func removeKnownHostPattern(line []byte, host string) (string, bool) {
+	if len(line) == 0 || line[0] == '#' {
+		return "", false
+	}
+	// Pin、Forget 只处理主机自身的记录, 保留 @cert-authority
+	marker, patterns, _, _, _, err := ssh.ParseKnownHosts(line)
+	if err != nil || marker == "cert-authority" {
+		return "", false
+	}
+
+	kept := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if pattern != host && !matchHashedHost(pattern, host) {
+			kept = append(kept, pattern)
+		}
+	}
+	if len(kept) == len(patterns) {
+		return "", false
+	}
+	if len(kept) == 0 {
+		return "", true
+	}
+
+	fields := strings.Fields(string(line))
+	i := 0
+	if strings.HasPrefix(fields[0], "@") {
+		i = 1
+	}
+	fields[i] = strings.Join(kept, ",")
+	return strings.Join(fields, " "), true
+}
+
+// matchHashedHost 匹配 OpenSSH HashKnownHosts 格式: |1|base64(salt)|base64(hmac-sha1(salt, host))
+func matchHashedHost(pattern, host string) bool {
+	parts := strings.Split(pattern, "|")
+	if len(parts) != 4 || parts[0] != "" || parts[1] != "1" {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+var errHostKeyScanned = errors.New("host key scanned")
+
+type placeholderKey struct{}
+
+func (placeholderKey) Type() string                            { return "placeholder" }
+func (placeholderKey) Marshal() []byte                         { return []byte("placeholder") }
+func (placeholderKey) Verify(_ []byte, _ *ssh.Signature) error { return errors.New("placeholder") }
+
+// PromptYesNo 在终端提问, 直到输入 yes/y 或 no/n
+func PromptYesNo(question string) (bool, error) {
+	for {
+		fmt.Print(question)
+		answer, err := readLine(stdin())
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "yes", "y":
+			return true, nil
+		case "no", "n":
+			return false, nil
+		}
+		question = "Please type 'yes' or 'no': "
+	}
+}
+
+// readLine 逐字节读取, 避免缓冲多读的数据在进入 raw 模式后丢失
+func readLine(r io.Reader) (string, error) {
+	var (
+		buf = make([]byte, 0, 16)
+		b   = make([]byte, 1)
+	)
+	for {
+		n, err := r.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				return string(buf), nil
+			}
+			buf = append(buf, b[0])
+		}
+		if err != nil {
+			return string(buf), err
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package adapter
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestKnownHostsCallback(t *testing.T) {
+	assert := assert.New(t)
+
+	knownHosts, err := OpenKnownHosts(filepath.Join(t.TempDir(), "var", "known_hosts"))
+	assert.Nil(err)
+
+	var (
+		remote   = &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 2222}
+		key      = newTestHostKey(t)
+		callback = knownHosts.HostKeyCallback()
+	)
+
+	knownHosts.Policy = HostKeyStrict
+	assert.NotNil(callback("10.0.0.1:2222", remote, key))
+
+	var asked int
+	knownHosts.Policy = HostKeyAsk
+	knownHosts.Prompt = func(string) (bool, error) {
+		asked++
+		return asked > 1, nil
+	}
+	assert.NotNil(callback("10.0.0.1:2222", remote, key))
+	assert.Nil(callback("10.0.0.1:2222", remote, key))
+	assert.Nil(callback("10.0.0.1:2222", remote, key))
+	assert.Equal(2, asked)
+
+	var changed *HostKeyChangedError
+	err = callback("10.0.0.1:2222", remote, newTestHostKey(t))
+	assert.True(errors.As(err, &changed))
+	assert.Equal("[10.0.0.1]:2222", changed.Host)
+
+	hosts, err := knownHosts.List()
+	assert.Nil(err)
+	assert.Len(hosts, 1)
+	assert.Equal([]string{"[10.0.0.1]:2222"}, hosts[0].Hosts)
+	assert.Equal([]string{ssh.KeyAlgoED25519}, knownHosts.HostKeyAlgorithms("10.0.0.1:2222"))
+}
+
+func TestKnownHostsHashed(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	key := newTestHostKey(t)
+	line := knownhosts.Line([]string{knownhosts.HashHostname("[10.0.0.2]:2222")}, key) + "\n" +
+		knownhosts.Line([]string{"10.0.0.3"}, key) + "\n"
+	assert.Nil(os.WriteFile(path, []byte(line), 0o600))
+
+	knownHosts, err := OpenKnownHosts(path)
+	assert.Nil(err)
+	knownHosts.Policy = HostKeyStrict
+
+	callback := knownHosts.HostKeyCallback()
+	assert.Nil(callback("10.0.0.2:2222", &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 2222}, key))
+	assert.NotNil(callback("10.0.0.2:22", &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 22}, key))
+
+	n, err := knownHosts.Forget("10.0.0.2:2222")
+	assert.Nil(err)
+	assert.Equal(1, n)
+
+	assert.Nil(knownHosts.Pin("10.0.0.3", newTestHostKey(t), true))
+	hosts, err := knownHosts.List()
+	assert.Nil(err)
+	assert.Len(hosts, 1)
+	assert.True(matchHashedHost(hosts[0].Hosts[0], "10.0.0.3"))
+
+	// 多个主机名的记录只删除匹配的主机名
+	assert.Nil(os.WriteFile(path, []byte(knownhosts.Line([]string{"10.0.0.4", "10.0.0.5", knownhosts.HashHostname("10.0.0.6")}, key)+" ops@example\n"), 0o600))
+	n, err = knownHosts.Forget("10.0.0.4")
+	assert.Nil(err)
+	assert.Equal(1, n)
+	n, err = knownHosts.Forget("10.0.0.6")
+	assert.Nil(err)
+	assert.Equal(1, n)
+	hosts, err = knownHosts.List()
+	assert.Nil(err)
+	assert.Len(hosts, 1)
+	assert.Equal([]string{"10.0.0.5"}, hosts[0].Hosts)
+	assert.Equal("ops@example", hosts[0].Comment)
+	assert.Nil(callback("10.0.0.5:22", &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 22}, key))
+	assert.NotNil(callback("10.0.0.4:22", &net.TCPAddr{IP: net.ParseIP("10.0.0.4"), Port: 22}, key))
+
+	n, err = knownHosts.Forget("10.0.0.5")
+	assert.Nil(err)
+	assert.Equal(1, n)
+	hosts, err = knownHosts.List()
+	assert.Nil(err)
+	assert.Empty(hosts)
+}
+
+func TestKnownHostsCertAuthority(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		ca     = newTestSigner(t)
+		server = newTestServer(t, "root", "root-password")
+		dialer = newTestDialer(t)
+	)
+	server.useHostCert(t, ca)
+	dialer.KnownHosts.Policy = HostKeyStrict
+
+	// 未信任 CA 时按证书中的 host key 校验, 未知主机被拒绝
+	_, err := dialer.Dial(server.machine("root", "root-password"))
+	assert.NotNil(err)
+
+	assert.Nil(dialer.KnownHosts.TrustCA([]string{fmt.Sprintf("[127.0.0.*]:%d", server.Port)}, ca.PublicKey()))
+	client, err := dialer.Dial(server.machine("root", "root-password"))
+	assert.Nil(err)
+	if client != nil {
+		client.Close()
+	}
+
+	hosts, err := dialer.KnownHosts.List()
+	assert.Nil(err)
+	assert.Len(hosts, 1)
+	assert.Equal("cert-authority", hosts[0].Marker)
+
+	// 不受信任的证书按已记录的 host key 校验, Pin 不删除 CA 记录, 记录证书中的 key
+	untrusted := newTestServer(t, "root", "root-password")
+	untrusted.useHostCert(t, newTestSigner(t))
+	addr := untrusted.machine("root", "").Addr()
+	cert := newTestCert(t, newTestSigner(t), untrusted.hostKey.PublicKey(), ssh.HostCert, nil, ssh.CertTimeInfinity)
+	assert.Nil(dialer.KnownHosts.Pin(addr, cert, false))
+	assert.Equal([]string{ssh.CertAlgoED25519v01, ssh.KeyAlgoED25519}, dialer.KnownHosts.HostKeyAlgorithms(addr))
+	client, err = dialer.Dial(untrusted.machine("root", "root-password"))
+	assert.Nil(err)
+	if client != nil {
+		client.Close()
+	}
+
+	hosts, err = dialer.KnownHosts.List()
+	assert.Nil(err)
+	assert.Len(hosts, 2)
+	assert.Equal(ssh.KeyAlgoED25519, hosts[1].Key.Type())
+}
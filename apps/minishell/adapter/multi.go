@@ -0,0 +1,535 @@
+package adapter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// multiPrefix 多会话模式的前缀键 Ctrl+], 之后的一个按键为命令
+const multiPrefix = 0x1d
+
+// multiHistorySize 每个会话保留的最近输出, 切换时重放
+const multiHistorySize = 64 * 1024
+
+// MultiHelpText 多会话模式的按键说明
+const MultiHelpText = "Ctrl+] n/p: next/previous host, Ctrl+] 1-9: switch to host N, Ctrl+] b: toggle broadcast, " +
+	"Ctrl+] l: list hosts, Ctrl+] q: close all, Ctrl+] Ctrl+]: send Ctrl+]"
+
+type MultiOption struct {
+	Concurrency int
+	// LogDir 每台 machine 的输出记录在 LogDir/<user>@<ip>-<port>.log, 为空时不记录
+	LogDir string
+	// Steps 返回 machine 登录后执行的步骤, 为 nil 时不执行
+	Steps func(machine *assets.Machine) ([]*assets.Step, error)
+}
+
+type MultiResult struct {
+	Machine *assets.Machine
+	LogPath string
+	// Start 开始登录的时间, End 会话结束或登录失败的时间
+	Start time.Time
+	End   time.Time
+	Err   error
+}
+
+type writerFunc func(b []byte) (int, error)
+
+func (f writerFunc) Write(b []byte) (int, error) {
+	return f(b)
+}
+
+// pane 多会话模式中的一个会话
+type pane struct {
+	machine *assets.Machine
+	stdin   io.Writer
+	resize  func(width, height int)
+	close   func() error
+	// waitClose 等待会话结束并关闭连接
+	waitClose func() error
+	log       io.WriteCloser
+	logPath   string
+
+	history []byte
+	watcher *promptWatcher
+	closed  bool
+	// quit 由 closeAll 主动关闭
+	quit bool
+	end  time.Time
+	err  error
+}
+
+// multiplexer 管理多个会话: 只显示当前会话的输出, 其它会话的输出保留在 history 中; 输入发送到当前会话或全部会话
+type multiplexer struct {
+	out io.Writer
+
+	mu        sync.Mutex
+	panes     []*pane
+	focus     int
+	broadcast bool
+	alive     int
+	done      chan struct{}
+}
+
+func newMultiplexer(out io.Writer, panes []*pane) *multiplexer {
+	return &multiplexer{out: out, panes: panes, alive: len(panes), done: make(chan struct{})}
+}
+
+// paneWriter 返回写入 p 输出的 writer
+func (m *multiplexer) paneWriter(p *pane) io.Writer {
+	return writerFunc(func(b []byte) (int, error) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		if p.log != nil {
+			p.log.Write(b)
+		}
+		if p.watcher != nil {
+			p.watcher.Write(b)
+		}
+		p.history = append(p.history, b...)
+		if len(p.history) > multiHistorySize {
+			// 从换行处截断, 减少重放时不完整的控制序列
+			history := p.history[len(p.history)-multiHistorySize:]
+			if i := bytes.IndexByte(history, '\n'); i >= 0 {
+				history = history[i+1:]
+			}
+			p.history = append(p.history[:0], history...)
+		}
+		if len(m.panes) != 0 && m.panes[m.focus] == p {
+			m.out.Write(b)
+		}
+		return len(b), nil
+	})
+}
+
+// tabs 标签栏: 当前会话以 [] 标记, 已关闭的会话以 x 标记
+func (m *multiplexer) tabs() string {
+	var b strings.Builder
+	b.WriteString("\x1b[7m minishell multi ")
+	for i, p := range m.panes {
+		name := fmt.Sprintf("%d:%s", i+1, p.machine.Host())
+		if p.closed {
+			name += "(x)"
+		}
+		if i == m.focus {
+			name = "[" + name + "]"
+		}
+		b.WriteString(" " + name)
+	}
+	if m.broadcast {
+		b.WriteString("  broadcast: ON")
+	} else {
+		b.WriteString("  broadcast: off")
+	}
+	b.WriteString("  (Ctrl+] ? for help) \x1b[0m\r\n")
+	return b.String()
+}
+
+// show 清屏, 显示标签栏并重放当前会话的输出, 调用方需持有 mu
+func (m *multiplexer) show() {
+	io.WriteString(m.out, "\x1b[2J\x1b[H"+m.tabs())
+	m.out.Write(m.panes[m.focus].history)
+}
+
+// notice 在当前位置输出提示, 不写入会话的 history, 调用方需持有 mu
+func (m *multiplexer) notice(msg string) {
+	io.WriteString(m.out, "\r\n\x1b[7m "+msg+" \x1b[0m\r\n")
+}
+
+func (m *multiplexer) switchTo(i int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if i < 0 || i >= len(m.panes) {
+		return
+	}
+	m.focus = i
+	m.show()
+}
+
+// next 切换到 step 方向上的下一个会话, 跳过已关闭的会话
+func (m *multiplexer) next(step int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if i, ok := m.nextAlive(step); ok {
+		m.focus = i
+		m.show()
+	}
+}
+
+// nextAlive 调用方需持有 mu
+func (m *multiplexer) nextAlive(step int) (int, bool) {
+	for n := 1; n <= len(m.panes); n++ {
+		i := ((m.focus+step*n)%len(m.panes) + len(m.panes)) % len(m.panes)
+		if !m.panes[i].closed {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (m *multiplexer) toggleBroadcast() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.broadcast = !m.broadcast
+	if m.broadcast {
+		m.notice("broadcast: ON, input is sent to all hosts")
+	} else {
+		m.notice(fmt.Sprintf("broadcast: off, input is sent to %s only", m.panes[m.focus].machine.Host()))
+	}
+}
+
+func (m *multiplexer) list() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notice(strings.TrimSuffix(strings.TrimPrefix(m.tabs(), "\x1b[7m "), " \x1b[0m\r\n"))
+}
+
+func (m *multiplexer) help() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notice(MultiHelpText)
+}
+
+// send 将输入发送到当前会话, 广播时发送到所有未关闭的会话
+func (m *multiplexer) send(data []byte) {
+	m.mu.Lock()
+	targets := make([]*pane, 0, len(m.panes))
+	for i, p := range m.panes {
+		if !p.closed && (m.broadcast || i == m.focus) {
+			targets = append(targets, p)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, p := range targets {
+		p.stdin.Write(data)
+	}
+}
+
+func (m *multiplexer) resize(width, height int) {
+	m.mu.Lock()
+	panes := append([]*pane(nil), m.panes...)
+	m.mu.Unlock()
+
+	for _, p := range panes {
+		p.resize(width, height)
+	}
+}
+
+func (m *multiplexer) closeAll() {
+	m.mu.Lock()
+	panes := append([]*pane(nil), m.panes...)
+	for _, p := range panes {
+		p.quit = true
+	}
+	m.mu.Unlock()
+
+	for _, p := range panes {
+		p.close()
+	}
+}
+
+// closed 会话结束; 当前会话结束时切换到下一个未关闭的会话, 全部结束时关闭 done
+func (m *multiplexer) closed(p *pane, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed, p.end, p.err = true, time.Now(), err
+	if p.log != nil {
+		p.log.Close()
+	}
+
+	if m.alive--; m.alive == 0 {
+		close(m.done)
+		return
+	}
+	if m.panes[m.focus] == p {
+		if i, ok := m.nextAlive(1); ok {
+			m.focus = i
+			m.show()
+			m.notice(fmt.Sprintf("%s closed", p.machine.Host()))
+		}
+	}
+}
+
+// multiInput 处理终端输入: 前缀键之后的按键为命令, 其余输入交给 multiplexer.send
+type multiInput struct {
+	m      *multiplexer
+	prefix bool
+}
+
+func (in *multiInput) Write(b []byte) (int, error) {
+	var data []byte
+	flush := func() {
+		if len(data) != 0 {
+			in.m.send(data)
+			data = nil
+		}
+	}
+
+	for _, x := range b {
+		if !in.prefix {
+			if x == multiPrefix {
+				in.prefix = true
+			} else {
+				data = append(data, x)
+			}
+			continue
+		}
+
+		in.prefix = false
+		if x == multiPrefix {
+			data = append(data, x)
+			continue
+		}
+		flush()
+		switch {
+		case x == 'n':
+			in.m.next(1)
+		case x == 'p':
+			in.m.next(-1)
+		case x >= '1' && x <= '9':
+			in.m.switchTo(int(x - '1'))
+		case x == 'b':
+			in.m.toggleBroadcast()
+		case x == 'l':
+			in.m.list()
+		case x == 'q':
+			in.m.closeAll()
+		default:
+			in.m.help()
+		}
+	}
+	flush()
+	return len(b), nil
+}
+
+// InteractiveWithTerminalForMulti 同时登录多台 machine 并打开交互式终端, 以标签页的方式显示:
+// 只显示当前会话, 切换时重放该会话最近的输出; 输入发送到当前会话, 开启广播时发送到所有会话.
+// 每台 machine 的输出分别记录到 option.LogDir. 登录失败的 machine 不会打开, 记录在结果中
+func InteractiveWithTerminalForMulti(dialer *Dialer, machines []*assets.Machine, option MultiOption) ([]*MultiResult, error) {
+	fd := int(os.Stdin.Fd())
+	w, h, err := term.GetSize(fd)
+	if err != nil {
+		return nil, err
+	}
+	termType := os.Getenv("TERM")
+	if termType == "" {
+		termType = "xterm-256color"
+	}
+	if option.LogDir != "" {
+		if err := os.MkdirAll(option.LogDir, 0o700); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		results = make([]*MultiResult, len(machines))
+		panes   = make([]*pane, len(machines))
+		m       = newMultiplexer(os.Stdout, nil)
+	)
+	runOnMachines(machines, option.Concurrency, func(i int, machine *assets.Machine) {
+		results[i] = &MultiResult{Machine: machine, Start: time.Now()}
+		p, err := openPane(dialer, m, machine, termType, w, h, option)
+		if err != nil {
+			results[i].End, results[i].Err = time.Now(), err
+			return
+		}
+		panes[i], results[i].LogPath = p, p.logPath
+	})
+
+	opened := make([]*pane, 0, len(panes))
+	for _, p := range panes {
+		if p != nil {
+			opened = append(opened, p)
+		}
+	}
+	if len(opened) == 0 {
+		return results, fmt.Errorf("no machine logged in")
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		for _, p := range opened {
+			p.close()
+		}
+		return results, err
+	}
+	defer term.Restore(fd, state)
+
+	m.mu.Lock()
+	m.panes, m.alive = opened, len(opened)
+	m.show()
+	m.notice(MultiHelpText)
+	m.mu.Unlock()
+	for _, p := range opened {
+		go p.run(m)
+	}
+
+	sharedStdin.start()
+	go sharedStdin.copyTo(&multiInput{m: m}, m.done)
+
+	signal_chan := make(chan os.Signal, 1)
+	signal.Notify(signal_chan, syscall.SIGWINCH, syscall.SIGQUIT, syscall.SIGTERM)
+	defer signal.Stop(signal_chan)
+	for {
+		select {
+		case <-m.done:
+			for i, p := range panes {
+				if p != nil {
+					results[i].End, results[i].Err = p.end, p.err
+				}
+			}
+			return results, nil
+		case s := <-signal_chan:
+			if s == syscall.SIGWINCH {
+				w, h, _ = term.GetSize(int(os.Stdout.Fd()))
+				m.resize(w, h)
+			} else {
+				m.closeAll()
+			}
+		}
+	}
+}
+
+// run 等待会话结束; 远端 shell 以非 0 状态退出与主动关闭连接不作为错误
+func (p *pane) run(m *multiplexer) {
+	err := p.waitClose()
+	m.mu.Lock()
+	quit := p.quit
+	m.mu.Unlock()
+
+	var exitErr *ssh.ExitError
+	if quit || errors.As(err, &exitErr) || errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+	m.closed(p, err)
+}
+
+// openPane 登录 machine, 完成提权与登录步骤后返回会话; 登录期间的输出保留在 history 中
+func openPane(dialer *Dialer, m *multiplexer, machine *assets.Machine, termType string, width, height int, option MultiOption) (*pane, error) {
+	var steps []*assets.Step
+	if option.Steps != nil {
+		var err error
+		if steps, err = option.Steps(machine); err != nil {
+			return nil, err
+		}
+	}
+
+	p := &pane{machine: machine, watcher: newPromptWatcher()}
+	if option.LogDir != "" {
+		p.logPath = filepath.Join(option.LogDir, machine.FileName()+".log")
+		f, err := os.OpenFile(p.logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		p.log = f
+	}
+
+	var err error
+	if machine.IsTelnet() {
+		err = p.openTelnet(dialer, m, termType, width, height)
+	} else {
+		err = p.openSSH(dialer, m, termType, width, height)
+	}
+	if err == nil && machine.Become != nil {
+		err = becomeInteractive(p.watcher, p.stdin, machine)
+	}
+	if err != nil {
+		if p.close != nil {
+			p.close()
+		}
+		if p.log != nil {
+			p.log.Close()
+		}
+		return nil, err
+	}
+
+	// 步骤失败时仍打开会话, 与单个会话一致
+	if len(steps) != 0 {
+		if err := runSteps(p.watcher, p.stdin, machine, steps); err != nil {
+			io.WriteString(m.paneWriter(p), fmt.Sprintf("\r\n==> Warning: %v\r\n", err))
+		}
+	}
+
+	m.mu.Lock()
+	p.watcher = nil
+	m.mu.Unlock()
+	return p, nil
+}
+
+func (p *pane) openSSH(dialer *Dialer, m *multiplexer, termType string, width, height int) error {
+	client, err := dialer.Dial(p.machine)
+	if err != nil {
+		return err
+	}
+	p.close = client.Close
+
+	session, err := dialer.NewSession(client, p.machine)
+	if err != nil {
+		return err
+	}
+	modes := ssh.TerminalModes{
+		ssh.ECHOCTL:       1,
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err := session.RequestPty(termType, height, width, modes); err != nil {
+		return err
+	}
+	if p.stdin, err = session.StdinPipe(); err != nil {
+		return err
+	}
+	out := m.paneWriter(p)
+	session.Stdout, session.Stderr = out, out
+	if err := session.Shell(); err != nil {
+		return err
+	}
+
+	p.resize = func(width, height int) { session.WindowChange(height, width) }
+	p.waitClose = func() error {
+		err := session.Wait()
+		client.Close()
+		return err
+	}
+	return nil
+}
+
+func (p *pane) openTelnet(dialer *Dialer, m *multiplexer, termType string, width, height int) error {
+	raw, err := dialer.DialTelnet(p.machine)
+	if err != nil {
+		return err
+	}
+	p.close = raw.Close
+
+	conn := newTelnetConn(raw, termType, width, height)
+	closed := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(m.paneWriter(p), conn)
+		closed <- err
+	}()
+	p.stdin, p.resize = conn, conn.Resize
+	p.waitClose = func() error {
+		err := <-closed
+		raw.Close()
+		return err
+	}
+	return telnetLogin(p.watcher, conn, p.machine)
+}
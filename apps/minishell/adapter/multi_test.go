@@ -0,0 +1,128 @@
+package adapter
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+	"github.com/stretchr/testify/assert"
+)
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestMultiplexer(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		out    bytes.Buffer
+		panes  = make([]*pane, 3)
+		stdins = make([]*bytes.Buffer, 3)
+		logs   = make([]*bytes.Buffer, 3)
+	)
+	for i := range panes {
+		stdins[i], logs[i] = &bytes.Buffer{}, &bytes.Buffer{}
+		panes[i] = &pane{
+			machine: &assets.Machine{IP: "10.0.0." + string(rune('1'+i))},
+			stdin:   stdins[i],
+			log:     nopWriteCloser{logs[i]},
+			resize:  func(width, height int) {},
+			close:   func() error { return nil },
+		}
+	}
+	m := newMultiplexer(&out, panes)
+	input := &multiInput{m: m}
+
+	// 只显示当前会话的输出, 所有输出都写入各自的日志
+	for i, p := range panes {
+		io.WriteString(m.paneWriter(p), "$ hello from "+p.machine.IP+"\r\n")
+		assert.Equal("$ hello from "+p.machine.IP+"\r\n", logs[i].String())
+	}
+	assert.Equal("$ hello from 10.0.0.1\r\n", out.String())
+
+	io.WriteString(input, "ls\r")
+	assert.Equal("ls\r", stdins[0].String())
+	assert.Empty(stdins[1].String())
+
+	// 切换时重放输出
+	out.Reset()
+	io.WriteString(input, "\x1d2")
+	assert.True(strings.HasPrefix(out.String(), "\x1b[2J\x1b[H"))
+	assert.Contains(out.String(), "[2:10.0.0.2]")
+	assert.True(strings.HasSuffix(out.String(), "$ hello from 10.0.0.2\r\n"))
+	io.WriteString(input, "pwd\r")
+	assert.Equal("pwd\r", stdins[1].String())
+
+	// 广播, 连续两次前缀键发送前缀键本身
+	io.WriteString(input, "\x1dbuptime\r\x1d\x1d")
+	for i := range panes {
+		assert.True(strings.HasSuffix(stdins[i].String(), "uptime\r\x1d"), i)
+	}
+
+	// 当前会话结束时切换到下一个, 已关闭的会话不再接收输入
+	m.closed(panes[1], nil)
+	assert.Equal(2, m.focus)
+	assert.Contains(out.String(), "10.0.0.2 closed")
+	io.WriteString(input, "\x1dp")
+	assert.Equal(0, m.focus)
+	io.WriteString(input, "w\r")
+	assert.False(strings.HasSuffix(stdins[1].String(), "w\r"))
+	assert.True(strings.HasSuffix(stdins[2].String(), "w\r"))
+
+	m.closed(panes[0], nil)
+	m.closed(panes[2], nil)
+	select {
+	case <-m.done:
+	default:
+		t.Fatal("multiplexer not done")
+	}
+}
+
+func TestOpenPane(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newTestServer(t, "root", "root-password")
+	var out bytes.Buffer
+	m := newMultiplexer(&out, nil)
+	dir := t.TempDir()
+
+	p, err := openPane(newTestDialer(t), m, server.machine("root", "root-password"), "xterm", 80, 24, MultiOption{
+		LogDir: dir,
+		Steps: func(machine *assets.Machine) ([]*assets.Step, error) {
+			return []*assets.Step{{Send: "cd /data"}}, nil
+		},
+	})
+	if !assert.Nil(err) {
+		return
+	}
+	m.panes, m.alive = []*pane{p}, 1
+	go p.run(m)
+
+	io.WriteString(&multiInput{m: m}, "exit\r")
+	<-m.done
+	assert.Nil(p.err)
+
+	buf, err := os.ReadFile(p.logPath)
+	assert.Nil(err)
+	assert.Equal("$ cd /data\r\nexec: cd /data\r\n$ ", string(buf))
+
+	// Ctrl+] q 关闭全部会话不作为错误
+	m = newMultiplexer(&out, nil)
+	p, err = openPane(newTestDialer(t), m, server.machine("root", "root-password"), "xterm", 80, 24, MultiOption{})
+	if !assert.Nil(err) {
+		return
+	}
+	m.panes, m.alive = []*pane{p}, 1
+	go p.run(m)
+
+	io.WriteString(&multiInput{m: m}, "\x1dq")
+	<-m.done
+	assert.Nil(p.err)
+
+	_, err = openPane(newTestDialer(t), m, server.machine("root", "wrong-password"), "xterm", 80, 24, MultiOption{LogDir: dir})
+	assert.NotNil(err)
+}
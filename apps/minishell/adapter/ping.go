@@ -0,0 +1,181 @@
+package adapter
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+	"golang.org/x/crypto/ssh"
+)
+
+type PingOption struct {
+	Concurrency int
+	Timeout     time.Duration
+	// Auth 为 true 时使用 machine 的认证信息登录, 检查认证是否成功
+	Auth bool
+}
+
+const (
+	AuthSkipped = ""
+	AuthOK      = "ok"
+	AuthFailed  = "failed"
+)
+
+type PingResult struct {
+	Machine *assets.Machine
+	// Latency 建立 tcp 连接的耗时, 经跳板机时为建立隧道的耗时
+	Latency       time.Duration
+	ServerVersion string
+	HostKeyType   string
+	Fingerprint   string
+	Auth          string
+	Err           error
+}
+
+// Reachable 是否成功读取到 ssh banner
+func (r *PingResult) Reachable() bool {
+	return r.ServerVersion != ""
+}
+
+// PingMachines 并发检查 machines 的连通性: tcp 连接、读取 ssh banner、记录 host key, option.Auth 为 true 时检查认证.
+// 结果与 machines 顺序一致
+func PingMachines(dialer *Dialer, machines []*assets.Machine, option PingOption) []*PingResult {
+	results := make([]*PingResult, len(machines))
+	runOnMachines(machines, option.Concurrency, func(i int, machine *assets.Machine) {
+		results[i] = dialer.Ping(machine, option)
+	})
+	return results
+}
+
+// Ping 检查单台 machine, 错误记录在 PingResult.Err 中; telnet 设备只检查 tcp 连接
+func (d *Dialer) Ping(machine *assets.Machine, option PingOption) *PingResult {
+	result := &PingResult{Machine: machine}
+
+	via, err := d.dialChain(machine.JumpChain)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	if via != nil {
+		defer via.Close()
+	}
+
+	addr := machine.Addr()
+	begin := time.Now()
+	var conn net.Conn
+	if via == nil {
+		conn, err = net.DialTimeout("tcp", addr, d.timeout(machine))
+	} else {
+		conn, err = via.Dial("tcp", addr)
+	}
+	if err != nil {
+		result.Err = fmt.Errorf("connect %s failure, nest error: %v", addr, err)
+		return result
+	}
+	result.Latency = time.Since(begin)
+	defer conn.Close()
+
+	// telnet 设备只检查 tcp 连接
+	if machine.IsTelnet() {
+		result.ServerVersion = assets.ProtocolTelnet
+		return result
+	}
+
+	// 隧道中的连接不支持 SetDeadline, 超时后直接关闭连接
+	var timedOut atomic.Bool
+	if option.Timeout > 0 {
+		timer := time.AfterFunc(option.Timeout, func() {
+			timedOut.Store(true)
+			conn.Close()
+		})
+		defer timer.Stop()
+	}
+	defer func() {
+		if timedOut.Load() && result.Err != nil {
+			result.Err = fmt.Errorf("ping timeout after %v", option.Timeout)
+		}
+	}()
+
+	banner, replay, err := readBanner(conn)
+	if err != nil {
+		result.Err = fmt.Errorf("read ssh banner failure, nest error: %v", err)
+		return result
+	}
+	result.ServerVersion = banner
+
+	config := &ssh.ClientConfig{User: machine.Username, Config: defaultConfig}
+	if option.Auth {
+		if config, err = d.clientConfig(machine, addr); err != nil {
+			result.Err = err
+			return result
+		}
+	}
+
+	var (
+		verify      = config.HostKeyCallback
+		hostKeyPass bool
+	)
+	config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		result.HostKeyType, result.Fingerprint = key.Type(), ssh.FingerprintSHA256(key)
+		if !option.Auth {
+			return errHostKeyScanned
+		}
+		if err := verify(hostname, remote, key); err != nil {
+			return err
+		}
+		hostKeyPass = true
+		return nil
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(replay, addr, config)
+	switch {
+	case err == nil:
+		ssh.NewClient(c, chans, reqs).Close()
+		result.Auth = AuthOK
+	case !option.Auth && result.Fingerprint != "":
+	case hostKeyPass:
+		result.Auth, result.Err = AuthFailed, err
+	default:
+		result.Err = err
+	}
+	return result
+}
+
+// readBanner 读取服务端的 ssh 版本行, 返回的 conn 会重新读到已读取的内容, 可继续用于握手
+func readBanner(conn net.Conn) (string, net.Conn, error) {
+	var (
+		r    = bufio.NewReader(conn)
+		read bytes.Buffer
+	)
+	// RFC 4253: 版本行之前可能有其它行
+	for i := 0; i < 32; i++ {
+		line, err := r.ReadString('\n')
+		read.WriteString(line)
+		if err != nil {
+			if err == io.EOF {
+				err = errors.New("connection closed before ssh banner")
+			}
+			return "", nil, err
+		}
+		if line = strings.TrimRight(line, "\r\n"); strings.HasPrefix(line, "SSH-") {
+			return line, &replayConn{Conn: conn, r: io.MultiReader(&read, r)}, nil
+		}
+	}
+	return "", nil, errors.New("ssh banner not found")
+}
+
+type replayConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,62 @@
+package adapter
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestPingMachines(t *testing.T) {
+	assert := assert.New(t)
+
+	// 版本行之前带有其它行, 读取 banner 后关闭连接
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("welcome\r\nSSH-2.0-Fake_1.0\r\n"))
+			conn.Close()
+		}
+	}()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	closed.Close()
+
+	server := newTestServer(t, "root", "root-password")
+	machines := []*assets.Machine{
+		server.machine("root", "root-password"),
+		server.machine("root", "wrong-password"),
+		{IP: "127.0.0.1", Port: listener.Addr().(*net.TCPAddr).Port, Username: "root"},
+		{IP: "127.0.0.1", Port: closed.Addr().(*net.TCPAddr).Port, Username: "root"},
+	}
+
+	dialer := newTestDialer(t)
+	results := PingMachines(dialer, machines, PingOption{Concurrency: 4, Timeout: 5 * time.Second})
+	assert.Len(results, 4)
+	assert.Nil(results[0].Err)
+	assert.True(results[0].Reachable())
+	assert.Equal("SSH-2.0-Go", results[0].ServerVersion)
+	assert.Equal(ssh.KeyAlgoED25519, results[0].HostKeyType)
+	assert.NotEmpty(results[0].Fingerprint)
+	assert.Equal(AuthSkipped, results[0].Auth)
+	assert.Equal("SSH-2.0-Fake_1.0", results[2].ServerVersion)
+	assert.NotNil(results[2].Err)
+	assert.False(results[3].Reachable())
+	assert.NotNil(results[3].Err)
+
+	results = PingMachines(dialer, machines[:2], PingOption{Concurrency: 2, Timeout: 5 * time.Second, Auth: true})
+	assert.Nil(results[0].Err)
+	assert.Equal(AuthOK, results[0].Auth)
+	assert.Equal(AuthFailed, results[1].Auth)
+	assert.NotNil(results[1].Err)
+	assert.Equal(results[0].Fingerprint, results[1].Fingerprint)
+}
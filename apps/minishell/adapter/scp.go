@@ -0,0 +1,495 @@
+package adapter
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+	"golang.org/x/crypto/ssh"
+)
+
+type CopyOption struct {
+	Recursive bool
+	Preserve  bool
+
+	// Progress 每写入一段数据后回调, written 为当前文件已传输的字节数
+	Progress func(file string, written, size int64)
+}
+
+type CopyStat struct {
+	Files int
+	Bytes int64
+}
+
+type TransferOption struct {
+	CopyOption
+	Concurrency int
+
+	// Progress 多台机器传输时的进度回调, 优先于 CopyOption.Progress
+	Progress func(machine *assets.Machine, file string, written, size int64)
+	// Done 每台机器传输结束后回调
+	Done func(result *TransferResult)
+}
+
+type TransferResult struct {
+	Machine  *assets.Machine
+	Stat     CopyStat
+	Duration time.Duration
+	Err      error
+}
+
+// PushToMachines 并发上传本地文件到多台机器的同一路径
+func PushToMachines(dialer *Dialer, machines []*assets.Machine, local, remote string, option TransferOption) []*TransferResult {
+	return transferOnMachines(dialer, machines, option, func(client *ssh.Client, _ *assets.Machine, copyOption CopyOption) (CopyStat, error) {
+		return Upload(client, local, remote, copyOption)
+	})
+}
+
+// PullFromMachines 并发从多台机器下载文件; 多台机器时每台机器的文件保存在 local/<user>@<ip>-<port> 目录下
+func PullFromMachines(dialer *Dialer, machines []*assets.Machine, remote, local string, option TransferOption) []*TransferResult {
+	return transferOnMachines(dialer, machines, option, func(client *ssh.Client, machine *assets.Machine, copyOption CopyOption) (CopyStat, error) {
+		target := local
+		if len(machines) > 1 {
+			target = filepath.Join(local, machine.FileName())
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return CopyStat{}, err
+			}
+		}
+		return Download(client, remote, target, copyOption)
+	})
+}
+
+func transferOnMachines(dialer *Dialer, machines []*assets.Machine, option TransferOption, transfer func(*ssh.Client, *assets.Machine, CopyOption) (CopyStat, error)) []*TransferResult {
+	results := make([]*TransferResult, len(machines))
+	runOnMachines(machines, option.Concurrency, func(i int, machine *assets.Machine) {
+		begin := time.Now()
+		result := &TransferResult{Machine: machine}
+		results[i] = result
+		if option.Done != nil {
+			defer option.Done(result)
+		}
+
+		copyOption := option.CopyOption
+		if option.Progress != nil {
+			copyOption.Progress = func(file string, written, size int64) {
+				option.Progress(machine, file, written, size)
+			}
+		}
+
+		client, err := dialer.Dial(machine)
+		if err != nil {
+			result.Err, result.Duration = err, time.Since(begin)
+			return
+		}
+		defer client.Close()
+
+		result.Stat, result.Err = transfer(client, machine, copyOption)
+		result.Duration = time.Since(begin)
+	})
+	return results
+}
+
+// Upload 使用 SCP 协议(scp -t)上传本地文件或目录
+func Upload(client *ssh.Client, local, remote string, option CopyOption) (CopyStat, error) {
+	var stat CopyStat
+
+	fi, err := os.Stat(local)
+	if err != nil {
+		return stat, err
+	}
+	if fi.IsDir() && !option.Recursive {
+		return stat, fmt.Errorf("%s is a directory, use recursive mode", local)
+	}
+
+	err = runSCP(client, "-t", remote, option, func(w io.Writer, r *bufio.Reader) error {
+		if err := readSCPAck(r); err != nil {
+			return err
+		}
+
+		s := &scpSender{w: w, r: r, option: option, stat: &stat}
+		if fi.IsDir() {
+			return s.sendDir(local, fi)
+		}
+		return s.sendFile(local, fi)
+	})
+	return stat, err
+}
+
+// Download 使用 SCP 协议(scp -f)下载远程文件或目录; local 为已存在的目录时保存到该目录下
+func Download(client *ssh.Client, remote, local string, option CopyOption) (CopyStat, error) {
+	var stat CopyStat
+
+	err := runSCP(client, "-f", remote, option, func(w io.Writer, r *bufio.Reader) error {
+		s := &scpReceiver{w: w, r: r, option: option, stat: &stat, local: local}
+		return s.receive()
+	})
+	return stat, err
+}
+
+func runSCP(client *ssh.Client, mode, remote string, option CopyOption, fn func(io.Writer, *bufio.Reader) error) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+
+	command := []string{"scp", mode}
+	if option.Recursive {
+		command = append(command, "-r")
+	}
+	if option.Preserve {
+		command = append(command, "-p")
+	}
+	command = append(command, "--", shellQuote(remote))
+	if err := session.Start(strings.Join(command, " ")); err != nil {
+		return err
+	}
+
+	err = fn(stdin, bufio.NewReader(stdout))
+	stdin.Close()
+	if waitErr := session.Wait(); err == nil && waitErr != nil {
+		err = waitErr
+	}
+	if err != nil && stderr.Len() != 0 {
+		return fmt.Errorf("%v, stderr: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return err
+}
+
+type scpSender struct {
+	w      io.Writer
+	r      *bufio.Reader
+	option CopyOption
+	stat   *CopyStat
+}
+
+func (s *scpSender) sendTimes(fi os.FileInfo) error {
+	if !s.option.Preserve {
+		return nil
+	}
+	mtime := fi.ModTime().Unix()
+	if _, err := fmt.Fprintf(s.w, "T%d 0 %d 0\n", mtime, mtime); err != nil {
+		return err
+	}
+	return readSCPAck(s.r)
+}
+
+func (s *scpSender) sendFile(path string, fi os.FileInfo) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := s.sendTimes(fi); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "C%04o %d %s\n", fi.Mode().Perm(), fi.Size(), fi.Name()); err != nil {
+		return err
+	}
+	if err := readSCPAck(s.r); err != nil {
+		return err
+	}
+
+	n, err := io.Copy(s.w, &progressReader{r: f, file: path, size: fi.Size(), progress: s.option.Progress})
+	if err != nil {
+		return err
+	}
+	if n != fi.Size() {
+		return fmt.Errorf("%s changed size during transfer", path)
+	}
+	if _, err := s.w.Write([]byte{0}); err != nil {
+		return err
+	}
+	if err := readSCPAck(s.r); err != nil {
+		return err
+	}
+
+	s.stat.Files++
+	s.stat.Bytes += n
+	return nil
+}
+
+func (s *scpSender) sendDir(path string, fi os.FileInfo) error {
+	if err := s.sendTimes(fi); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "D%04o 0 %s\n", fi.Mode().Perm(), fi.Name()); err != nil {
+		return err
+	}
+	if err := readSCPAck(s.r); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		child := filepath.Join(path, entry.Name())
+		info, err := os.Stat(child)
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.IsDir():
+			err = s.sendDir(child, info)
+		case info.Mode().IsRegular():
+			err = s.sendFile(child, info)
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprint(s.w, "E\n"); err != nil {
+		return err
+	}
+	return readSCPAck(s.r)
+}
+
+type scpReceiver struct {
+	w      io.Writer
+	r      *bufio.Reader
+	option CopyOption
+	stat   *CopyStat
+	local  string
+
+	dirs     []string
+	times    *[2]time.Time
+	dirTimes [][2]time.Time
+}
+
+func (s *scpReceiver) receive() error {
+	if err := s.ack(); err != nil {
+		return err
+	}
+
+	for {
+		b, err := s.r.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		switch b {
+		case 1, 2:
+			return fmt.Errorf("scp: %s", line)
+		case 'T':
+			times, err := parseSCPTimes(line)
+			if err != nil {
+				return err
+			}
+			s.times = &times
+		case 'C':
+			if err := s.receiveFile(line); err != nil {
+				return err
+			}
+			continue
+		case 'D':
+			if err := s.receiveDir(line); err != nil {
+				return err
+			}
+		case 'E':
+			if len(s.dirs) == 0 {
+				return fmt.Errorf("scp: unexpected end of directory")
+			}
+			dir, times := s.dirs[len(s.dirs)-1], s.dirTimes[len(s.dirTimes)-1]
+			s.dirs, s.dirTimes = s.dirs[:len(s.dirs)-1], s.dirTimes[:len(s.dirTimes)-1]
+			if !times[1].IsZero() {
+				os.Chtimes(dir, times[0], times[1])
+			}
+		default:
+			return fmt.Errorf("scp: unexpected message %q", string(b)+line)
+		}
+		if err := s.ack(); err != nil {
+			return err
+		}
+	}
+}
+
+// target 计算本地保存路径; 顶层条目在 local 为已存在的目录时保存到其中, 否则直接使用 local
+func (s *scpReceiver) target(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return "", fmt.Errorf("scp: invalid file name %q", name)
+	}
+	if len(s.dirs) != 0 {
+		return filepath.Join(s.dirs[len(s.dirs)-1], name), nil
+	}
+	if fi, err := os.Stat(s.local); err == nil && fi.IsDir() {
+		return filepath.Join(s.local, name), nil
+	}
+	return s.local, nil
+}
+
+func (s *scpReceiver) receiveFile(line string) error {
+	mode, size, name, err := parseSCPEntry(line)
+	if err != nil {
+		return err
+	}
+	path, err := s.target(name)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := s.ack(); err != nil {
+		return err
+	}
+
+	n, err := io.Copy(f, &progressReader{r: io.LimitReader(s.r, size), file: path, size: size, progress: s.option.Progress})
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("scp: unexpected end of file %s", path)
+	}
+	if err := readSCPAck(s.r); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if s.option.Preserve {
+		os.Chmod(path, mode)
+		if s.times != nil {
+			os.Chtimes(path, s.times[0], s.times[1])
+		}
+	}
+	s.times = nil
+
+	s.stat.Files++
+	s.stat.Bytes += n
+	return s.ack()
+}
+
+func (s *scpReceiver) receiveDir(line string) error {
+	if !s.option.Recursive {
+		return fmt.Errorf("scp: received directory without recursive mode")
+	}
+	mode, _, name, err := parseSCPEntry(line)
+	if err != nil {
+		return err
+	}
+	path, err := s.target(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path, mode|0o700); err != nil {
+		return err
+	}
+
+	var times [2]time.Time
+	if s.option.Preserve && s.times != nil {
+		times = *s.times
+		os.Chmod(path, mode|0o700)
+	}
+	s.times = nil
+	s.dirs = append(s.dirs, path)
+	s.dirTimes = append(s.dirTimes, times)
+	return nil
+}
+
+func (s *scpReceiver) ack() error {
+	_, err := s.w.Write([]byte{0})
+	return err
+}
+
+// parseSCPEntry 解析 "0644 1024 name" 格式
+func parseSCPEntry(line string) (os.FileMode, int64, string, error) {
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) != 3 {
+		return 0, 0, "", fmt.Errorf("scp: invalid entry %q", line)
+	}
+	mode, err := strconv.ParseUint(parts[0], 8, 32)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("scp: invalid mode %q", parts[0])
+	}
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, 0, "", fmt.Errorf("scp: invalid size %q", parts[1])
+	}
+	return os.FileMode(mode).Perm(), size, parts[2], nil
+}
+
+// parseSCPTimes 解析 "mtime 0 atime 0" 格式, 返回 [atime, mtime]
+func parseSCPTimes(line string) ([2]time.Time, error) {
+	var mtime, mtimeUsec, atime, atimeUsec int64
+	if _, err := fmt.Sscanf(line, "%d %d %d %d", &mtime, &mtimeUsec, &atime, &atimeUsec); err != nil {
+		return [2]time.Time{}, fmt.Errorf("scp: invalid times %q", line)
+	}
+	return [2]time.Time{time.Unix(atime, atimeUsec*1000), time.Unix(mtime, mtimeUsec*1000)}, nil
+}
+
+func readSCPAck(r *bufio.Reader) error {
+	b, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch b {
+	case 0:
+		return nil
+	case 1, 2:
+		msg, _ := r.ReadString('\n')
+		return fmt.Errorf("scp: %s", strings.TrimSpace(msg))
+	default:
+		return errors.New("scp: unexpected response from remote")
+	}
+}
+
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+type progressReader struct {
+	r        io.Reader
+	file     string
+	size     int64
+	written  int64
+	progress func(file string, written, size int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.written += int64(n)
+	if p.progress != nil && n > 0 {
+		p.progress(p.file, p.written, p.size)
+	}
+	return n, err
+}
@@ -0,0 +1,85 @@
+package adapter
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPushPull(t *testing.T) {
+	if _, err := exec.LookPath("scp"); err != nil {
+		t.Skip("scp not found")
+	}
+	assert := assert.New(t)
+
+	var (
+		server   = newTestServer(t, "root", "root-password")
+		machines = []*assets.Machine{server.machine("root", "root-password")}
+		dialer   = newTestDialer(t)
+		local    = t.TempDir()
+		remote   = t.TempDir()
+		mtime    = time.Date(2023, 1, 2, 3, 4, 5, 0, time.Local)
+	)
+	assert.Nil(os.MkdirAll(filepath.Join(local, "data", "sub"), 0o755))
+	assert.Nil(os.WriteFile(filepath.Join(local, "data", "a.txt"), []byte("hello"), 0o600))
+	assert.Nil(os.WriteFile(filepath.Join(local, "data", "sub", "b.txt"), []byte("world!"), 0o755))
+	assert.Nil(os.Chtimes(filepath.Join(local, "data", "a.txt"), mtime, mtime))
+
+	option := TransferOption{CopyOption: CopyOption{Recursive: true, Preserve: true}, Concurrency: 2}
+	results := PushToMachines(dialer, machines, filepath.Join(local, "data"), remote, option)
+	assert.Nil(results[0].Err)
+	assert.Equal(CopyStat{Files: 2, Bytes: 11}, results[0].Stat)
+
+	fi, err := os.Stat(filepath.Join(remote, "data", "a.txt"))
+	assert.Nil(err)
+	assert.Equal(os.FileMode(0o600), fi.Mode().Perm())
+	assert.True(fi.ModTime().Equal(mtime))
+
+	var progressed bool
+	option.Progress = func(_ *assets.Machine, _ string, written, size int64) {
+		progressed = written == size
+	}
+	pulled := filepath.Join(t.TempDir(), "copy")
+	results = PullFromMachines(dialer, machines, filepath.Join(remote, "data"), pulled, option)
+	assert.Nil(results[0].Err)
+	assert.Equal(CopyStat{Files: 2, Bytes: 11}, results[0].Stat)
+	assert.True(progressed)
+
+	buf, err := os.ReadFile(filepath.Join(pulled, "sub", "b.txt"))
+	assert.Nil(err)
+	assert.Equal("world!", string(buf))
+	fi, err = os.Stat(filepath.Join(pulled, "a.txt"))
+	assert.Nil(err)
+	assert.True(fi.ModTime().Equal(mtime))
+
+	results = PullFromMachines(dialer, machines, filepath.Join(remote, "missing"), pulled, option)
+	assert.NotNil(results[0].Err)
+}
+
+func TestPullFromMachinesSameIP(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		remote = t.TempDir()
+		local  = t.TempDir()
+		a      = newTestServer(t, "root", "root-password").machine("root", "root-password")
+		b      = newTestServer(t, "root", "root-password").machine("root", "root-password")
+	)
+	assert.Equal(a.IP, b.IP)
+	assert.Nil(os.WriteFile(filepath.Join(remote, "a.txt"), []byte("hello"), 0o600))
+
+	results := PullFromMachines(newTestDialer(t), []*assets.Machine{a, b}, filepath.Join(remote, "a.txt"), local, TransferOption{Concurrency: 2})
+	for _, result := range results {
+		assert.Nil(result.Err)
+	}
+	for _, machine := range []*assets.Machine{a, b} {
+		buf, err := os.ReadFile(filepath.Join(local, machine.FileName(), "a.txt"))
+		assert.Nil(err)
+		assert.Equal("hello", string(buf))
+	}
+}
@@ -0,0 +1,459 @@
+package adapter
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// testServer 进程内的 sshd 替身, 支持密码与公钥认证、exec、agent 转发、direct-tcpip 与 tcpip-forward
+type testServer struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	conns    map[net.Conn]bool
+
+	Host string
+	Port int
+	// AuthorizedKeys 允许公钥认证的 key
+	AuthorizedKeys []ssh.PublicKey
+	// UserCA 信任的用户 CA, 由其签发的用户证书可以登录
+	UserCA ssh.PublicKey
+
+	hostKey ssh.Signer
+}
+
+func newTestServer(t *testing.T, username, password string) *testServer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == username && string(pass) == password {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("login[user=%s] failure", c.User())
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{listener: listener, config: config, Host: "127.0.0.1", Port: listener.Addr().(*net.TCPAddr).Port, hostKey: signer}
+	config.PublicKeyCallback = func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		if _, ok := key.(*ssh.Certificate); ok && s.UserCA != nil {
+			checker := &ssh.CertChecker{IsUserAuthority: func(auth ssh.PublicKey) bool {
+				return bytes.Equal(auth.Marshal(), s.UserCA.Marshal())
+			}}
+			return checker.Authenticate(c, key)
+		}
+		for _, authorized := range s.AuthorizedKeys {
+			if c.User() == username && bytes.Equal(authorized.Marshal(), key.Marshal()) {
+				return nil, nil
+			}
+		}
+		return nil, fmt.Errorf("login[user=%s] failure, unknown public key", c.User())
+	}
+	go s.serve()
+	t.Cleanup(s.close)
+	return s
+}
+
+// useHostCert 同时提供由 ca 签发的 host 证书
+func (s *testServer) useHostCert(t *testing.T, ca ssh.Signer) {
+	cert := newTestCert(t, ca, s.hostKey.PublicKey(), ssh.HostCert, []string{s.Host}, ssh.CertTimeInfinity)
+	signer, err := ssh.NewCertSigner(cert, s.hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	config := *s.config
+	config.AddHostKey(signer)
+	s.config = &config
+}
+
+// newTestCert 使用 ca 为 key 签发证书
+func newTestCert(t *testing.T, ca ssh.Signer, key ssh.PublicKey, certType uint32, principals []string, validBefore uint64) *ssh.Certificate {
+	cert := &ssh.Certificate{
+		Key:             key,
+		CertType:        certType,
+		KeyId:           "minishell-test",
+		ValidPrincipals: principals,
+		ValidBefore:     validBefore,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func (s *testServer) machine(username, password string) *assets.Machine {
+	return &assets.Machine{IP: s.Host, Port: s.Port, Username: username, Password: password}
+}
+
+func (s *testServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handleConn(conn)
+		}()
+	}
+}
+
+func (s *testServer) close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+// drop 断开所有已建立的连接, 模拟网络中断
+func (s *testServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *testServer) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	s.mu.Lock()
+	config := s.config
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]bool)
+	}
+	s.conns[conn] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	servconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	defer servconn.Close()
+
+	go handleTestGlobalRequests(servconn, reqs)
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "session":
+			go handleTestSession(servconn, newChannel)
+		case "direct-tcpip":
+			go handleTestDirectTCPIP(newChannel)
+		default:
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+		}
+	}
+}
+
+// handleTestSession 对 exec 请求回显命令本身; "scp" 交由本机 scp 执行, "exit N" 以 N 退出, "sleep" 阻塞至连接关闭,
+// shell 请求由 testShell 处理, "ssh-add -l" 经转发的 agent 列出客户端 agent 中的 key, "sudo"/"su" 询问密码, 密码为 testBecomePassword 时执行其中的命令
+func handleTestSession(conn ssh.Conn, newChannel ssh.NewChannel) {
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+
+	var forwardAgent bool
+	for req := range requests {
+		switch req.Type {
+		case "auth-agent-req@openssh.com":
+			forwardAgent = true
+			req.Reply(true, nil)
+		case "pty-req", "window-change":
+			req.Reply(true, nil)
+		case "shell":
+			req.Reply(true, nil)
+			go func() {
+				testShell(channel)
+				channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+				channel.Close()
+			}()
+		case "exec":
+			var payload struct{ Command string }
+			ssh.Unmarshal(req.Payload, &payload)
+			req.Reply(true, nil)
+
+			var status uint32
+			switch {
+			case strings.HasPrefix(payload.Command, "scp "):
+				cmd := exec.Command("sh", "-c", payload.Command)
+				cmd.Stdout, cmd.Stderr = channel, channel.Stderr()
+				// 不能直接把 channel 作为 Stdin, 否则 Run 会等待客户端关闭输入
+				stdin, _ := cmd.StdinPipe()
+				go func() {
+					io.Copy(stdin, channel)
+					stdin.Close()
+				}()
+				if err := cmd.Run(); err != nil {
+					status = 1
+				}
+			case payload.Command == "sleep":
+				for range requests {
+				}
+				return
+			case payload.Command == "ssh-add -l":
+				if status = 2; forwardAgent {
+					status = listTestAgent(conn, channel)
+				}
+			case strings.HasPrefix(payload.Command, "sudo ") || strings.HasPrefix(payload.Command, "su "):
+				status = becomeTestCommand(channel, payload.Command)
+			case strings.HasPrefix(payload.Command, "exit "):
+				code, _ := strconv.Atoi(strings.TrimPrefix(payload.Command, "exit "))
+				status = uint32(code)
+				fmt.Fprintf(channel.Stderr(), "exit with %d\n", code)
+			default:
+				fmt.Fprintf(channel, "exec: %s\n", payload.Command)
+			}
+			channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+			return
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// testShell 模拟登录后的 shell: 提示符为 "$ ", 对每行输入回显 "exec: <line>", 输入 exit 时退出
+func testShell(channel ssh.Channel) {
+	r := bufio.NewReader(channel)
+	for {
+		fmt.Fprintf(channel, "$ ")
+		line, err := r.ReadString('\r')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSuffix(line, "\r")
+		if line == "exit" {
+			return
+		}
+		fmt.Fprintf(channel, "%s\r\nexec: %s\r\n", line, line)
+	}
+}
+
+const testBecomePassword = "s3cret"
+
+// becomeTestCommand 模拟 sudo -p <prompt> ... -- sh -c <cmd> 与 su - <user> -c <cmd>: 询问密码, 正确时回显 <cmd>
+func becomeTestCommand(channel ssh.Channel, command string) uint32 {
+	prompt := "Password: "
+	if strings.HasPrefix(command, "sudo -p '") {
+		prompt, _, _ = strings.Cut(strings.TrimPrefix(command, "sudo -p '"), "'")
+	}
+	fmt.Fprintf(channel, "%s", prompt)
+
+	password, err := bufio.NewReader(channel).ReadString('\n')
+	if err != nil {
+		return 1
+	}
+	if strings.TrimSpace(password) != testBecomePassword {
+		fmt.Fprintf(channel, "\nsu: Authentication failure\n")
+		return 1
+	}
+
+	inner := command[strings.Index(command, " -c '")+len(" -c '") : len(command)-1]
+	inner = strings.ReplaceAll(inner, `'\''`, "'")
+	marker, inner, _ := strings.Cut(inner, "; ")
+	fmt.Fprintf(channel, "\n%s\nexec: %s\n", strings.TrimPrefix(marker, "echo "), inner)
+	return 0
+}
+
+// listTestAgent 打开 auth-agent@openssh.com 通道, 输出 agent 中 key 的指纹
+func listTestAgent(conn ssh.Conn, w io.Writer) uint32 {
+	channel, requests, err := conn.OpenChannel("auth-agent@openssh.com", nil)
+	if err != nil {
+		return 1
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+
+	keys, err := agent.NewClient(channel).List()
+	if err != nil {
+		return 1
+	}
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s %s\n", ssh.FingerprintSHA256(key), key.Comment)
+	}
+	return 0
+}
+
+func handleTestDirectTCPIP(newChannel ssh.NewChannel) {
+	var payload struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))), 5*time.Second)
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	go func() {
+		io.Copy(conn, channel)
+		conn.(*net.TCPConn).CloseWrite()
+	}()
+	io.Copy(channel, conn)
+	channel.Close()
+	conn.Close()
+}
+
+// handleTestGlobalRequests 处理 tcpip-forward, 在本机监听并将连接通过 forwarded-tcpip 转给客户端
+func handleTestGlobalRequests(conn ssh.Conn, reqs <-chan *ssh.Request) {
+	listeners := make(map[string]net.Listener)
+	defer func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}()
+
+	for req := range reqs {
+		var payload struct {
+			Addr string
+			Port uint32
+		}
+		if req.Type != "tcpip-forward" && req.Type != "cancel-tcpip-forward" || ssh.Unmarshal(req.Payload, &payload) != nil {
+			req.Reply(false, nil)
+			continue
+		}
+
+		key := net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port)))
+		if req.Type == "cancel-tcpip-forward" {
+			if listener, ok := listeners[key]; ok {
+				listener.Close()
+				delete(listeners, key)
+			}
+			req.Reply(true, nil)
+			continue
+		}
+
+		listener, err := net.Listen("tcp", key)
+		if err != nil {
+			req.Reply(false, nil)
+			continue
+		}
+		port := uint32(listener.Addr().(*net.TCPAddr).Port)
+		listeners[net.JoinHostPort(payload.Addr, strconv.Itoa(int(port)))] = listener
+		req.Reply(true, ssh.Marshal(struct{ Port uint32 }{port}))
+
+		go func() {
+			for {
+				c, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				origin := c.RemoteAddr().(*net.TCPAddr)
+				channel, requests, err := conn.OpenChannel("forwarded-tcpip", ssh.Marshal(struct {
+					Addr       string
+					Port       uint32
+					OriginAddr string
+					OriginPort uint32
+				}{payload.Addr, port, origin.IP.String(), uint32(origin.Port)}))
+				if err != nil {
+					c.Close()
+					continue
+				}
+				go ssh.DiscardRequests(requests)
+				go func() {
+					go func() {
+						io.Copy(channel, c)
+						channel.CloseWrite()
+					}()
+					io.Copy(c, channel)
+					c.Close()
+					channel.Close()
+				}()
+			}
+		}()
+	}
+}
+
+// newEchoServer 启动本机 tcp echo 服务, 返回监听的 host 与 port
+func newEchoServer(t *testing.T) (string, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), strconv.Itoa(addr.Port)
+}
+
+func newTestDialer(t *testing.T) *Dialer {
+	knownHosts, err := OpenKnownHosts(filepath.Join(t.TempDir(), "known_hosts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	knownHosts.Policy = HostKeyAcceptNew
+	return &Dialer{KnownHosts: knownHosts, Timeout: 5 * time.Second}
+}
@@ -0,0 +1,286 @@
+package adapter
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+	"golang.org/x/crypto/ssh"
+)
+
+// SOCKS5, 参见 RFC 1928 与 RFC 1929(用户名/密码认证)
+const (
+	socksVersion = 0x05
+
+	socksMethodNoAuth       = 0x00
+	socksMethodPassword     = 0x02
+	socksMethodNoAcceptable = 0xff
+
+	socksPasswordVersion = 0x01
+
+	socksCmdConnect = 0x01
+
+	socksAtypIPv4   = 0x01
+	socksAtypDomain = 0x03
+	socksAtypIPv6   = 0x04
+
+	socksRepSucceeded         = 0x00
+	socksRepGeneralFailure    = 0x01
+	socksRepConnectionRefused = 0x05
+	socksRepCmdNotSupported   = 0x07
+	socksRepAtypNotSupported  = 0x08
+)
+
+const socksHandshakeTimeout = 30 * time.Second
+
+type SocksOption struct {
+	// Username/Password 均不为空时要求客户端进行用户名/密码认证
+	Username string
+	Password string
+
+	// Logf 用于记录每条代理连接, 为 nil 时不记录
+	Logf func(format string, args ...interface{})
+}
+
+// SocksServer 本地 SOCKS5 代理, 所有目标均通过 machine 的 SSH 连接建立(等同于 ssh -D)
+type SocksServer struct {
+	client   *ssh.Client
+	listener net.Listener
+	option   SocksOption
+
+	closed atomic.Bool
+}
+
+// StartSocks 登录 machine 并在 listen 上启动 SOCKS5 代理
+func StartSocks(dialer *Dialer, machine *assets.Machine, listen string, option SocksOption) (*SocksServer, error) {
+	if (option.Username == "") != (option.Password == "") {
+		return nil, fmt.Errorf("socks username and password must be set together")
+	}
+	if option.Logf == nil {
+		option.Logf = func(string, ...interface{}) {}
+	}
+
+	client, err := dialer.Dial(machine)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("listen failure, nest error: %v, addr: %v", err, listen)
+	}
+
+	s := &SocksServer{client: client, listener: listener, option: option}
+	go s.serve()
+	option.Logf("listen socks5 %v", listener.Addr())
+	return s, nil
+}
+
+func (s *SocksServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Wait 阻塞至 SSH 连接断开或调用 Close
+func (s *SocksServer) Wait() error {
+	err := s.client.Wait()
+	closed := s.closed.Load()
+	s.Close()
+	if closed {
+		return nil
+	}
+	return err
+}
+
+func (s *SocksServer) Close() error {
+	if !s.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	s.listener.Close()
+	return s.client.Close()
+}
+
+func (s *SocksServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !s.closed.Load() {
+				s.option.Logf("accept failure, nest error: %v", err)
+			}
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *SocksServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+	r := bufio.NewReader(conn)
+	if err := s.negotiate(r, conn); err != nil {
+		s.option.Logf("socks handshake failure, nest error: %v, origin: %v", err, conn.RemoteAddr())
+		return
+	}
+	addr, err := readSocksRequest(r, conn)
+	if err != nil {
+		s.option.Logf("socks request failure, nest error: %v, origin: %v", err, conn.RemoteAddr())
+		return
+	}
+
+	dest, err := s.client.Dial("tcp", addr)
+	if err != nil {
+		rep := byte(socksRepGeneralFailure)
+		var openErr *ssh.OpenChannelError
+		if errors.As(err, &openErr) && openErr.Reason == ssh.ConnectionFailed {
+			rep = socksRepConnectionRefused
+		}
+		writeSocksReply(conn, rep)
+		s.option.Logf("connect failure, nest error: %v, target: %v, origin: %v", err, addr, conn.RemoteAddr())
+		return
+	}
+	defer dest.Close()
+
+	if err := writeSocksReply(conn, socksRepSucceeded); err != nil {
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	// 握手阶段客户端可能已经发送了部分数据
+	if n := r.Buffered(); n != 0 {
+		data, _ := r.Peek(n)
+		if _, err := dest.Write(data); err != nil {
+			return
+		}
+	}
+
+	s.option.Logf("open socks5 -> %v, origin: %v", addr, conn.RemoteAddr())
+	sent, received := pipe(conn, dest)
+	s.option.Logf("close socks5 -> %v, origin: %v, sent: %d, received: %d", addr, conn.RemoteAddr(), sent, received)
+}
+
+// negotiate 协商认证方式, 并在需要时校验用户名/密码
+func (s *SocksServer) negotiate(r *bufio.Reader, w io.Writer) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	if header[0] != socksVersion {
+		return fmt.Errorf("unsupported socks version: %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return err
+	}
+
+	want := byte(socksMethodNoAuth)
+	if s.option.Username != "" {
+		want = socksMethodPassword
+	}
+	accepted := false
+	for _, method := range methods {
+		if method == want {
+			accepted = true
+			break
+		}
+	}
+	if !accepted {
+		w.Write([]byte{socksVersion, socksMethodNoAcceptable})
+		return fmt.Errorf("no acceptable auth method, want: %d, offered: %v", want, methods)
+	}
+	if _, err := w.Write([]byte{socksVersion, want}); err != nil {
+		return err
+	}
+	if want == socksMethodNoAuth {
+		return nil
+	}
+
+	// RFC 1929: VER ULEN UNAME PLEN PASSWD
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	if header[0] != socksPasswordVersion {
+		return fmt.Errorf("unsupported auth version: %d", header[0])
+	}
+	username := make([]byte, header[1])
+	if _, err := io.ReadFull(r, username); err != nil {
+		return err
+	}
+	plen, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	password := make([]byte, plen)
+	if _, err := io.ReadFull(r, password); err != nil {
+		return err
+	}
+
+	userOK := subtle.ConstantTimeCompare(username, []byte(s.option.Username))
+	passOK := subtle.ConstantTimeCompare(password, []byte(s.option.Password))
+	if userOK&passOK != 1 {
+		w.Write([]byte{socksPasswordVersion, 0x01})
+		return fmt.Errorf("auth failure, username: %s", username)
+	}
+	_, err = w.Write([]byte{socksPasswordVersion, 0x00})
+	return err
+}
+
+// readSocksRequest 读取 CONNECT 请求, 返回目标地址 host:port
+func readSocksRequest(r *bufio.Reader, w io.Writer) (string, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", err
+	}
+	if header[0] != socksVersion {
+		return "", fmt.Errorf("unsupported socks version: %d", header[0])
+	}
+	if header[1] != socksCmdConnect {
+		writeSocksReply(w, socksRepCmdNotSupported)
+		return "", fmt.Errorf("unsupported socks command: %d", header[1])
+	}
+
+	var host string
+	switch header[3] {
+	case socksAtypIPv4, socksAtypIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if header[3] == socksAtypIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socksAtypDomain:
+		n, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		domain := make([]byte, n)
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		writeSocksReply(w, socksRepAtypNotSupported)
+		return "", fmt.Errorf("unsupported address type: %d", header[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// writeSocksReply 回复请求结果, SSH 通道没有真实的本地地址, BND.ADDR 固定为 0.0.0.0:0
+func writeSocksReply(w io.Writer, rep byte) error {
+	_, err := w.Write([]byte{socksVersion, rep, 0x00, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
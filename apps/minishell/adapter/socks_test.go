@@ -0,0 +1,104 @@
+package adapter
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSocks(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		server     = newTestServer(t, "root", "root-password")
+		host, port = newEchoServer(t)
+	)
+	socks, err := StartSocks(newTestDialer(t), server.machine("root", "root-password"), "127.0.0.1:0", SocksOption{Username: "user", Password: "pass"})
+	assert.Nil(err)
+	defer socks.Close()
+
+	// 域名形式的目标地址
+	conn, rep, err := dialSocks(socks.Addr().String(), "user", "pass", "localhost", port)
+	assert.Nil(err)
+	assert.Equal(byte(socksRepSucceeded), rep)
+	_, err = conn.Write([]byte("hello"))
+	assert.Nil(err)
+	conn.(*net.TCPConn).CloseWrite()
+	data, err := io.ReadAll(conn)
+	assert.Nil(err)
+	assert.Equal("hello", string(data))
+	conn.Close()
+
+	// 目标端口未监听
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	closed := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	listener.Close()
+	_, rep, err = dialSocks(socks.Addr().String(), "user", "pass", host, closed)
+	assert.Nil(err)
+	assert.Equal(byte(socksRepConnectionRefused), rep)
+
+	// 密码错误
+	_, _, err = dialSocks(socks.Addr().String(), "user", "wrong", host, port)
+	assert.NotNil(err)
+
+	socks.Close()
+	assert.Nil(socks.Wait())
+}
+
+// dialSocks 最小的 SOCKS5 客户端, 返回建立的连接与 REP
+func dialSocks(addr, username, password, host, port string) (net.Conn, byte, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, 0, err
+	}
+	fail := func(err error) (net.Conn, byte, error) {
+		conn.Close()
+		return nil, 0, err
+	}
+
+	reply := make([]byte, 2)
+	conn.Write([]byte{socksVersion, 1, socksMethodPassword})
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fail(err)
+	}
+	auth := append([]byte{socksPasswordVersion, byte(len(username))}, username...)
+	auth = append(append(auth, byte(len(password))), password...)
+	conn.Write(auth)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fail(err)
+	}
+	if reply[1] != 0x00 {
+		return fail(io.ErrUnexpectedEOF)
+	}
+
+	n, _ := strconv.Atoi(port)
+	request := append([]byte{socksVersion, socksCmdConnect, 0x00}, socksAtypDomain, byte(len(host)))
+	request = binary.BigEndian.AppendUint16(append(request, host...), uint16(n))
+	conn.Write(request)
+	reply = make([]byte, 10)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fail(err)
+	}
+	return conn, reply[1], nil
+}
+
+func TestSocksConnectionLost(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newTestServer(t, "root", "root-password")
+	socks, err := StartSocks(newTestDialer(t), server.machine("root", "root-password"), "127.0.0.1:0", SocksOption{})
+	assert.Nil(err)
+
+	server.drop()
+	assert.NotNil(socks.Wait())
+
+	socks, err = StartSocks(newTestDialer(t), server.machine("root", "root-password"), "127.0.0.1:0", SocksOption{})
+	assert.Nil(err)
+	socks.Close()
+	assert.Nil(socks.Wait())
+}
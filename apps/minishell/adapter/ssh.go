@@ -1,75 +1,29 @@
 package adapter
 
 import (
+	"errors"
 	"fmt"
 	"io"
-	"net"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/term"
 )
 
-func InteractiveWithTerminalForSSH(username, password, privateKeyPath string, host string, port int, timeout time.Duration, changePS1 bool) error {
-	authMethods := make([]ssh.AuthMethod, 0, 4)
-
-	if privateKeyPath != "" {
-		pk, err := os.ReadFile(privateKeyPath)
-		if err != nil {
-			return err
-		}
-		signer, err := ssh.ParsePrivateKey([]byte(pk))
-		if err != nil {
-			return err
-		}
-		authMethods = append(authMethods, ssh.PublicKeys(signer))
-	}
-	if password != "" {
-		authMethods = append(authMethods, ssh.KeyboardInteractive(setKeyboard(password)))
-		authMethods = append(authMethods, ssh.Password(password))
-	}
-
-	config := ssh.ClientConfig{
-		User: username,
-		Auth: authMethods,
-		Config: ssh.Config{
-			Ciphers: []string{
-				"aes128-ctr",
-				"aes192-ctr",
-				"aes256-ctr",
-				"aes128-gcm@openssh.com",
-				"arcfour256",
-				"arcfour128",
-				"aes128-cbc",
-			},
-			KeyExchanges: []string{
-				"diffie-hellman-group-exchange-sha1",
-				"diffie-hellman-group1-sha1",
-				"diffie-hellman-group-exchange-sha256",
-				"diffie-hellman-group16-sha512",
-				"diffie-hellman-group18-sha512",
-				"diffie-hellman-group14-sha256",
-				"diffie-hellman-group14-sha1",
-				"curve25519-sha256",
-				"kex-strict-s-v00@openssh.com",
-			},
-		},
-		Timeout: timeout,
-		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-			return nil
-		},
-	}
-
-	connection, err := ssh.Dial("tcp", net.JoinHostPort(host, fmt.Sprintf("%d", port)), &config)
+// InteractiveWithTerminalForSSH 登录 machine 并打开交互式 shell, 依次执行 steps(参见 assets.LoginSteps),
+// cast 不为 nil 时将会话以 asciicast v2 格式记录到 cast.
+// 连接意外断开时返回 ErrConnectionLost, 调用方可以重新调用以重连
+func InteractiveWithTerminalForSSH(dialer *Dialer, machine *assets.Machine, steps []*assets.Step, cast io.Writer) error {
+	connection, err := dialer.Dial(machine)
 	if err != nil {
 		return err
 	}
 	defer connection.Close()
 
-	session, err := connection.NewSession()
+	session, err := dialer.NewSession(connection, machine)
 	if err != nil {
 		return err
 	}
@@ -117,36 +71,74 @@ func InteractiveWithTerminalForSSH(username, password, privateKeyPath string, ho
 		return err
 	}
 
-	go io.Copy(os.Stderr, stderr)
-	go io.Copy(os.Stdout, stdout)
+	var (
+		outw io.Writer = os.Stdout
+		errw io.Writer = os.Stderr
+	)
+	var recorder *Recorder
+	if cast != nil {
+		recorder, err = NewRecorder(cast, w, h, fmt.Sprintf("%s@%s", machine.Username, machine.Host()))
+		if err != nil {
+			return err
+		}
+		defer recorder.Close()
+
+		outw = io.MultiWriter(os.Stdout, recorder)
+		errw = io.MultiWriter(os.Stderr, recorder)
+	}
+
+	var watcher *promptWatcher
+	if len(steps) != 0 || machine.Become != nil {
+		watcher = newPromptWatcher()
+		outw = io.MultiWriter(outw, watcher)
+	}
+
+	go io.Copy(errw, stderr)
+	go io.Copy(outw, stdout)
 
 	if err = session.Shell(); err != nil {
 		return err
 	}
 
-	if changePS1 {
-		time.Sleep(1 * time.Second)
-		ps1 := fmt.Sprintf("export PS1=\"[%s] $PS1\"", host)
-		for _, b := range []byte(ps1) {
-			if _, err := stdin.Write([]byte{b}); err != nil {
-				return err
-			}
+	// 切换用户成功后才将会话交给用户
+	if machine.Become != nil {
+		if err := becomeInteractive(watcher, stdin, machine); err != nil {
+			return err
 		}
-		stdin.Write([]byte{'\r'})
 	}
 
-	go io.Copy(stdin, os.Stdin)
+	// 步骤失败时不中断会话, 由用户继续操作
+	if len(steps) != 0 {
+		if err := runSteps(watcher, stdin, machine, steps); err != nil {
+			fmt.Fprintf(os.Stderr, "\r\n==> Warning: %v\r\n", err)
+		}
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	sharedStdin.start()
+	go sharedStdin.copyTo(stdin, done)
 
 	signal_chan := make(chan os.Signal, 1)
 	signal.Notify(signal_chan, syscall.SIGWINCH, syscall.SIGQUIT, syscall.SIGTERM)
+	defer signal.Stop(signal_chan)
 	go func() {
 		for {
-			s := <-signal_chan
+			var s os.Signal
+			select {
+			case <-done:
+				return
+			case s = <-signal_chan:
+			}
 			switch s {
 			case syscall.SIGWINCH:
 				fd := int(os.Stdout.Fd())
 				w, h, _ = term.GetSize(fd)
 				session.WindowChange(h, w)
+				if recorder != nil {
+					recorder.Resize(w, h)
+				}
 			default:
 				session.Signal(ssh.SIGTERM)
 				return
@@ -154,16 +146,10 @@ func InteractiveWithTerminalForSSH(username, password, privateKeyPath string, ho
 		}
 	}()
 
-	err = session.Wait()
-	return nil
-}
-
-func setKeyboard(password string) func(user, instruction string, questions []string, echos []bool) (answers []string, err error) {
-	return func(_, _ string, questions []string, _ []bool) (answers []string, err error) {
-		answers = make([]string, len(questions))
-		for n := range questions {
-			answers[n] = password
-		}
-		return answers, nil
+	// 远端正常退出时会发送 exit-status, 连接中断时没有
+	var exitMissing *ssh.ExitMissingError
+	if err = session.Wait(); errors.As(err, &exitMissing) || err == io.EOF {
+		return ErrConnectionLost
 	}
+	return nil
 }
@@ -0,0 +1,92 @@
+package adapter
+
+import (
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// stdinPump 由唯一的 goroutine 读取 os.Stdin, 在第一次交互式会话时启动.
+// os.Stdin 的读取无法取消, 会话结束后残留的 io.Copy(session, os.Stdin) 会吞掉之后的输入(如重连提示的回答),
+// 因此交互式会话与终端提示都从这里读取
+type stdinPump struct {
+	once    sync.Once
+	started atomic.Bool
+	ch      chan []byte
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+var sharedStdin = &stdinPump{}
+
+// stdin 返回读取终端输入的 reader, 交互式会话开始后为 sharedStdin
+func stdin() io.Reader {
+	if sharedStdin.started.Load() {
+		return sharedStdin
+	}
+	return os.Stdin
+}
+
+func (p *stdinPump) start() {
+	p.once.Do(func() {
+		p.ch = make(chan []byte)
+		p.started.Store(true)
+		go func() {
+			for {
+				buf := make([]byte, 1024)
+				n, err := os.Stdin.Read(buf)
+				if n > 0 {
+					p.ch <- buf[:n]
+				}
+				if err != nil {
+					close(p.ch)
+					return
+				}
+			}
+		}()
+	})
+}
+
+func (p *stdinPump) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	if len(p.buf) == 0 {
+		p.mu.Unlock()
+		data, ok := <-p.ch
+		if !ok {
+			return 0, io.EOF
+		}
+		p.mu.Lock()
+		p.buf = data
+	}
+	n := copy(b, p.buf)
+	p.buf = p.buf[n:]
+	p.mu.Unlock()
+	return n, nil
+}
+
+// copyTo 将输入写入 w, 直到 done 关闭或写入失败
+func (p *stdinPump) copyTo(w io.Writer, done <-chan struct{}) {
+	for {
+		p.mu.Lock()
+		data := p.buf
+		p.buf = nil
+		p.mu.Unlock()
+
+		if len(data) == 0 {
+			var ok bool
+			select {
+			case <-done:
+				return
+			case data, ok = <-p.ch:
+				if !ok {
+					return
+				}
+			}
+		}
+		if _, err := w.Write(data); err != nil {
+			return
+		}
+	}
+}
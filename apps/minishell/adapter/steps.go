@@ -0,0 +1,97 @@
+package adapter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+)
+
+// ansiEscape 终端控制序列(颜色、光标移动、窗口标题等), 匹配提示符前去除
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[()][0-9A-Za-z]|\x1b[=>]`)
+
+const promptBufferSize = 4096
+
+// promptWatcher 记录会话最近的输出, 用于等待提示符
+type promptWatcher struct {
+	mu     sync.Mutex
+	buf    []byte
+	notify chan struct{}
+}
+
+func newPromptWatcher() *promptWatcher {
+	return &promptWatcher{notify: make(chan struct{}, 1)}
+}
+
+func (w *promptWatcher) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	w.buf = append(w.buf, b...)
+	if len(w.buf) > promptBufferSize {
+		w.buf = w.buf[len(w.buf)-promptBufferSize:]
+	}
+	w.mu.Unlock()
+
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+	return len(b), nil
+}
+
+// wait 等待输出以 re 匹配的内容结尾, 匹配后清空已记录的输出, 使下一步只匹配之后的输出
+func (w *promptWatcher) wait(re *regexp.Regexp, timeout time.Duration) error {
+	_, _, err := w.waitAny([]*regexp.Regexp{re}, timeout)
+	return err
+}
+
+// waitAny 等待输出匹配 res 中的任意一个, 多个同时匹配时取靠前的, 返回其下标与匹配所在行从行首到匹配结束的内容
+func (w *promptWatcher) waitAny(res []*regexp.Regexp, timeout time.Duration) (int, string, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		w.mu.Lock()
+		text := ansiEscape.ReplaceAll(w.buf, nil)
+		for i, re := range res {
+			if loc := re.FindIndex(text); loc != nil {
+				begin := bytes.LastIndexAny(text[:loc[0]], "\r\n") + 1
+				w.buf = w.buf[:0]
+				w.mu.Unlock()
+				return i, string(text[begin:loc[1]]), nil
+			}
+		}
+		w.mu.Unlock()
+
+		select {
+		case <-w.notify:
+		case <-timer.C:
+			patterns := make([]string, 0, len(res))
+			for _, re := range res {
+				patterns = append(patterns, re.String())
+			}
+			return -1, "", fmt.Errorf("wait for prompt %q timeout after %v", strings.Join(patterns, " | "), timeout)
+		}
+	}
+}
+
+// runSteps 依次等待每一步的提示符并发送内容, 超时时返回错误, 不再执行后续步骤
+func runSteps(w *promptWatcher, stdin io.Writer, machine *assets.Machine, steps []*assets.Step) error {
+	for i, step := range steps {
+		re, err := step.Prompt()
+		if err != nil {
+			return fmt.Errorf("step %d failure, nest error: %v", i+1, err)
+		}
+		if err := w.wait(re, step.Wait()); err != nil {
+			return fmt.Errorf("step %d failure, nest error: %v", i+1, err)
+		}
+		if _, err := io.WriteString(stdin, step.Line(machine)+"\r"); err != nil {
+			return fmt.Errorf("step %d failure, nest error: %v", i+1, err)
+		}
+	}
+	return nil
+}
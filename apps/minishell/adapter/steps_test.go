@@ -0,0 +1,58 @@
+package adapter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeShell 收到一行输入后输出 reply 中对应的内容, 模拟登录后的终端
+type fakeShell struct {
+	out     *promptWatcher
+	lines   []string
+	replies []string
+}
+
+func (s *fakeShell) Write(b []byte) (int, error) {
+	s.lines = append(s.lines, strings.TrimSuffix(string(b), "\r"))
+	if len(s.replies) != 0 {
+		reply := s.replies[0]
+		s.replies = s.replies[1:]
+		go s.out.Write([]byte(reply))
+	}
+	return len(b), nil
+}
+
+func TestRunSteps(t *testing.T) {
+	assert := assert.New(t)
+
+	machine := &assets.Machine{IP: "10.0.0.1", Username: "root"}
+	steps := []*assets.Step{
+		{Expect: `Password:\s*$`, Send: "secret"},
+		{Send: "cd /data"},
+		{Send: "export PS1=\"[{{host}}] $PS1\""},
+	}
+
+	watcher := newPromptWatcher()
+	shell := &fakeShell{out: watcher, replies: []string{"\r\n\x1b[01;32mroot@web\x1b[00m:\x1b[01;34m~\x1b[00m# ", "\r\n\x1b]0;root@web:/data\x07root@web:/data# "}}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		watcher.Write([]byte("Last login: Mon Oct 12\r\nsudo su -\r\nPassword: "))
+	}()
+	assert.Nil(runSteps(watcher, shell, machine, steps))
+	assert.Equal([]string{"secret", "cd /data", `export PS1="[10.0.0.1] $PS1"`}, shell.lines)
+
+	// 提示符未出现时超时, 不再执行后续步骤
+	watcher = newPromptWatcher()
+	shell = &fakeShell{out: watcher}
+	watcher.Write([]byte("Welcome\r\n"))
+	begin := time.Now()
+	err := runSteps(watcher, shell, machine, []*assets.Step{{Send: "uptime", Timeout: 200 * time.Millisecond}, {Send: "exit"}})
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "step 1")
+	assert.True(time.Since(begin) >= 200*time.Millisecond)
+	assert.Empty(shell.lines)
+}
@@ -0,0 +1,446 @@
+package adapter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// telnet 命令与选项, 参见 RFC 854、RFC 1091(TTYPE)、RFC 1073(NAWS)
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	telnetOptEcho  = 1
+	telnetOptSGA   = 3
+	telnetOptTType = 24
+	telnetOptNAWS  = 31
+
+	telnetTTypeIS   = 0
+	telnetTTypeSend = 1
+)
+
+const (
+	telnetStateData = iota
+	telnetStateIAC
+	telnetStateOption
+	telnetStateSB
+	telnetStateSBIAC
+)
+
+// telnetConn 在 tcp 连接上处理 telnet 协议: Read 返回去除命令后的数据并自动应答选项协商, Write 转义数据.
+// 同意服务端的 ECHO、SGA, 提供终端类型(TTYPE)与窗口大小(NAWS), 拒绝其它选项
+type telnetConn struct {
+	net.Conn
+	termType string
+
+	wmu           sync.Mutex
+	width, height int
+	naws          bool
+	will          map[byte]bool
+	do            map[byte]bool
+
+	// 以下字段只在 Read 中使用
+	state int
+	verb  byte
+	sb    []byte
+	cr    bool
+}
+
+func newTelnetConn(conn net.Conn, termType string, width, height int) *telnetConn {
+	return &telnetConn{
+		Conn:     conn,
+		termType: termType,
+		width:    width,
+		height:   height,
+		will:     make(map[byte]bool),
+		do:       make(map[byte]bool),
+	}
+}
+
+func (c *telnetConn) Read(b []byte) (int, error) {
+	raw := make([]byte, len(b))
+	for {
+		n, err := c.Conn.Read(raw)
+		k := c.parse(raw[:n], b)
+		if k > 0 || err != nil {
+			return k, err
+		}
+	}
+}
+
+// parse 处理从服务端读取的 raw, 数据写入 out, 返回数据长度
+func (c *telnetConn) parse(raw, out []byte) int {
+	var k int
+	for _, x := range raw {
+		switch c.state {
+		case telnetStateData:
+			switch {
+			case x == telnetIAC:
+				c.state = telnetStateIAC
+			case c.cr && x == 0:
+				// CR NUL 表示单独的回车
+				c.cr = false
+			default:
+				out[k] = x
+				k++
+				c.cr = x == '\r'
+			}
+		case telnetStateIAC:
+			switch x {
+			case telnetIAC:
+				out[k] = x
+				k++
+				c.state = telnetStateData
+			case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+				c.verb, c.state = x, telnetStateOption
+			case telnetSB:
+				c.sb, c.state = c.sb[:0], telnetStateSB
+			default:
+				// NOP、GA 等命令忽略
+				c.state = telnetStateData
+			}
+		case telnetStateOption:
+			c.negotiate(c.verb, x)
+			c.state = telnetStateData
+		case telnetStateSB:
+			if x == telnetIAC {
+				c.state = telnetStateSBIAC
+			} else {
+				c.sb = append(c.sb, x)
+			}
+		case telnetStateSBIAC:
+			switch x {
+			case telnetSE:
+				c.subnegotiate(c.sb)
+				c.state = telnetStateData
+			case telnetIAC:
+				c.sb = append(c.sb, x)
+				c.state = telnetStateSB
+			default:
+				c.state = telnetStateSB
+			}
+		}
+	}
+	return k
+}
+
+// negotiate 应答选项协商, 只在状态变化时应答, 避免协商循环
+func (c *telnetConn) negotiate(verb, option byte) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	switch verb {
+	case telnetDO:
+		switch option {
+		case telnetOptTType, telnetOptNAWS, telnetOptSGA:
+			if !c.will[option] {
+				c.will[option] = true
+				c.writeRaw(telnetIAC, telnetWILL, option)
+			}
+			if option == telnetOptNAWS {
+				c.naws = true
+				c.writeNAWS()
+			}
+		default:
+			c.writeRaw(telnetIAC, telnetWONT, option)
+		}
+	case telnetDONT:
+		if c.will[option] {
+			c.will[option] = false
+			c.writeRaw(telnetIAC, telnetWONT, option)
+		}
+		if option == telnetOptNAWS {
+			c.naws = false
+		}
+	case telnetWILL:
+		switch option {
+		case telnetOptEcho, telnetOptSGA:
+			if !c.do[option] {
+				c.do[option] = true
+				c.writeRaw(telnetIAC, telnetDO, option)
+			}
+		default:
+			c.writeRaw(telnetIAC, telnetDONT, option)
+		}
+	case telnetWONT:
+		if c.do[option] {
+			c.do[option] = false
+			c.writeRaw(telnetIAC, telnetDONT, option)
+		}
+	}
+}
+
+func (c *telnetConn) subnegotiate(sb []byte) {
+	if len(sb) < 2 || sb[0] != telnetOptTType || sb[1] != telnetTTypeSend {
+		return
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	data := []byte{telnetIAC, telnetSB, telnetOptTType, telnetTTypeIS}
+	data = append(data, strings.ToUpper(c.termType)...)
+	c.writeRaw(append(data, telnetIAC, telnetSE)...)
+}
+
+// writeNAWS 发送窗口大小, 调用方需持有 wmu
+func (c *telnetConn) writeNAWS() {
+	data := []byte{telnetIAC, telnetSB, telnetOptNAWS}
+	for _, v := range []int{c.width, c.height} {
+		for _, x := range []byte{byte(v >> 8), byte(v)} {
+			if data = append(data, x); x == telnetIAC {
+				data = append(data, x)
+			}
+		}
+	}
+	c.writeRaw(append(data, telnetIAC, telnetSE)...)
+}
+
+// writeRaw 调用方需持有 wmu; 协商失败说明连接已断开, 由 Read 返回错误
+func (c *telnetConn) writeRaw(data ...byte) {
+	c.Conn.Write(data)
+}
+
+// Write 转义 IAC, 单独的回车按 NVT 规范发送为 CR NUL
+func (c *telnetConn) Write(b []byte) (int, error) {
+	data := make([]byte, 0, len(b)+8)
+	for i, x := range b {
+		data = append(data, x)
+		switch {
+		case x == telnetIAC:
+			data = append(data, telnetIAC)
+		case x == '\r' && (i+1 == len(b) || b[i+1] != '\n'):
+			data = append(data, 0)
+		}
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if _, err := c.Conn.Write(data); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Resize 窗口大小变化, 服务端同意 NAWS 时通知服务端
+func (c *telnetConn) Resize(width, height int) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.width, c.height = width, height
+	if c.naws {
+		c.writeNAWS()
+	}
+}
+
+// DialTelnet 建立到 machine 的 tcp 连接, 配置了跳板机时经 ssh 隧道连接, 关闭返回的连接时一并关闭跳板机连接.
+// 网络错误时按 Retries、Backoff 重试
+func (d *Dialer) DialTelnet(machine *assets.Machine) (net.Conn, error) {
+	for attempt := 0; ; attempt++ {
+		conn, err := d.dialTelnet(machine)
+		if err == nil {
+			return conn, nil
+		}
+		if attempt >= d.Retries || !retryable(err) {
+			return nil, err
+		}
+		time.Sleep(backoff(d.Backoff, attempt))
+	}
+}
+
+func (d *Dialer) dialTelnet(machine *assets.Machine) (net.Conn, error) {
+	via, err := d.dialChain(machine.JumpChain)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := machine.Addr()
+	if via == nil {
+		conn, err := net.DialTimeout("tcp", addr, d.timeout(machine))
+		if err != nil {
+			return nil, fmt.Errorf("dial %s failure, nest error: %w", addr, err)
+		}
+		return conn, nil
+	}
+
+	conn, err := via.Dial("tcp", addr)
+	if err != nil {
+		via.Close()
+		return nil, fmt.Errorf("tunnel to %s failure, nest error: %w", addr, err)
+	}
+	return &tunnelConn{Conn: conn, via: via}, nil
+}
+
+// tunnelConn 经跳板机建立的连接, 关闭时一并关闭跳板机
+type tunnelConn struct {
+	net.Conn
+	via *ssh.Client
+}
+
+func (c *tunnelConn) Close() error {
+	err := c.Conn.Close()
+	c.via.Close()
+	return err
+}
+
+var (
+	// telnetLoginPrompt 登录用户名提示, 如 "login: "、"Username:"
+	telnetLoginPrompt = regexp.MustCompile(`(?i)(login|user ?name|用户名)[^\r\n:：]*[:：]\s*$`)
+	// telnetLoginFailure 登录失败时的输出
+	telnetLoginFailure = regexp.MustCompile(`(?i)[^\r\n]*(login incorrect|login invalid|login failed|authentication failed|bad password|access denied|认证失败)[^\r\n]*`)
+)
+
+// telnetLogin 回答用户名与密码提示, 直到出现提示符; 未配置用户名与密码的设备直接出现提示符
+func telnetLogin(w *promptWatcher, conn io.Writer, machine *assets.Machine) error {
+	var sentUser, sentPassword bool
+	for {
+		i, match, err := w.waitAny([]*regexp.Regexp{telnetLoginFailure, becomePasswordPrompt, telnetLoginPrompt, shellPrompt}, assets.DefaultStepTimeout)
+		if err != nil {
+			return fmt.Errorf("telnet login failure, nest error: %v", err)
+		}
+		switch i {
+		case 0:
+			return fmt.Errorf("telnet login failure: %s", strings.TrimSpace(match))
+		case 1:
+			if sentPassword {
+				return fmt.Errorf("telnet login failure: password rejected")
+			}
+			password, err := machine.RevealPassword()
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(conn, password+"\r"); err != nil {
+				return err
+			}
+			sentPassword = true
+		case 2:
+			if sentUser {
+				return fmt.Errorf("telnet login failure: login rejected")
+			}
+			if machine.Username == "" {
+				return fmt.Errorf("telnet login failure: username required")
+			}
+			if _, err := io.WriteString(conn, machine.Username+"\r"); err != nil {
+				return err
+			}
+			sentUser = true
+		default:
+			// 保留提示符, 供之后的登录步骤匹配
+			w.Write([]byte(match))
+			return nil
+		}
+	}
+}
+
+// InteractiveWithTerminalForTelnet 以 telnet 登录 machine 并打开交互式终端, 自动回答用户名与密码提示, 其余行为与 InteractiveWithTerminalForSSH 一致.
+// 连接意外断开时返回 ErrConnectionLost, 服务端正常关闭连接时返回 nil
+func InteractiveWithTerminalForTelnet(dialer *Dialer, machine *assets.Machine, steps []*assets.Step, cast io.Writer) error {
+	raw, err := dialer.DialTelnet(machine)
+	if err != nil {
+		return err
+	}
+	defer raw.Close()
+
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+
+	w, h, err := term.GetSize(fd)
+	if err != nil {
+		return err
+	}
+
+	termType := os.Getenv("TERM")
+	if termType == "" {
+		termType = "xterm-256color"
+	}
+	conn := newTelnetConn(raw, termType, w, h)
+
+	watcher := newPromptWatcher()
+	outw := io.MultiWriter(os.Stdout, watcher)
+	var recorder *Recorder
+	if cast != nil {
+		recorder, err = NewRecorder(cast, w, h, fmt.Sprintf("%s@%s", machine.Username, machine.Host()))
+		if err != nil {
+			return err
+		}
+		defer recorder.Close()
+		outw = io.MultiWriter(os.Stdout, watcher, recorder)
+	}
+
+	closed := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(outw, conn)
+		closed <- err
+	}()
+
+	if err := telnetLogin(watcher, conn, machine); err != nil {
+		return err
+	}
+	if machine.Become != nil {
+		if err := becomeInteractive(watcher, conn, machine); err != nil {
+			return err
+		}
+	}
+	if len(steps) != 0 {
+		if err := runSteps(watcher, conn, machine, steps); err != nil {
+			fmt.Fprintf(os.Stderr, "\r\n==> Warning: %v\r\n", err)
+		}
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	sharedStdin.start()
+	go sharedStdin.copyTo(conn, done)
+
+	signal_chan := make(chan os.Signal, 1)
+	signal.Notify(signal_chan, syscall.SIGWINCH, syscall.SIGQUIT, syscall.SIGTERM)
+	defer signal.Stop(signal_chan)
+	go func() {
+		for {
+			var s os.Signal
+			select {
+			case <-done:
+				return
+			case s = <-signal_chan:
+			}
+			switch s {
+			case syscall.SIGWINCH:
+				w, h, _ = term.GetSize(int(os.Stdout.Fd()))
+				conn.Resize(w, h)
+				if recorder != nil {
+					recorder.Resize(w, h)
+				}
+			default:
+				raw.Close()
+				return
+			}
+		}
+	}()
+
+	// io.Copy 读到 EOF 时返回 nil, 说明服务端关闭了连接(如 logout)
+	if err := <-closed; err != nil && !errors.Is(err, net.ErrClosed) {
+		return ErrConnectionLost
+	}
+	return nil
+}
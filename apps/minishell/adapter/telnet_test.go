@@ -0,0 +1,211 @@
+package adapter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+	"github.com/stretchr/testify/assert"
+)
+
+// testTelnetServer 进程内的 telnet 设备替身: 协商 TTYPE、NAWS、ECHO、SGA, 询问用户名与密码,
+// 登录后对每行输入回显 "exec: <line>" 与提示符 "<switch>"
+type testTelnetServer struct {
+	addr     string
+	password string
+
+	mu       sync.Mutex
+	commands []string
+	lines    []string
+}
+
+func newTestTelnetServer(t *testing.T, password string) *testTelnetServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &testTelnetServer{addr: listener.Addr().String(), password: password}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *testTelnetServer) machine(username, password string) *assets.Machine {
+	host, port, _ := net.SplitHostPort(s.addr)
+	p, _ := strconv.Atoi(port)
+	return &assets.Machine{IP: host, Port: p, Username: username, Password: password, Protocol: assets.ProtocolTelnet}
+}
+
+func (s *testTelnetServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	lines := make(chan string, 16)
+	go s.read(conn, lines)
+
+	conn.Write([]byte{telnetIAC, telnetDO, telnetOptTType, telnetIAC, telnetDO, telnetOptNAWS, telnetIAC, telnetWILL, telnetOptEcho, telnetIAC, telnetWILL, telnetOptSGA, telnetIAC, telnetDO, 39})
+	conn.Write([]byte{telnetIAC, telnetSB, telnetOptTType, telnetTTypeSend, telnetIAC, telnetSE})
+	fmt.Fprintf(conn, "\r\nUser Access Verification\r\n\r\nUsername:")
+	user := <-lines
+	fmt.Fprintf(conn, "%s\r\nPassword:", user)
+	if password := <-lines; password != s.password {
+		fmt.Fprintf(conn, "\r\n%% Login failed!\r\n")
+		return
+	}
+	fmt.Fprintf(conn, "\r\n<switch>")
+	for line := range lines {
+		if line == "quit" {
+			return
+		}
+		fmt.Fprintf(conn, "%s\r\nexec: %s\r\n<switch>", line, line)
+	}
+}
+
+// read 解析客户端发送的内容, 协商命令记录在 commands 中, 以 CR NUL 或 CR LF 结尾的行发送到 lines
+func (s *testTelnetServer) read(conn net.Conn, lines chan<- string) {
+	defer close(lines)
+
+	var (
+		r    = bufio.NewReader(conn)
+		line []byte
+	)
+	for {
+		x, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		switch x {
+		case telnetIAC:
+			verb, _ := r.ReadByte()
+			if verb == telnetIAC {
+				line = append(line, verb)
+				continue
+			}
+			if verb != telnetSB {
+				option, _ := r.ReadByte()
+				s.record(fmt.Sprintf("%d %d", verb, option))
+				continue
+			}
+			sb, _ := r.ReadBytes(telnetSE)
+			s.record(fmt.Sprintf("SB %v", bytes.ReplaceAll(sb[:len(sb)-2], []byte{telnetIAC, telnetIAC}, []byte{telnetIAC})))
+		case '\r':
+			r.ReadByte()
+			s.mu.Lock()
+			s.lines = append(s.lines, string(line))
+			s.mu.Unlock()
+			lines <- string(line)
+			line = line[:0]
+		default:
+			line = append(line, x)
+		}
+	}
+}
+
+func (s *testTelnetServer) record(command string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands = append(s.commands, command)
+}
+
+func (s *testTelnetServer) snapshot() ([]string, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...), append([]string(nil), s.lines...)
+}
+
+func TestTelnet(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		server  = newTestTelnetServer(t, "pass\xffword")
+		machine = server.machine("admin", "pass\xffword")
+	)
+	// 经 ssh 跳板机连接
+	hop := newTestServer(t, "root", "root-password")
+	machine.JumpChain = []*assets.Machine{hop.machine("root", "root-password")}
+
+	raw, err := newTestDialer(t).DialTelnet(machine)
+	if !assert.Nil(err) {
+		return
+	}
+	defer raw.Close()
+
+	conn := newTelnetConn(raw, "xterm-256color", 80, 24)
+	watcher := newPromptWatcher()
+	var (
+		output strings.Builder
+		mu     sync.Mutex
+	)
+	closed := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.MultiWriter(watcher, writerFunc(func(b []byte) (int, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return output.Write(b)
+		})), conn)
+		closed <- err
+	}()
+
+	assert.Nil(telnetLogin(watcher, conn, machine))
+	assert.Nil(runSteps(watcher, conn, machine, []*assets.Step{{Expect: `<switch>$`, Send: "screen-length disable"}, {Send: "display version"}}))
+	assert.Nil(watcher.wait(shellPrompt, time.Second))
+	conn.Resize(120, 255)
+	conn.Write([]byte("quit\r"))
+
+	select {
+	case err := <-closed:
+		assert.Nil(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("telnet session not closed")
+	}
+
+	commands, lines := server.snapshot()
+	assert.Equal([]string{"admin", "pass\xffword", "screen-length disable", "display version", "quit"}, lines)
+	assert.Equal([]string{
+		fmt.Sprintf("%d %d", telnetWILL, telnetOptTType),
+		fmt.Sprintf("%d %d", telnetWILL, telnetOptNAWS),
+		"SB [31 0 80 0 24]",
+		fmt.Sprintf("%d %d", telnetDO, telnetOptEcho),
+		fmt.Sprintf("%d %d", telnetDO, telnetOptSGA),
+		fmt.Sprintf("%d %d", telnetWONT, 39),
+		"SB [24 0 88 84 69 82 77 45 50 53 54 67 79 76 79 82]",
+		"SB [31 0 120 0 255]",
+	}, commands)
+	mu.Lock()
+	assert.Contains(output.String(), "exec: display version\r\n<switch>")
+	mu.Unlock()
+
+	// 密码错误
+	machine = server.machine("admin", "wrong")
+	raw, err = newTestDialer(t).DialTelnet(machine)
+	if !assert.Nil(err) {
+		return
+	}
+	defer raw.Close()
+	conn = newTelnetConn(raw, "vt100", 80, 24)
+	watcher = newPromptWatcher()
+	go io.Copy(watcher, conn)
+	err = telnetLogin(watcher, conn, machine)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "Login failed")
+
+	// 非交互式命令不支持 telnet
+	_, err = ExecWithSSH(newTestDialer(t), machine, "uptime", io.Discard, io.Discard, time.Second)
+	assert.NotNil(err)
+}
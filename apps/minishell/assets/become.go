@@ -0,0 +1,147 @@
+package assets
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	BecomeSudo = "sudo"
+	BecomeSu   = "su"
+)
+
+// Become 登录后切换用户, 如普通用户登录后执行 sudo -i 或 su -
+type Become struct {
+	// Method sudo 或 su
+	Method string `toml:"method" json:"method" yaml:"method"`
+	// User 目标用户, 默认 root
+	User string `toml:"user,omitempty" json:"user,omitempty" yaml:"user,omitempty"`
+	// Password sudo 为登录用户的密码, su 为目标用户的密码; 为空时使用登录密码
+	Password string `toml:"password,omitempty" json:"password,omitempty" yaml:"password,omitempty"`
+
+	// sealedPassword 从 vault 加载的密码, 以进程内密钥加密保存
+	sealedPassword *Sealed
+}
+
+// ParseBecome 解析 method[:user], 如 sudo、su:oracle
+func ParseBecome(s string) (*Become, error) {
+	method, user, _ := strings.Cut(strings.TrimSpace(s), ":")
+	become := &Become{Method: strings.ToLower(method), User: user}
+	if err := become.Validate(); err != nil {
+		return nil, err
+	}
+	return become, nil
+}
+
+// String 返回 method[:user], 与 ParseBecome 对应
+func (b *Become) String() string {
+	if b == nil {
+		return ""
+	}
+	if b.User == "" {
+		return b.Method
+	}
+	return b.Method + ":" + b.User
+}
+
+func (b *Become) Validate() error {
+	if b.Method != BecomeSudo && b.Method != BecomeSu {
+		return fmt.Errorf("invalid become method: %q, must be sudo or su", b.Method)
+	}
+	if strings.ContainsAny(b.User, " \t'\"") {
+		return fmt.Errorf("invalid become user: %q", b.User)
+	}
+	return nil
+}
+
+// TargetUser 切换到的用户
+func (b *Become) TargetUser() string {
+	if b.User == "" {
+		return "root"
+	}
+	return b.User
+}
+
+// HasPassword 是否配置了提权密码
+func (b *Become) HasPassword() bool {
+	return b.sealedPassword != nil || (b.Password != "" && b.Password != NotExist)
+}
+
+// RevealPassword 返回明文提权密码, 未配置时返回空字符串
+func (b *Become) RevealPassword() (string, error) {
+	if b.sealedPassword != nil {
+		return b.sealedPassword.Open()
+	}
+	if b.Password == NotExist {
+		return "", nil
+	}
+	return b.Password, nil
+}
+
+// SetPassword 修改提权密码, 替换从 vault 加载的密码
+func (b *Become) SetPassword(password string) {
+	b.Password, b.sealedPassword = password, nil
+}
+
+// BecomePassword 返回提权使用的密码, 未配置 Become.Password 时使用登录密码
+func (m *Machine) BecomePassword() (string, error) {
+	if m.Become != nil && m.Become.HasPassword() {
+		return m.Become.RevealPassword()
+	}
+	return m.RevealPassword()
+}
+
+// Command 返回交互式会话中切换用户的命令
+func (b *Become) Command() string {
+	if b.Method == BecomeSu {
+		return "su - " + b.TargetUser()
+	}
+	return "sudo -i -u " + b.TargetUser()
+}
+
+// WrapCommand 返回以目标用户执行 command 的命令, prompt 为 sudo 询问密码时输出的提示
+func (b *Become) WrapCommand(command, prompt string) string {
+	if b.Method == BecomeSu {
+		return "su - " + b.TargetUser() + " -c " + ShellQuote(command)
+	}
+	return "sudo -p " + ShellQuote(prompt) + " -H -u " + b.TargetUser() + " -- sh -c " + ShellQuote(command)
+}
+
+// ShellQuote 以单引号包围 s, 作为 sh 的一个参数
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// setBecome 表格中 become 列的值, 保留已读取的密码
+func (m *Machine) setBecome(v string) error {
+	if v == "" || v == NotExist {
+		return nil
+	}
+	become, err := ParseBecome(v)
+	if err != nil {
+		return err
+	}
+	if m.Become != nil {
+		become.Password, become.sealedPassword = m.Become.Password, m.Become.sealedPassword
+	}
+	m.Become = become
+	return nil
+}
+
+// setBecomePassword 表格中 become-password 列的值, 与 become 列的顺序无关
+func (m *Machine) setBecomePassword(v string) {
+	if v == "" || v == NotExist {
+		return
+	}
+	if m.Become == nil {
+		m.Become = &Become{}
+	}
+	m.Become.SetPassword(v)
+}
+
+func (m *Machine) configuredBecomePassword() string {
+	if m.Become == nil {
+		return ""
+	}
+	return m.Become.Password
+}
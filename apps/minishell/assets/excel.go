@@ -0,0 +1,548 @@
+package assets
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+const excelTitle = "ssh 登录信息表格（空白处，请填‘无’）"
+
+// excelField xlsx 中的一列, name 为写入时的表头, aliases 为读取时可识别的表头(规范化后, 参见 normalizeHeader)
+type excelField struct {
+	name    string
+	aliases []string
+	get     func(m *Machine) string
+	set     func(m *Machine, v string) error
+}
+
+// excelFields 写入时按此顺序排列; 读取时按表头匹配, 列的顺序与缺失的列不影响读取
+var excelFields = []*excelField{
+	{
+		name:    "内网访问地址(LOCAL-IP)",
+		aliases: []string{"ip", "local-ip", "内网访问地址", "内网地址", "内网ip", "ip地址", "host"},
+		get:     func(m *Machine) string { return m.IP },
+		set:     func(m *Machine, v string) error { m.IP = v; return nil },
+	},
+	{
+		name:    "外网访问地址(NAT-IP)",
+		aliases: []string{"nat-ip", "natip", "外网访问地址", "外网地址", "外网ip", "公网ip"},
+		get:     func(m *Machine) string { return m.NatIP },
+		set:     func(m *Machine, v string) error { m.NatIP = v; return nil },
+	},
+	{
+		name:    "端口",
+		aliases: []string{"port", "端口"},
+		get:     func(m *Machine) string { return strconv.Itoa(m.Port) },
+		set: func(m *Machine, v string) (err error) {
+			if v != NotExist {
+				m.Port, err = strconv.Atoi(v)
+			}
+			return err
+		},
+	},
+	{
+		name:    "用户名",
+		aliases: []string{"username", "user", "用户名", "用户"},
+		get:     func(m *Machine) string { return m.Username },
+		set:     func(m *Machine, v string) error { m.Username = v; return nil },
+	},
+	{
+		name:    "密码",
+		aliases: []string{"password", "密码"},
+		get:     func(m *Machine) string { return m.Password },
+		set:     func(m *Machine, v string) error { m.Password = v; return nil },
+	},
+	{
+		name:    "私钥地址",
+		aliases: []string{"private-key", "key", "私钥", "私钥地址", "私钥路径"},
+		get:     func(m *Machine) string { return m.PrivateKeyPath },
+		set:     func(m *Machine, v string) error { m.PrivateKeyPath = v; return nil },
+	},
+	{
+		name:    "设备",
+		aliases: []string{"device", "设备", "设备类型"},
+		get:     func(m *Machine) string { return m.Device },
+		set:     func(m *Machine, v string) error { m.Device = v; return nil },
+	},
+	{
+		name:    "备注",
+		aliases: []string{"remark", "备注", "说明"},
+		get:     func(m *Machine) string { return m.Remark },
+		set:     func(m *Machine, v string) error { m.Remark = v; return nil },
+	},
+	{
+		name:    "跳板机",
+		aliases: []string{"jump", "跳板机"},
+		get:     func(m *Machine) string { return strings.Join(m.Jump, ",") },
+		set:     func(m *Machine, v string) error { m.Jump = splitList(v); return nil },
+	},
+	{
+		name:    "标签",
+		aliases: []string{"tags", "tag", "标签"},
+		get:     func(m *Machine) string { return strings.Join(m.Tags, ",") },
+		set:     func(m *Machine, v string) error { m.Tags = splitList(v); return nil },
+	},
+	{
+		name:    "分组",
+		aliases: []string{"group", "分组"},
+		get:     func(m *Machine) string { return m.Group },
+		set: func(m *Machine, v string) error {
+			if v != NotExist {
+				m.Group = v
+			}
+			return nil
+		},
+	},
+	{
+		name:    "超时",
+		aliases: []string{"timeout", "超时"},
+		get:     func(m *Machine) string { return formatTimeout(m.Timeout) },
+		set: func(m *Machine, v string) (err error) {
+			if v != NotExist {
+				m.Timeout, err = time.ParseDuration(v)
+			}
+			return err
+		},
+	},
+	{
+		name:    "转发 Agent",
+		aliases: []string{"forward-agent", "转发agent"},
+		get:     func(m *Machine) string { return formatBool(m.ForwardAgent) },
+		set:     func(m *Machine, v string) error { m.ForwardAgent = parseBool(v); return nil },
+	},
+	{
+		name:    "私钥密码",
+		aliases: []string{"passphrase", "私钥密码"},
+		get:     func(m *Machine) string { return m.Passphrase },
+		set:     func(m *Machine, v string) error { m.Passphrase = v; return nil },
+	},
+	{
+		name:    "私钥密码命令",
+		aliases: []string{"passphrase-command", "私钥密码命令"},
+		get:     func(m *Machine) string { return m.PassphraseCommand },
+		set:     func(m *Machine, v string) error { m.PassphraseCommand = v; return nil },
+	},
+	{
+		name:    "提权方式",
+		aliases: []string{"become", "提权方式", "提权"},
+		get:     func(m *Machine) string { return m.Become.String() },
+		set:     func(m *Machine, v string) error { return m.setBecome(v) },
+	},
+	{
+		name:    "提权密码",
+		aliases: []string{"become-password", "提权密码"},
+		get:     func(m *Machine) string { return m.configuredBecomePassword() },
+		set:     func(m *Machine, v string) error { m.setBecomePassword(v); return nil },
+	},
+	{
+		name:    "协议",
+		aliases: []string{"protocol", "协议", "登录协议"},
+		get:     func(m *Machine) string { return m.Protocol },
+		set: func(m *Machine, v string) error {
+			if v != NotExist {
+				m.Protocol = v
+			}
+			return nil
+		},
+	},
+	{
+		name:    "证书",
+		aliases: []string{"certificate", "证书", "用户证书"},
+		get:     func(m *Machine) string { return m.Certificate },
+		set: func(m *Machine, v string) error {
+			if v != NotExist {
+				m.Certificate = v
+			}
+			return nil
+		},
+	},
+	{
+		name:    "登录步骤",
+		aliases: []string{"steps", "登录步骤", "步骤"},
+		get: func(m *Machine) string {
+			steps, _ := formatSteps(m.Steps)
+			return steps
+		},
+		set: func(m *Machine, v string) (err error) {
+			m.Steps, err = parseSteps(v)
+			return err
+		},
+	},
+}
+
+// excelIgnoredHeaders 可识别但不读取的列, 序号由加载顺序决定
+var excelIgnoredHeaders = []string{"num", "no", "序号", "编号"}
+
+// defaultSheetName 未重命名的 sheet 不作为分组
+var defaultSheetName = regexp.MustCompile(`^(?i:sheet)\d+$`)
+
+// normalizeHeader 转为小写, 去除空白, 统一全角括号与下划线
+func normalizeHeader(s string) string {
+	s = strings.NewReplacer("（", "(", "）", ")", "_", "-").Replace(strings.ToLower(s))
+	return strings.Join(strings.Fields(s), "")
+}
+
+// matchExcelHeader 返回表头对应的字段, 支持 "内网访问地址(LOCAL-IP)" 这样括号内外任一部分匹配的写法
+func matchExcelHeader(header string) (*excelField, bool) {
+	h := normalizeHeader(header)
+	candidates := []string{h}
+	if i := strings.Index(h, "("); i > 0 && strings.HasSuffix(h, ")") {
+		candidates = append(candidates, h[:i], h[i+1:len(h)-1])
+	}
+	for _, c := range candidates {
+		for _, field := range excelFields {
+			if normalizeHeader(field.name) == c {
+				return field, true
+			}
+			for _, alias := range field.aliases {
+				if alias == c {
+					return field, true
+				}
+			}
+		}
+		for _, ignored := range excelIgnoredHeaders {
+			if ignored == c {
+				return nil, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// excelLayout 一个 sheet 的表头: 表头所在行与各列(从 0 开始)对应的字段或自定义属性
+type excelLayout struct {
+	sheet     string
+	headerRow int
+	fields    map[int]*excelField
+	attrs     map[int]string
+	lastCol   int
+}
+
+// parseExcelLayout 在前 10 行中查找包含 IP 列的表头, 找不到时返回 nil
+func parseExcelLayout(sheet string, rows [][]string) *excelLayout {
+	for i := 0; i < len(rows) && i < 10; i++ {
+		layout := &excelLayout{sheet: sheet, headerRow: i + 1, fields: make(map[int]*excelField), attrs: make(map[int]string), lastCol: -1}
+		var hasIP bool
+		for col, cell := range rows[i] {
+			if cell = strings.TrimSpace(cell); cell == "" {
+				continue
+			}
+			layout.lastCol = col
+			field, ok := matchExcelHeader(cell)
+			switch {
+			case field != nil:
+				layout.fields[col] = field
+				hasIP = hasIP || field == excelFields[0]
+			case !ok:
+				layout.attrs[col] = cell
+			}
+		}
+		if hasIP {
+			return layout
+		}
+	}
+	return nil
+}
+
+// parseRow 解析一行数据, IP 为空的行返回 nil
+func (l *excelLayout) parseRow(row []string) (*Machine, error) {
+	cell := func(col int) string {
+		if col < len(row) {
+			return strings.TrimSpace(row[col])
+		}
+		return ""
+	}
+
+	machine := &Machine{}
+	for col, field := range l.fields {
+		if field == excelFields[0] && cell(col) == "" {
+			return nil, nil
+		}
+	}
+	for col, field := range l.fields {
+		if v := cell(col); v != "" {
+			if err := field.set(machine, v); err != nil {
+				return nil, fmt.Errorf("invalid %s: %q", field.name, v)
+			}
+		}
+	}
+	for col, name := range l.attrs {
+		if v := cell(col); v != "" && v != NotExist {
+			if machine.Attrs == nil {
+				machine.Attrs = make(map[string]string)
+			}
+			machine.Attrs[name] = v
+		}
+	}
+	if machine.Become != nil && machine.Become.Method == "" {
+		return nil, fmt.Errorf("提权密码 is set without 提权方式")
+	}
+	if machine.Port == 0 {
+		machine.Port = 22
+	}
+	return machine, nil
+}
+
+// setRow 按表头写入第 row 行(从 1 开始), 表头中没有的自定义属性追加为新列
+func (l *excelLayout) setRow(f *excelize.File, row int, m *Machine) error {
+	set := func(col int, v string) error {
+		cell, err := excelize.CoordinatesToCellName(col+1, row)
+		if err != nil {
+			return err
+		}
+		// 以文本写入, 避免端口、IP 被识别为数字
+		return f.SetCellStr(l.sheet, cell, v)
+	}
+
+	for col, field := range l.fields {
+		if err := set(col, field.get(m)); err != nil {
+			return err
+		}
+	}
+	known := make(map[string]bool, len(l.attrs))
+	for col, name := range l.attrs {
+		known[name] = true
+		if err := set(col, m.Attrs[name]); err != nil {
+			return err
+		}
+	}
+	for _, name := range sortedKeys(m.Attrs) {
+		if known[name] {
+			continue
+		}
+		l.lastCol++
+		l.attrs[l.lastCol] = name
+		cell, err := excelize.CoordinatesToCellName(l.lastCol+1, l.headerRow)
+		if err != nil {
+			return err
+		}
+		if err := f.SetCellStr(l.sheet, cell, name); err != nil {
+			return err
+		}
+		if err := set(l.lastCol, m.Attrs[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadExcelFile 读取所有 sheet, 每个 sheet 按表头识别列, 无法识别的列保存为自定义属性.
+// 重命名过的 sheet 名作为其中 machine 的默认分组. 解析失败的行输出警告后跳过
+func loadExcelFile(path string) ([]*Machine, error) {
+	f, err := excelize.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	machines := make([]*Machine, 0, 128)
+	for _, sheet := range f.GetSheetList() {
+		rows, err := f.GetRows(sheet)
+		if err != nil {
+			return nil, err
+		}
+		layout := parseExcelLayout(sheet, rows)
+		if layout == nil {
+			if len(rows) != 0 {
+				Warnf("no header with ip column in sheet [%s] of [%s], ignored", sheet, path)
+			}
+			continue
+		}
+
+		for i := layout.headerRow; i < len(rows); i++ {
+			machine, err := layout.parseRow(rows[i])
+			if err != nil {
+				Warnf("%v in sheet [%s] row %d of [%s], ignored", err, sheet, i+1, path)
+				continue
+			}
+			if machine == nil {
+				continue
+			}
+			if machine.Group == "" && !defaultSheetName.MatchString(sheet) {
+				machine.Group = sheet
+			}
+			machine.sheet, machine.row = sheet, i+1
+			machines = append(machines, machine)
+		}
+	}
+	for i, machine := range machines {
+		machine.Num = i + 1
+	}
+	return machines, nil
+}
+
+// encodeExcel 第一行为合并的标题, 第二行为表头, 自定义属性按名称排在最后
+func encodeExcel(buf *bytes.Buffer, machines MachineList) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	const sheet = "Sheet1"
+	layout := &excelLayout{sheet: sheet, headerRow: 2, fields: make(map[int]*excelField), attrs: make(map[int]string)}
+	for col, field := range excelFields {
+		layout.fields[col] = field
+		layout.lastCol = col
+	}
+	attrs := make(map[string]string)
+	for _, m := range machines {
+		for name := range m.Attrs {
+			attrs[name] = ""
+		}
+	}
+	for _, name := range sortedKeys(attrs) {
+		layout.lastCol++
+		layout.attrs[layout.lastCol] = name
+	}
+
+	lastCol, err := excelize.ColumnNumberToName(layout.lastCol + 1)
+	if err != nil {
+		return err
+	}
+	if err := f.SetCellValue(sheet, "A1", excelTitle); err != nil {
+		return err
+	}
+	if err := f.MergeCell(sheet, "A1", lastCol+"1"); err != nil {
+		return err
+	}
+	for col := 0; col <= layout.lastCol; col++ {
+		name := layout.attrs[col]
+		if field, ok := layout.fields[col]; ok {
+			name = field.name
+		}
+		cell, err := excelize.CoordinatesToCellName(col+1, layout.headerRow)
+		if err != nil {
+			return err
+		}
+		if err := f.SetCellStr(sheet, cell, name); err != nil {
+			return err
+		}
+	}
+
+	for i, m := range machines {
+		if err := layout.setRow(f, i+3, m); err != nil {
+			return err
+		}
+	}
+	return f.Write(buf)
+}
+
+// patchExcel 在原 xlsx 上修改、删除、追加数据行, 保留标题、表头、样式与其它内容.
+// 新增的 machine 追加到与其分组同名的 sheet, 否则追加到最后一个 machine 所在的 sheet
+func (inv *Inventory) patchExcel() error {
+	f, err := excelize.OpenFile(inv.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var (
+		layouts = make(map[string]*excelLayout)
+		first   *excelLayout
+	)
+	for _, sheet := range f.GetSheetList() {
+		rows, err := f.GetRows(sheet)
+		if err != nil {
+			return err
+		}
+		if layout := parseExcelLayout(sheet, rows); layout != nil {
+			layouts[sheet] = layout
+			if first == nil {
+				first = layout
+			}
+		}
+	}
+	if first == nil {
+		return errCannotPatch
+	}
+
+	current := make(map[*Machine]bool, len(inv.Machines))
+	for _, machine := range inv.Machines {
+		current[machine] = true
+	}
+	loaded := make(map[*Machine]bool, len(inv.loaded))
+	for _, machine := range inv.loaded {
+		loaded[machine] = true
+		if layouts[machine.sheet] == nil {
+			return errCannotPatch
+		}
+	}
+
+	for _, machine := range inv.loaded {
+		if current[machine] && inv.changed(machine) {
+			if err := layouts[machine.sheet].setRow(f, machine.row, machine); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 从下往上删除, 并更新同一 sheet 中其它 machine 的行号
+	removed := make(MachineList, 0, len(inv.loaded))
+	for _, machine := range inv.loaded {
+		if !current[machine] {
+			removed = append(removed, machine)
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].row > removed[j].row })
+	for _, machine := range removed {
+		if err := f.RemoveRow(machine.sheet, machine.row); err != nil {
+			return err
+		}
+		for _, other := range inv.Machines {
+			if other.sheet == machine.sheet && other.row > machine.row {
+				other.row--
+			}
+		}
+	}
+
+	for _, machine := range inv.Machines {
+		if loaded[machine] {
+			continue
+		}
+		layout := layouts[machine.Group]
+		for i := len(inv.Machines) - 1; layout == nil && i >= 0; i-- {
+			if loaded[inv.Machines[i]] {
+				layout = layouts[inv.Machines[i].sheet]
+			}
+		}
+		if layout == nil {
+			layout = first
+		}
+
+		// 插入到该 sheet 最后一条数据之后, 不覆盖表格下方的其它内容
+		row := layout.headerRow
+		for _, other := range inv.Machines {
+			if other.sheet == layout.sheet && other.row > row {
+				row = other.row
+			}
+		}
+		row++
+		if err := f.InsertRows(layout.sheet, row, 1); err != nil {
+			return err
+		}
+		if err := layout.setRow(f, row, machine); err != nil {
+			return err
+		}
+		machine.sheet, machine.row = layout.sheet, row
+	}
+
+	var buf bytes.Buffer
+	if err := f.Write(&buf); err != nil {
+		return err
+	}
+	return writeFile(inv.Path, buf.Bytes(), 0o600)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
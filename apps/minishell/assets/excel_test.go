@@ -0,0 +1,81 @@
+package assets
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestLoadExcelFile(t *testing.T) {
+	assert := assert.New(t)
+
+	f := excelize.NewFile()
+	assert.Nil(f.SetSheetName("Sheet1", "prod"))
+	for i, row := range [][]interface{}{
+		{"生产环境机器"},
+		{"Owner", "User", "Port", "IP", "Tags"},
+		{"alice", "root", "22", "10.0.0.1", "web"},
+		{"bob", "root", "abc", "10.0.0.2"},
+		{},
+		{"", "admin", "", "10.0.0.3"},
+	} {
+		assert.Nil(f.SetSheetRow("prod", fmt.Sprintf("A%d", i+1), &row))
+	}
+	_, err := f.NewSheet("Sheet2")
+	assert.Nil(err)
+	for i, row := range [][]interface{}{
+		{"内网访问地址（LOCAL-IP）", "端口", "用户名", "分组", "序号"},
+		{"10.0.1.1", "2222", "root", "db", "7"},
+	} {
+		assert.Nil(f.SetSheetRow("Sheet2", fmt.Sprintf("A%d", i+1), &row))
+	}
+	_, err = f.NewSheet("notes")
+	assert.Nil(err)
+	assert.Nil(f.SetCellStr("notes", "A1", "nothing here"))
+	path := filepath.Join(t.TempDir(), "machines.xlsx")
+	assert.Nil(f.SaveAs(path))
+	f.Close()
+
+	var warnings []string
+	defer func(warnf func(string, ...interface{})) { Warnf = warnf }(Warnf)
+	Warnf = func(format string, args ...interface{}) { warnings = append(warnings, fmt.Sprintf(format, args...)) }
+
+	machines, err := LoadFile(path)
+	assert.Nil(err)
+	assert.Len(warnings, 2)
+	assert.Contains(warnings[0], "row 4")
+	if !assert.Len(machines, 3) {
+		return
+	}
+	assert.Equal(&Machine{Num: 1, IP: "10.0.0.1", Port: 22, Username: "root", Group: "prod", Tags: []string{"web"}, Attrs: map[string]string{"Owner": "alice"}, Source: path, sheet: "prod", row: 3}, machines[0])
+	assert.Equal("admin", machines[1].Username)
+	assert.Equal(22, machines[1].Port)
+	assert.Nil(machines[1].Attrs)
+	assert.Equal(&Machine{Num: 3, IP: "10.0.1.1", Port: 2222, Username: "root", Group: "db", Source: path, index: 2, sheet: "Sheet2", row: 2}, machines[2])
+
+	// 按表头写回, 新增的 machine 追加到同名分组的 sheet
+	inv, err := OpenInventory(path)
+	assert.Nil(err)
+	inv.Machines[0].Attrs["Owner"] = "carol"
+	inv.Remove(inv.Machines[1:2])
+	assert.Nil(inv.Add(&Machine{IP: "10.0.0.4", Port: 22, Username: "root", Group: "prod", Attrs: map[string]string{"Rack": "A1"}}))
+	_, err = inv.Save()
+	assert.Nil(err)
+
+	f, err = excelize.OpenFile(path)
+	assert.Nil(err)
+	rows, err := f.GetRows("prod")
+	assert.Nil(err)
+	f.Close()
+	assert.Equal([]string{"Owner", "User", "Port", "IP", "Tags", "Rack"}, rows[1])
+	assert.Equal([]string{"carol", "root", "22", "10.0.0.1", "web"}, rows[2])
+	assert.Equal([]string{"", "root", "22", "10.0.0.4", "", "A1"}, rows[3])
+	assert.Equal([]string{"bob", "root", "abc", "10.0.0.2"}, rows[4])
+
+	machines, err = LoadFile(path)
+	assert.Nil(err)
+	assert.Len(machines, 3)
+}
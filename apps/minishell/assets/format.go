@@ -0,0 +1,358 @@
+package assets
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// machineFileExts 支持的机器列表文件格式
+var machineFileExts = []string{".xlsx", ".conf", ".toml", ".json", ".yaml", ".yml", ".csv", ".vault"}
+
+// IsMachineFile 根据扩展名判断是否为支持的机器列表文件
+func IsMachineFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range machineFileExts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// csvColumns csv 首行的列名, 读取时按列名匹配(忽略大小写), 列的顺序与缺失的列不影响读取.
+// 其它列保存为自定义属性, 写入时按名称排在最后; steps 为 JSON 编码的登录步骤
+var csvColumns = []string{"num", "ip", "nat-ip", "port", "username", "password", "private-key", "device", "remark", "jump", "group", "tags", "timeout", "forward-agent", "passphrase", "passphrase-command", "become", "become-password", "protocol", "certificate", "steps"}
+
+func LoadJSONFile(path string) ([]*Machine, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// 兼容 CMDB 直接导出的数组
+	if data := bytes.TrimSpace(buf); len(data) != 0 && data[0] == '[' {
+		var machines []*Machine
+		if err := json.Unmarshal(data, &machines); err != nil {
+			return nil, err
+		}
+		return machines, nil
+	}
+
+	type F struct {
+		Machines []*Machine `json:"machines"`
+	}
+	f := new(F)
+	if err := json.Unmarshal(buf, f); err != nil {
+		return nil, err
+	}
+	return f.Machines, nil
+}
+
+func LoadYAMLFile(path string) ([]*Machine, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var node yaml.Node
+	if err := yaml.Unmarshal(buf, &node); err != nil {
+		return nil, err
+	}
+	if len(node.Content) != 0 && node.Content[0].Kind == yaml.SequenceNode {
+		var machines []*Machine
+		if err := node.Decode(&machines); err != nil {
+			return nil, err
+		}
+		return machines, nil
+	}
+
+	type F struct {
+		Machines []*Machine `yaml:"machines"`
+	}
+	f := new(F)
+	if err := node.Decode(f); err != nil {
+		return nil, err
+	}
+	return f.Machines, nil
+}
+
+func LoadCSVFile(path string) ([]*Machine, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	known := make(map[string]bool, len(csvColumns))
+	for _, name := range csvColumns {
+		known[name] = true
+	}
+	var (
+		index = make(map[string]int, len(records[0]))
+		attrs = make(map[int]string)
+	)
+	for i, name := range records[0] {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		if key := strings.ToLower(name); known[key] {
+			index[key] = i
+		} else if name != "" {
+			attrs[i] = name
+		}
+	}
+	if _, ok := index["ip"]; !ok {
+		return nil, fmt.Errorf("csv header missing column: ip, path: %v", path)
+	}
+
+	machines := make([]*Machine, 0, len(records)-1)
+	for i, record := range records[1:] {
+		get := func(name string) string {
+			if j, ok := index[name]; ok && j < len(record) {
+				return strings.TrimSpace(record[j])
+			}
+			return ""
+		}
+		if get("ip") == "" {
+			continue
+		}
+
+		machine := &Machine{
+			IP:             get("ip"),
+			NatIP:          get("nat-ip"),
+			Username:       get("username"),
+			Password:       get("password"),
+			PrivateKeyPath: get("private-key"),
+			Device:         get("device"),
+			Remark:         get("remark"),
+			Group:          get("group"),
+			Jump:           splitList(get("jump")),
+			Tags:           splitList(get("tags")),
+			ForwardAgent:   parseBool(get("forward-agent")),
+			Protocol:       get("protocol"),
+
+			Passphrase:        get("passphrase"),
+			PassphraseCommand: get("passphrase-command"),
+			Certificate:       get("certificate"),
+		}
+		if v := get("num"); v != "" {
+			if machine.Num, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("parse num failure, nest error: %v, line: %d", err, i+2)
+			}
+		}
+		if v := get("port"); v != "" {
+			if machine.Port, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("parse port failure, nest error: %v, line: %d", err, i+2)
+			}
+		}
+		if v := get("timeout"); v != "" {
+			if machine.Timeout, err = time.ParseDuration(v); err != nil {
+				return nil, fmt.Errorf("parse timeout failure, nest error: %v, line: %d", err, i+2)
+			}
+		}
+		machine.setBecomePassword(get("become-password"))
+		if err := machine.setBecome(get("become")); err != nil {
+			return nil, fmt.Errorf("parse become failure, nest error: %v, line: %d", err, i+2)
+		}
+		if machine.Steps, err = parseSteps(get("steps")); err != nil {
+			return nil, fmt.Errorf("parse steps failure, nest error: %v, line: %d", err, i+2)
+		}
+		for j, name := range attrs {
+			if j < len(record) {
+				if v := strings.TrimSpace(record[j]); v != "" && v != NotExist {
+					if machine.Attrs == nil {
+						machine.Attrs = make(map[string]string)
+					}
+					machine.Attrs[name] = v
+				}
+			}
+		}
+		if machine.Become != nil && machine.Become.Method == "" {
+			return nil, fmt.Errorf("become-password is set without become, line: %d", i+2)
+		}
+		machines = append(machines, machine)
+	}
+	return machines, nil
+}
+
+// WriteFile 按扩展名将 machines 写入 path, 从 vault 加载的密码以明文写入.
+// 写入 .vault 需要主密码, 请使用 WriteVaultFile.
+func WriteFile(path string, machines MachineList) error {
+	machines, err := machines.reveal()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".conf", ".toml":
+		err = encodeToml(&buf, machines)
+	case ".json":
+		encoder := json.NewEncoder(&buf)
+		encoder.SetIndent("", "    ")
+		err = encoder.Encode(map[string]MachineList{"machines": machines})
+	case ".yaml", ".yml":
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		err = encoder.Encode(map[string]MachineList{"machines": machines})
+	case ".csv":
+		err = encodeCSV(&buf, machines)
+	case ".xlsx":
+		err = encodeExcel(&buf, machines)
+	case ".vault":
+		return fmt.Errorf("writing vault requires a passphrase, path: %v", path)
+	default:
+		return fmt.Errorf("not support file, path: %v", path)
+	}
+	if err != nil {
+		return err
+	}
+	return writeFile(path, buf.Bytes(), 0o600)
+}
+
+func encodeCSV(buf *bytes.Buffer, machines MachineList) error {
+	attrs := make(map[string]string)
+	for _, m := range machines {
+		for name := range m.Attrs {
+			attrs[name] = ""
+		}
+	}
+	names := sortedKeys(attrs)
+
+	writer := csv.NewWriter(buf)
+	if err := writer.Write(append(append([]string(nil), csvColumns...), names...)); err != nil {
+		return err
+	}
+	for _, m := range machines {
+		steps, err := formatSteps(m.Steps)
+		if err != nil {
+			return err
+		}
+		record := []string{
+			strconv.Itoa(m.Num),
+			m.IP,
+			m.NatIP,
+			strconv.Itoa(m.Port),
+			m.Username,
+			m.Password,
+			m.PrivateKeyPath,
+			m.Device,
+			m.Remark,
+			strings.Join(m.Jump, ","),
+			m.Group,
+			strings.Join(m.Tags, ","),
+			formatTimeout(m.Timeout),
+			formatBool(m.ForwardAgent),
+			m.Passphrase,
+			m.PassphraseCommand,
+			m.Become.String(),
+			m.configuredBecomePassword(),
+			m.Protocol,
+			m.Certificate,
+			steps,
+		}
+		for _, name := range names {
+			record = append(record, m.Attrs[name])
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func formatTimeout(timeout time.Duration) string {
+	if timeout == 0 {
+		return ""
+	}
+	return timeout.String()
+}
+
+// formatSteps 将登录步骤编码为 JSON, 用于 csv、xlsx 的单元格
+func formatSteps(steps []*Step) (string, error) {
+	if len(steps) == 0 {
+		return "", nil
+	}
+	buf, err := json.Marshal(steps)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func parseSteps(s string) ([]*Step, error) {
+	if s == "" || s == NotExist {
+		return nil, nil
+	}
+	var steps []*Step
+	if err := json.Unmarshal([]byte(s), &steps); err != nil {
+		return nil, err
+	}
+	for _, step := range steps {
+		if _, err := step.Prompt(); err != nil {
+			return nil, err
+		}
+	}
+	return steps, nil
+}
+
+func formatBool(b bool) string {
+	if b {
+		return "true"
+	}
+	return ""
+}
+
+// reveal 返回 machines 的副本, 从 vault 加载的密码、私钥密码与提权密码替换为明文
+func (m MachineList) reveal() (MachineList, error) {
+	machines := make(MachineList, 0, len(m))
+	for _, machine := range m {
+		c := *machine
+		c.JumpChain = nil
+		if err := revealField(&c.Password, &c.sealedPassword); err != nil {
+			return nil, err
+		}
+		if err := revealField(&c.Passphrase, &c.sealedPassphrase); err != nil {
+			return nil, err
+		}
+		if machine.Become != nil {
+			become := *machine.Become
+			if err := revealField(&become.Password, &become.sealedPassword); err != nil {
+				return nil, err
+			}
+			c.Become = &become
+		}
+		machines = append(machines, &c)
+	}
+	return machines, nil
+}
+
+func revealField(plain *string, sealed **Sealed) error {
+	if *sealed == nil {
+		return nil
+	}
+	v, err := (*sealed).Open()
+	if err != nil {
+		return err
+	}
+	*plain, *sealed = v, nil
+	return nil
+}
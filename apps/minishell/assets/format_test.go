@@ -0,0 +1,62 @@
+package assets
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFile(t *testing.T) {
+	assert := assert.New(t)
+
+	machines := MachineList{
+		{Num: 1, IP: "10.0.0.1", NatIP: "1.1.1.1", Port: 22, Username: "root", Password: "p,a\"ss", PrivateKeyPath: NotExist, Device: "Linux", Remark: "跳板机", Group: "ops", Tags: []string{"prod", "bastion"}, Timeout: 5 * time.Second, Attrs: map[string]string{"owner": "ops", "机房": "A1"}},
+		{Num: 2, IP: "10.0.0.2", NatIP: NotExist, Port: 2222, Username: "admin", Password: NotExist, PrivateKeyPath: "~/.ssh/id_rsa", Certificate: "~/.ssh/id_rsa-cert.pub", Device: "h3c", Remark: "core switch", Protocol: "telnet", Jump: []string{"1"}, ForwardAgent: true, Become: &Become{Method: "su", User: "oracle", Password: "o,ra"}, Steps: []*Step{{Expect: `>\s*$`, Send: "screen-length disable", Timeout: 3 * time.Second}, {Send: "display version"}}},
+	}
+	sealed := *machines[0]
+	assert.Nil(sealed.seal())
+
+	dir := t.TempDir()
+	for _, name := range []string{"machines.conf", "machines.json", "machines.yaml", "machines.csv", "machines.xlsx"} {
+		path := filepath.Join(dir, name)
+		assert.Nil(WriteFile(path, MachineList{&sealed, machines[1]}), name)
+
+		loaded, err := LoadFile(path)
+		assert.Nil(err, name)
+		if !assert.Len(loaded, 2, name) {
+			continue
+		}
+		for i, machine := range loaded {
+			assert.Equal(path, machine.Source, name)
+			assert.Equal(i, machine.index, name)
+			machine.JumpChain, machine.Source, machine.index, machine.sheet, machine.row = nil, "", 0, "", 0
+			assert.Equal(machines[i], machine, name)
+		}
+	}
+
+	// CMDB 导出的数组与乱序的 csv 列
+	path := filepath.Join(dir, "cmdb.json")
+	assert.Nil(os.WriteFile(path, []byte(`[{"ip": "10.0.0.9", "port": 22, "tags": ["db"]}]`), 0o644))
+	loaded, err := LoadFile(path)
+	assert.Nil(err)
+	assert.Equal([]string{"db"}, loaded[0].Tags)
+
+	path = filepath.Join(dir, "cmdb.csv")
+	assert.Nil(os.WriteFile(path, []byte("Remark,IP,Port,Tags\nweb,10.0.0.8,22,\"a,b\"\n"), 0o644))
+	loaded, err = LoadFile(path)
+	assert.Nil(err)
+	assert.Equal(&Machine{IP: "10.0.0.8", Port: 22, Remark: "web", Tags: []string{"a", "b"}, Source: path}, loaded[0])
+
+	// 无法识别的 csv 列保存为自定义属性
+	path = filepath.Join(dir, "owner.csv")
+	assert.Nil(os.WriteFile(path, []byte("IP,Port,Owner,Rack\n10.0.0.7,22,alice,无\n"), 0o644))
+	loaded, err = LoadFile(path)
+	assert.Nil(err)
+	assert.Equal(map[string]string{"Owner": "alice"}, loaded[0].Attrs)
+
+	assert.NotNil(WriteFile(filepath.Join(dir, "machines.vault"), machines))
+	assert.NotNil(WriteFile(filepath.Join(dir, "machines.txt"), machines))
+}
@@ -0,0 +1,170 @@
+package assets
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/eviltomorrow/toolbox/lib/system"
+)
+
+const (
+	ExitLogout         = "logout"
+	ExitConnectionLost = "connection lost"
+)
+
+// HistoryEntry 一次登录记录
+type HistoryEntry struct {
+	IP       string        `json:"ip"`
+	Port     int           `json:"port"`
+	Username string        `json:"username"`
+	Remark   string        `json:"remark,omitempty"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	// Exit 结束原因: logout、connection lost 或登录失败的错误信息
+	Exit string `json:"exit"`
+}
+
+// Key 与 mergeFiles 去重使用的 key 一致, 用于关联机器列表中的 machine
+func (e *HistoryEntry) Key() string {
+	return machineKey(e.IP, e.Port, e.Username)
+}
+
+// NewHistoryEntry 返回 machine 从 start 到 end 的登录记录
+func NewHistoryEntry(m *Machine, start, end time.Time, exit string) *HistoryEntry {
+	return &HistoryEntry{
+		IP:       m.IP,
+		Port:     m.Port,
+		Username: m.Username,
+		Remark:   m.Remark,
+		Start:    start,
+		Duration: end.Sub(start).Round(time.Second),
+		Exit:     exit,
+	}
+}
+
+// History 登录记录, 每行一条 json, 只追加
+type History struct {
+	Path string
+}
+
+// OpenHistory 返回 path 的登录记录, path 为空时使用 var/history.jsonl
+func OpenHistory(path string) *History {
+	if path == "" {
+		path = filepath.Join(system.Directory.VarDir, "history.jsonl")
+	}
+	return &History{Path: path}
+}
+
+// Append 追加一条记录
+func (h *History) Append(entry *HistoryEntry) error {
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(h.Path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(buf, '\n'))
+	return err
+}
+
+// Load 按记录顺序返回所有记录, 文件不存在时返回空; 无法解析的行忽略
+func (h *History) Load() ([]*HistoryEntry, error) {
+	f, err := os.Open(h.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := make([]*HistoryEntry, 0, 128)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry := &HistoryEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// HistoryStat 一台 machine 的登录统计
+type HistoryStat struct {
+	Last  *HistoryEntry
+	Count int
+	Total time.Duration
+	// Machine 机器列表中对应的 machine, 已不在列表中时为 nil
+	Machine *Machine
+}
+
+// SummarizeHistory 按 machine 汇总 entries 并关联 machines 中的 machine, 按最近登录时间倒序排列
+func SummarizeHistory(entries []*HistoryEntry, machines MachineList) []*HistoryStat {
+	index := make(map[string]*Machine, len(machines))
+	for _, machine := range machines {
+		index[machineKey(machine.IP, machine.Port, machine.Username)] = machine
+	}
+
+	var (
+		stats  = make([]*HistoryStat, 0, 32)
+		byKey  = make(map[string]*HistoryStat)
+		latest = func(a, b *HistoryEntry) bool { return a.Start.After(b.Start) }
+	)
+	for _, entry := range entries {
+		stat, ok := byKey[entry.Key()]
+		if !ok {
+			stat = &HistoryStat{Last: entry, Machine: index[entry.Key()]}
+			byKey[entry.Key()] = stat
+			stats = append(stats, stat)
+		}
+		stat.Count++
+		stat.Total += entry.Duration
+		if latest(entry, stat.Last) {
+			stat.Last = entry
+		}
+	}
+	sort.SliceStable(stats, func(i, j int) bool { return latest(stats[i].Last, stats[j].Last) })
+	return stats
+}
+
+// SortByCount 按登录次数倒序排列, 次数相同时最近登录的在前
+func SortByCount(stats []*HistoryStat) {
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].Count != stats[j].Count {
+			return stats[i].Count > stats[j].Count
+		}
+		return stats[i].Last.Start.After(stats[j].Last.Start)
+	})
+}
+
+// RecentFirst 返回 machines 的副本, 按 stats 的顺序将登录过的 machine 排在前面, 其余保持原顺序
+func RecentFirst(machines MachineList, stats []*HistoryStat) MachineList {
+	var (
+		sorted = make(MachineList, 0, len(machines))
+		seen   = make(map[*Machine]bool, len(stats))
+	)
+	for _, stat := range stats {
+		if stat.Machine != nil && !seen[stat.Machine] {
+			seen[stat.Machine] = true
+			sorted = append(sorted, stat.Machine)
+		}
+	}
+	for _, machine := range machines {
+		if !seen[machine] {
+			sorted = append(sorted, machine)
+		}
+	}
+	return sorted
+}
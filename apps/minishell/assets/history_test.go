@@ -0,0 +1,72 @@
+package assets
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory(t *testing.T) {
+	assert := assert.New(t)
+
+	history := OpenHistory(filepath.Join(t.TempDir(), "var", "history.jsonl"))
+	entries, err := history.Load()
+	assert.Nil(err)
+	assert.Empty(entries)
+
+	var (
+		machines = MachineList{
+			{Num: 1, IP: "10.0.0.1", Port: 22, Username: "root"},
+			{Num: 2, IP: "10.0.0.2", Port: 22, Username: "root"},
+			{Num: 3, IP: "10.0.0.3", Port: 22, Username: "root"},
+		}
+		removed = &Machine{IP: "10.0.0.9", Port: 22, Username: "admin"}
+		base    = time.Date(2024, 5, 1, 8, 0, 0, 0, time.Local)
+	)
+	for _, login := range []struct {
+		machine *Machine
+		minute  int
+		exit    string
+	}{
+		{machines[1], 0, ExitLogout},
+		{machines[1], 10, ExitConnectionLost},
+		{removed, 20, ExitLogout},
+		{machines[0], 30, "auth failure"},
+		{machines[1], 40, ExitLogout},
+		{machines[2], 50, ExitLogout},
+	} {
+		start := base.Add(time.Duration(login.minute) * time.Minute)
+		assert.Nil(history.Append(NewHistoryEntry(login.machine, start, start.Add(5*time.Minute), login.exit)))
+	}
+	f, err := os.OpenFile(history.Path, os.O_WRONLY|os.O_APPEND, 0o600)
+	assert.Nil(err)
+	f.WriteString("{broken\n")
+	f.Close()
+
+	entries, err = history.Load()
+	assert.Nil(err)
+	assert.Len(entries, 6)
+
+	stats := SummarizeHistory(entries, machines)
+	if !assert.Len(stats, 4) {
+		return
+	}
+	assert.Equal(machines[2], stats[0].Machine)
+	assert.Equal(machines[1], stats[1].Machine)
+	assert.Equal(3, stats[1].Count)
+	assert.Equal(15*time.Minute, stats[1].Total)
+	assert.Equal(ExitLogout, stats[1].Last.Exit)
+	assert.Equal("auth failure", stats[2].Last.Exit)
+	assert.Nil(stats[3].Machine)
+	assert.Equal("10.0.0.9", stats[3].Last.IP)
+
+	assert.Equal(MachineList{machines[2], machines[1], machines[0]}, RecentFirst(machines, stats))
+	assert.Equal(MachineList{machines[1], machines[0], machines[2]}, RecentFirst(machines, stats[1:2]))
+
+	SortByCount(stats)
+	assert.Equal(machines[1], stats[0].Machine)
+	assert.Equal(machines[2], stats[1].Machine)
+}
@@ -0,0 +1,541 @@
+package assets
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Inventory 单个机器列表文件, 用于增删改后按原格式写回
+type Inventory struct {
+	Path     string
+	Machines MachineList
+
+	// loaded 打开时的 machines 及其内容, 用于在写回时定位被修改、删除的条目
+	loaded   MachineList
+	original map[*Machine]string
+	// passphrase 打开 vault 时输入的主密码, 加密保存, 用于写回
+	passphrase *Sealed
+}
+
+// ResolveFile 返回要修改的机器列表文件, path 为空时为 etc 目录下的第一个机器列表文件
+func ResolveFile(path string) (string, error) {
+	if path = strings.TrimSpace(path); path != "" {
+		return path, nil
+	}
+
+	fs, err := MachineFiles()
+	if err != nil {
+		return "", err
+	}
+	return fs[0], nil
+}
+
+func OpenInventory(path string) (*Inventory, error) {
+	path, err := ResolveFile(path)
+	if err != nil {
+		return nil, err
+	}
+	inv := &Inventory{Path: path}
+	if strings.EqualFold(filepath.Ext(path), ".vault") {
+		inv.Machines, inv.passphrase, err = loadVaultInventory(path)
+	} else {
+		inv.Machines, err = LoadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	inv.snapshot()
+	return inv, nil
+}
+
+// OpenInventoryOf 打开 machines 所在的文件, 返回 inventory 以及其中对应的 machine; machines 必须来自同一个文件
+func OpenInventoryOf(machines MachineList) (*Inventory, MachineList, error) {
+	if len(machines) == 0 {
+		return nil, nil, ErrNotFound
+	}
+	source := machines[0].Source
+	for _, machine := range machines {
+		if machine.Source != source {
+			return nil, nil, fmt.Errorf("machines come from several files [%s] and [%s], please specify the file", source, machine.Source)
+		}
+	}
+
+	inv, err := OpenInventory(source)
+	if err != nil {
+		return nil, nil, err
+	}
+	found := make(MachineList, 0, len(machines))
+	for _, machine := range machines {
+		if machine.index >= len(inv.Machines) {
+			return nil, nil, fmt.Errorf("file changed while editing, path: %v", source)
+		}
+		m := inv.Machines[machine.index]
+		if m.IP != machine.IP || m.Port != machine.Port || m.Username != machine.Username {
+			return nil, nil, fmt.Errorf("file changed while editing, path: %v", source)
+		}
+		found = append(found, m)
+	}
+	return inv, found, nil
+}
+
+func (inv *Inventory) snapshot() {
+	inv.loaded = append(MachineList(nil), inv.Machines...)
+	inv.original = make(map[*Machine]string, len(inv.Machines))
+	for _, machine := range inv.Machines {
+		inv.original[machine] = encodeInlineToml(machine)
+	}
+}
+
+// changed 判断打开后 machine 是否被修改
+func (inv *Inventory) changed(machine *Machine) bool {
+	return inv.original[machine] != encodeInlineToml(machine)
+}
+
+// Add 校验并追加 machine
+func (inv *Inventory) Add(machine *Machine) error {
+	for _, m := range inv.Machines {
+		if m.IP == machine.IP && m.Port == machine.Port && m.Username == machine.Username {
+			return fmt.Errorf("machine already exists, ip: %v, port: %v, username: %v", machine.IP, machine.Port, machine.Username)
+		}
+	}
+	if machine.Num == 0 {
+		machine.Num = inv.nextNum()
+	}
+	inv.Machines = append(inv.Machines, machine)
+	return inv.Validate()
+}
+
+// Remove 删除 machines, 返回删除的数量
+func (inv *Inventory) Remove(machines []*Machine) int {
+	var (
+		removed = make(map[*Machine]bool, len(machines))
+		kept    = make(MachineList, 0, len(inv.Machines))
+	)
+	for _, machine := range machines {
+		removed[machine] = true
+	}
+	for _, machine := range inv.Machines {
+		if !removed[machine] {
+			kept = append(kept, machine)
+		}
+	}
+	n := len(inv.Machines) - len(kept)
+	inv.Machines = kept
+	return n
+}
+
+// Validate 校验新增、修改的 machine 以及所有跳板机引用, 未改动的条目不做校验, 避免文件中原有的问题影响修改
+func (inv *Inventory) Validate() error {
+	for i, machine := range inv.Machines {
+		if _, ok := inv.original[machine]; ok && !inv.changed(machine) {
+			continue
+		}
+		if err := machine.Validate(); err != nil {
+			return fmt.Errorf("%v, machine: %d", err, i+1)
+		}
+	}
+	return inv.Machines.resolveJump()
+}
+
+// nextNum 文件中的序号均为 0 时(如 toml 未配置 num)保持为 0, 否则为最大序号加 1
+func (inv *Inventory) nextNum() int {
+	max := 0
+	for _, machine := range inv.Machines {
+		if machine.Num > max {
+			max = machine.Num
+		}
+	}
+	if max == 0 {
+		return 0
+	}
+	return max + 1
+}
+
+// Save 先备份原文件, 再按原格式写回, 返回备份文件路径.
+// toml(每行一个 inline table) 与 xlsx 只修改变化的条目, 保留注释、格式与其它内容; 其它格式整体重写.
+func (inv *Inventory) Save() (string, error) {
+	if err := inv.Validate(); err != nil {
+		return "", err
+	}
+
+	backup, err := backupFile(inv.Path)
+	if err != nil {
+		return "", fmt.Errorf("backup failure, nest error: %v, path: %v", err, inv.Path)
+	}
+
+	switch strings.ToLower(filepath.Ext(inv.Path)) {
+	case ".conf", ".toml":
+		var data []byte
+		data, err = inv.patchToml()
+		if err == nil {
+			err = writeFile(inv.Path, data, 0o600)
+		} else if err == errCannotPatch {
+			err = WriteFile(inv.Path, inv.Machines)
+		}
+	case ".xlsx":
+		if err = inv.patchExcel(); err == errCannotPatch {
+			err = WriteFile(inv.Path, inv.Machines)
+		}
+	case ".vault":
+		err = inv.saveVault()
+	default:
+		err = WriteFile(inv.Path, inv.Machines)
+	}
+	if err != nil {
+		return backup, err
+	}
+	inv.snapshot()
+	return backup, nil
+}
+
+// saveVault 使用打开时输入的主密码写回, 写入前确认主密码与文件仍然匹配
+func (inv *Inventory) saveVault() error {
+	var passphrase []byte
+	if inv.passphrase != nil {
+		p, err := inv.passphrase.Open()
+		if err != nil {
+			return err
+		}
+		passphrase = []byte(p)
+	} else {
+		p, err := VaultPassphrase()
+		if err != nil {
+			return err
+		}
+		passphrase = p
+	}
+	defer wipe(passphrase)
+
+	if _, err := DecryptVaultFile(inv.Path, passphrase); err != nil {
+		return err
+	}
+	return WriteVaultFile(inv.Path, inv.Machines, passphrase)
+}
+
+// Validate 校验地址、端口、私钥与证书文件
+func (m *Machine) Validate() error {
+	if !validHost(m.IP) {
+		return fmt.Errorf("invalid ip: %q", m.IP)
+	}
+	if m.NatIP != "" && m.NatIP != NotExist && !validHost(m.NatIP) {
+		return fmt.Errorf("invalid nat-ip: %q", m.NatIP)
+	}
+	if m.Port <= 0 || m.Port > 65535 {
+		return fmt.Errorf("invalid port: %d", m.Port)
+	}
+	if m.Username == "" {
+		return fmt.Errorf("empty username")
+	}
+	if m.Timeout < 0 {
+		return fmt.Errorf("invalid timeout: %v", m.Timeout)
+	}
+	if m.PrivateKeyPath != "" && m.PrivateKeyPath != NotExist {
+		if _, err := os.Stat(ExpandHome(m.PrivateKeyPath)); err != nil {
+			return fmt.Errorf("private key not found, nest error: %v", err)
+		}
+	}
+	if m.Certificate != "" {
+		if m.PrivateKeyPath == "" || m.PrivateKeyPath == NotExist {
+			return fmt.Errorf("certificate is set without private-key")
+		}
+		if _, err := os.Stat(ExpandHome(m.Certificate)); err != nil {
+			return fmt.Errorf("certificate not found, nest error: %v", err)
+		}
+	}
+	switch strings.ToLower(m.Protocol) {
+	case "", ProtocolSSH, ProtocolTelnet:
+	default:
+		return fmt.Errorf("invalid protocol: %q, must be ssh or telnet", m.Protocol)
+	}
+	if m.Become != nil {
+		if err := m.Become.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, step := range m.Steps {
+		if _, err := step.Prompt(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetPassword 修改密码, 替换从 vault 加载的密码
+func (m *Machine) SetPassword(password string) {
+	m.Password, m.sealedPassword = password, nil
+}
+
+// ExpandHome 将 ~ 开头的路径展开为用户目录
+func ExpandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+func validHost(host string) bool {
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	labels := strings.Split(host, ".")
+	// 顶级域名不会是纯数字, 如 10.0.0.300 是错误的 IP 而不是主机名
+	if _, err := strconv.Atoi(labels[len(labels)-1]); err == nil {
+		return false
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// backupFile 复制 path 为 path.<时间>.bak
+func backupFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	backup := fmt.Sprintf("%s.%s.bak", path, time.Now().Format("20060102-150405.000"))
+	if err := os.WriteFile(backup, data, 0o600); err != nil {
+		return "", err
+	}
+	return backup, nil
+}
+
+var errCannotPatch = fmt.Errorf("cannot patch file")
+
+// patchToml 适用于 machines = [ {...}, {...} ] 且每行一个 inline table 的文件, 按行替换、删除、追加条目
+func (inv *Inventory) patchToml() ([]byte, error) {
+	data, err := os.ReadFile(inv.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		lines   []string
+		entries []int
+		scanner = bufio.NewScanner(bytes.NewReader(data))
+	)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(strings.TrimSpace(line), "{") {
+			entries = append(entries, len(lines))
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) != len(inv.loaded) || len(entries) == 0 {
+		return nil, errCannotPatch
+	}
+
+	current := make(map[*Machine]bool, len(inv.Machines))
+	for _, machine := range inv.Machines {
+		current[machine] = true
+	}
+	loaded := make(map[*Machine]bool, len(inv.loaded))
+	for _, machine := range inv.loaded {
+		loaded[machine] = true
+	}
+
+	var (
+		indent  = lines[entries[0]][:len(lines[entries[0]])-len(strings.TrimLeft(lines[entries[0]], " \t"))]
+		last    = entries[len(entries)-1]
+		removed = make(map[int]bool)
+	)
+	for i, machine := range inv.loaded {
+		if !current[machine] {
+			removed[entries[i]] = true
+			continue
+		}
+		if !inv.changed(machine) {
+			continue
+		}
+		// 保留 inline table 之后的内容, 如逗号与行尾注释
+		line := lines[entries[i]]
+		begin := len(line) - len(strings.TrimLeft(line, " \t"))
+		suffix := ","
+		if end := inlineTableEnd(line[begin:]); end > 0 {
+			suffix = line[begin+end:]
+		}
+		lines[entries[i]] = line[:begin] + encodeInlineToml(machine) + suffix
+	}
+
+	var added []string
+	for _, machine := range inv.Machines {
+		if !loaded[machine] {
+			added = append(added, indent+encodeInlineToml(machine)+",")
+		}
+	}
+
+	var buf bytes.Buffer
+	for i, line := range lines {
+		if !removed[i] {
+			buf.WriteString(line)
+			buf.WriteByte('\n')
+		}
+		if i == last {
+			for _, line := range added {
+				buf.WriteString(line)
+				buf.WriteByte('\n')
+			}
+		}
+	}
+
+	if !bytes.HasSuffix(data, []byte("\n")) {
+		buf.Truncate(buf.Len() - 1)
+	}
+
+	// 确认修改后的内容可以正确解析
+	machines, err := decodeToml(buf.Bytes())
+	if err != nil || len(machines) != len(inv.Machines) {
+		return nil, errCannotPatch
+	}
+	return buf.Bytes(), nil
+}
+
+// inlineTableEnd 返回以 { 开头的 inline table 结束位置(} 之后), 跳过字符串中的括号; 未结束时返回 -1
+func inlineTableEnd(s string) int {
+	var (
+		depth int
+		quote string
+	)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != "":
+			switch {
+			case quote[0] == '"' && c == '\\':
+				i++
+			case strings.HasPrefix(s[i:], quote):
+				i += len(quote) - 1
+				quote = ""
+			}
+		case strings.HasPrefix(s[i:], `"""`), strings.HasPrefix(s[i:], "'''"):
+			quote = s[i : i+3]
+			i += 2
+		case c == '"' || c == '\'':
+			quote = string(c)
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			if depth--; depth == 0 {
+				return i + 1
+			}
+		case c == '#':
+			return -1
+		}
+	}
+	return -1
+}
+
+// encodeInlineToml 编码为与 machines.conf 一致的单行 inline table
+func encodeInlineToml(m *Machine) string {
+	quote := func(s string) string {
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(s)
+		return strings.TrimSuffix(buf.String(), "\n")
+	}
+	quoteList := func(list []string) string {
+		quoted := make([]string, 0, len(list))
+		for _, s := range list {
+			quoted = append(quoted, quote(s))
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	}
+
+	fields := make([]string, 0, 16)
+	if m.Num != 0 {
+		fields = append(fields, "num = "+strconv.Itoa(m.Num))
+	}
+	fields = append(fields,
+		"ip = "+quote(m.IP),
+		"nat-ip = "+quote(m.NatIP),
+		"port = "+strconv.Itoa(m.Port),
+		"username = "+quote(m.Username),
+		"password = "+quote(m.Password),
+		"private-key = "+quote(m.PrivateKeyPath),
+		"device = "+quote(m.Device),
+		"remark = "+quote(m.Remark),
+	)
+	if len(m.Jump) != 0 {
+		fields = append(fields, "jump = "+quoteList(m.Jump))
+	}
+	if m.Group != "" {
+		fields = append(fields, "group = "+quote(m.Group))
+	}
+	if len(m.Tags) != 0 {
+		fields = append(fields, "tags = "+quoteList(m.Tags))
+	}
+	if m.Timeout != 0 {
+		fields = append(fields, "timeout = "+quote(m.Timeout.String()))
+	}
+	if m.ForwardAgent {
+		fields = append(fields, "forward-agent = true")
+	}
+	if m.Protocol != "" {
+		fields = append(fields, "protocol = "+quote(m.Protocol))
+	}
+	if m.Passphrase != "" {
+		fields = append(fields, "passphrase = "+quote(m.Passphrase))
+	}
+	if m.PassphraseCommand != "" {
+		fields = append(fields, "passphrase-command = "+quote(m.PassphraseCommand))
+	}
+	if m.Certificate != "" {
+		fields = append(fields, "certificate = "+quote(m.Certificate))
+	}
+	if m.Become != nil {
+		become := "method = " + quote(m.Become.Method)
+		if m.Become.User != "" {
+			become += ", user = " + quote(m.Become.User)
+		}
+		if m.Become.Password != "" {
+			become += ", password = " + quote(m.Become.Password)
+		}
+		fields = append(fields, "become = {"+become+"}")
+	}
+	if len(m.Steps) != 0 {
+		steps := make([]string, 0, len(m.Steps))
+		for _, step := range m.Steps {
+			line := "{expect = " + quote(step.Expect) + ", send = " + quote(step.Send)
+			if step.Timeout != 0 {
+				line += ", timeout = " + quote(step.Timeout.String())
+			}
+			steps = append(steps, line+"}")
+		}
+		fields = append(fields, "steps = ["+strings.Join(steps, ", ")+"]")
+	}
+	if len(m.Attrs) != 0 {
+		attrs := make([]string, 0, len(m.Attrs))
+		for _, name := range sortedKeys(m.Attrs) {
+			attrs = append(attrs, quote(name)+" = "+quote(m.Attrs[name]))
+		}
+		fields = append(fields, "attrs = {"+strings.Join(attrs, ", ")+"}")
+	}
+	return "{" + strings.Join(fields, ", ") + "}"
+}
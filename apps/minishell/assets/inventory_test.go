@@ -0,0 +1,130 @@
+package assets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInventory(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "machines.conf")
+	data := `# 机器列表
+machines = [
+    # 跳板机
+    {ip = "10.0.0.1",  port = 22, username = "root", password = "1", device = "linux", remark = "bastion"},  # 注释
+    {ip = "10.0.0.2", port = 22, username = "root", password = "2", device = "linux", remark = "web {nginx}"},  # 前端 {nginx}
+    {ip = "10.0.0.3", port = 22, username = "root", password = "3", device = "linux", remark = "db"},
+]
+`
+	assert.Nil(os.WriteFile(path, []byte(data), 0o600))
+
+	inv, err := OpenInventory(path)
+	assert.Nil(err)
+	assert.Nil(inv.Add(&Machine{IP: "10.0.0.4", Port: 22, Username: "admin", Device: "linux", Tags: []string{"new"}}))
+	assert.NotNil(inv.Add(&Machine{IP: "10.0.0.4", Port: 22, Username: "admin"}))
+	inv.Machines[1].Remark = "web-01"
+	assert.Equal(1, inv.Remove(inv.Machines[2:3]))
+
+	backup, err := inv.Save()
+	assert.Nil(err)
+	buf, err := os.ReadFile(backup)
+	assert.Nil(err)
+	assert.Equal(data, string(buf))
+
+	buf, err = os.ReadFile(path)
+	assert.Nil(err)
+	content := string(buf)
+	assert.True(strings.HasPrefix(content, "# 机器列表\nmachines = [\n    # 跳板机\n"))
+	// 未修改的条目保持原样
+	assert.Contains(content, `{ip = "10.0.0.1",  port = 22, username = "root", password = "1", device = "linux", remark = "bastion"},  # 注释`)
+	assert.NotContains(content, "10.0.0.3")
+	// 修改的条目保留行尾注释
+	assert.Contains(content, `remark = "web-01"},  # 前端 {nginx}`)
+
+	loaded, err := LoadFile(path)
+	assert.Nil(err)
+	if assert.Len(loaded, 3) {
+		assert.Equal("web-01", loaded[1].Remark)
+		assert.Equal("10.0.0.4", loaded[2].IP)
+		assert.Equal([]string{"new"}, loaded[2].Tags)
+	}
+
+	// 校验失败时不写入
+	inv, err = OpenInventory(path)
+	assert.Nil(err)
+	for _, machine := range []*Machine{
+		{IP: "10.0.0.300", Port: 22, Username: "root"},
+		{IP: "10.0.0.5", Port: 70000, Username: "root"},
+		{IP: "10.0.0.5", Port: 22, Username: "root", PrivateKeyPath: filepath.Join(dir, "id_rsa")},
+		{IP: "10.0.0.5", Port: 22, Username: "root", Jump: []string{"10.0.0.9"}},
+	} {
+		inv.Machines = inv.loaded
+		assert.NotNil(inv.Add(machine), machine.IP)
+	}
+}
+
+func TestInventoryExcel(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "machines.xlsx")
+	assert.Nil(WriteFile(path, MachineList{
+		{IP: "10.0.0.1", Port: 22, Username: "root", Device: "linux"},
+		{IP: "10.0.0.2", Port: 22, Username: "root", Device: "linux"},
+		{IP: "10.0.0.3", Port: 22, Username: "root", Device: "linux"},
+	}))
+
+	inv, err := OpenInventory(path)
+	assert.Nil(err)
+	inv.Machines[2].Port = 2222
+	inv.Remove(inv.Machines[:1])
+	assert.Nil(inv.Add(&Machine{IP: "10.0.0.4", Port: 22, Username: "root", Device: "linux"}))
+	_, err = inv.Save()
+	assert.Nil(err)
+
+	loaded, err := LoadFile(path)
+	assert.Nil(err)
+	if assert.Len(loaded, 3) {
+		assert.Equal("10.0.0.2", loaded[0].IP)
+		assert.Equal(2222, loaded[1].Port)
+		assert.Equal("10.0.0.4", loaded[2].IP)
+	}
+}
+
+func TestInventoryVault(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "machines.vault")
+	assert.Nil(WriteVaultFile(path, MachineList{{IP: "10.0.0.1", Port: 22, Username: "root", Password: "secret"}}, []byte("passphrase")))
+
+	// 加载、打开与写回只输入一次主密码
+	var prompts int
+	vaultPassphrase := VaultPassphrase
+	t.Cleanup(func() { VaultPassphrase = vaultPassphrase })
+	VaultPassphrase = func() ([]byte, error) {
+		prompts++
+		return []byte("passphrase"), nil
+	}
+
+	machines, err := LoadFile(path)
+	assert.Nil(err)
+	inv, found, err := OpenInventoryOf(machines)
+	assert.Nil(err)
+	assert.Len(found, 1)
+	assert.Nil(inv.Add(&Machine{IP: "10.0.0.2", Port: 22, Username: "admin"}))
+	_, err = inv.Save()
+	assert.Nil(err)
+	assert.Equal(1, prompts)
+
+	machines, err = DecryptVaultFile(path, []byte("passphrase"))
+	assert.Nil(err)
+	if assert.Len(machines, 2) {
+		assert.Equal("secret", machines[0].Password)
+		assert.Equal("10.0.0.2", machines[1].IP)
+	}
+}
@@ -13,7 +13,6 @@ import (
 
 	"github.com/BurntSushi/toml"
 	"github.com/eviltomorrow/toolbox/lib/system"
-	"github.com/xuri/excelize/v2"
 )
 
 var ErrNotFound = errors.New("not found")
@@ -22,19 +21,62 @@ const (
 	NotExist = "无"
 )
 
+const (
+	ProtocolSSH    = "ssh"
+	ProtocolTelnet = "telnet"
+)
+
 type MachineList []*Machine
 
 type Machine struct {
-	Num            int           `toml:"num" json:"num"`
-	NatIP          string        `toml:"nat-ip" json:"nat-ip"`
-	IP             string        `toml:"ip" json:"ip"`
-	Username       string        `toml:"username" json:"username"`
-	Password       string        `toml:"password" json:"password"`
-	Port           int           `toml:"port" json:"port"`
-	Timeout        time.Duration `json:"timeout"`
-	PrivateKeyPath string        `toml:"private-key" json:"private-key"`
-	Device         string        `toml:"device" json:"device"`
-	Remark         string        `toml:"remark" json:"remark"`
+	Num            int           `toml:"num" json:"num" yaml:"num"`
+	NatIP          string        `toml:"nat-ip" json:"nat-ip" yaml:"nat-ip"`
+	IP             string        `toml:"ip" json:"ip" yaml:"ip"`
+	Username       string        `toml:"username" json:"username" yaml:"username"`
+	Password       string        `toml:"password" json:"password" yaml:"password"`
+	Port           int           `toml:"port" json:"port" yaml:"port"`
+	Timeout        time.Duration `toml:"timeout" json:"timeout" yaml:"timeout"`
+	PrivateKeyPath string        `toml:"private-key" json:"private-key" yaml:"private-key"`
+	Device         string        `toml:"device" json:"device" yaml:"device"`
+	Remark         string        `toml:"remark" json:"remark" yaml:"remark"`
+	Jump           []string      `toml:"jump" json:"jump" yaml:"jump,omitempty"`
+	Group          string        `toml:"group" json:"group" yaml:"group"`
+	Tags           []string      `toml:"tags" json:"tags" yaml:"tags,omitempty"`
+	ForwardAgent   bool          `toml:"forward-agent" json:"forward-agent" yaml:"forward-agent,omitempty"`
+
+	// Protocol 登录使用的协议, ssh(默认) 或 telnet
+	Protocol string `toml:"protocol" json:"protocol,omitempty" yaml:"protocol,omitempty"`
+
+	// Passphrase 私钥密码, PassphraseCommand 输出私钥密码的命令(取第一行), 均未配置时在终端输入
+	Passphrase        string `toml:"passphrase" json:"passphrase" yaml:"passphrase,omitempty"`
+	PassphraseCommand string `toml:"passphrase-command" json:"passphrase-command" yaml:"passphrase-command,omitempty"`
+
+	// Certificate 与私钥配对的 OpenSSH 用户证书(-cert.pub), 未配置时使用私钥路径加 -cert.pub 的文件(存在时)
+	Certificate string `toml:"certificate" json:"certificate,omitempty" yaml:"certificate,omitempty"`
+
+	// Become 登录后切换用户, 参见 Become
+	Become *Become `toml:"become,omitempty" json:"become,omitempty" yaml:"become,omitempty"`
+
+	// Steps 登录后依次执行的步骤, 未配置时使用设备配置, 参见 LoginSteps
+	Steps []*Step `toml:"steps,omitempty" json:"steps,omitempty" yaml:"steps,omitempty"`
+
+	// Attrs 自定义属性, 如 xlsx 中无法识别的列
+	Attrs map[string]string `toml:"attrs,omitempty" json:"attrs,omitempty" yaml:"attrs,omitempty"`
+
+	// JumpChain 由 Jump 解析得到的跳板机链路, 按连接顺序排列
+	JumpChain []*Machine `toml:"-" json:"-" yaml:"-"`
+	// Source 加载 machine 的文件
+	Source string `toml:"-" json:"-" yaml:"-"`
+
+	// index machine 在 Source 中的位置, 用于合并列表后写回对应的文件
+	index int
+	// sheet、row 从 xlsx 加载时所在的 sheet 与行号(从 1 开始), 用于写回
+	sheet string
+	row   int
+
+	// sealedPassword、sealedPassphrase 从 vault 加载的密码与私钥密码, 以进程内密钥加密保存
+	sealedPassword   *Sealed
+	sealedPassphrase *Sealed
 }
 
 func (m *Machine) String() string {
@@ -42,35 +84,219 @@ func (m *Machine) String() string {
 	return string(buf)
 }
 
+// Host 返回登录使用的地址, 存在 NAT 地址时优先使用 NAT 地址
+func (m *Machine) Host() string {
+	if m.NatIP != "" && m.NatIP != NotExist {
+		return m.NatIP
+	}
+	return m.IP
+}
+
+// FileName 返回 <user>@<ip>-<port>, 用于区分多台 machine 的文件与目录名; IP 相同而端口或用户不同时也不会冲突
+func (m *Machine) FileName() string {
+	return fmt.Sprintf("%s@%s-%d", m.Username, m.IP, m.Port)
+}
+
+// Addr 返回 host:port, 经跳板机访问时使用内网 IP
+func (m *Machine) Addr() string {
+	host := m.Host()
+	if len(m.JumpChain) != 0 {
+		host = m.IP
+	}
+	return net.JoinHostPort(host, strconv.Itoa(m.Port))
+}
+
+// IsTelnet 是否使用 telnet 登录
+func (m *Machine) IsTelnet() bool {
+	return strings.EqualFold(m.Protocol, ProtocolTelnet)
+}
+
+// HasPassword 是否配置了密码
+func (m *Machine) HasPassword() bool {
+	return m.sealedPassword != nil || (m.Password != "" && m.Password != NotExist)
+}
+
+// RevealPassword 返回明文密码, 未配置时返回空字符串
+func (m *Machine) RevealPassword() (string, error) {
+	if m.sealedPassword != nil {
+		return m.sealedPassword.Open()
+	}
+	if m.Password == NotExist {
+		return "", nil
+	}
+	return m.Password, nil
+}
+
+// HasPassphrase 是否配置了私钥密码
+func (m *Machine) HasPassphrase() bool {
+	return m.sealedPassphrase != nil || (m.Passphrase != "" && m.Passphrase != NotExist)
+}
+
+// RevealPassphrase 返回明文私钥密码, 未配置时返回空字符串
+func (m *Machine) RevealPassphrase() (string, error) {
+	if m.sealedPassphrase != nil {
+		return m.sealedPassphrase.Open()
+	}
+	if m.Passphrase == NotExist {
+		return "", nil
+	}
+	return m.Passphrase, nil
+}
+
+// seal 加密密码、私钥密码与提权密码, 明文字段置空
+func (m *Machine) seal() error {
+	if err := sealField(&m.Password, &m.sealedPassword); err != nil {
+		return err
+	}
+	if err := sealField(&m.Passphrase, &m.sealedPassphrase); err != nil {
+		return err
+	}
+	if m.Become != nil {
+		return sealField(&m.Become.Password, &m.Become.sealedPassword)
+	}
+	return nil
+}
+
+func sealField(plain *string, sealed **Sealed) error {
+	if *plain == "" || *plain == NotExist {
+		return nil
+	}
+	s, err := Seal(*plain)
+	if err != nil {
+		return err
+	}
+	*sealed, *plain = s, ""
+	return nil
+}
+
+// Warnf 输出加载机器列表时的警告
+var Warnf = func(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "==> Warning: "+format+"\r\n", args...)
+}
+
+// MachineFiles 返回 etc 目录下所有支持的机器列表文件, 按文件名排序
+func MachineFiles() ([]string, error) {
+	dir := filepath.Join(system.Directory.RootDir, "etc")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if name := entry.Name(); !entry.IsDir() && IsMachineFile(name) {
+			fs = append(fs, filepath.Join(dir, name))
+		}
+	}
+	if len(fs) == 0 {
+		return nil, fmt.Errorf("no valid machines file")
+	}
+	return fs, nil
+}
+
+// LoadFile 加载 path; path 为空时加载 etc 目录下所有机器列表文件并合并, 参见 mergeFiles
 func LoadFile(path string) (MachineList, error) {
-	machineFile := strings.TrimSpace(path)
+	if path = strings.TrimSpace(path); path != "" {
+		return loadFile(path)
+	}
+
+	fs, err := MachineFiles()
+	if err != nil {
+		return nil, err
+	}
+	return mergeFiles(fs)
+}
 
-	if machineFile == "" {
-		entries, err := os.ReadDir(filepath.Join(system.Directory.RootDir, "etc"))
+// mergeFiles 按文件名顺序合并多个机器列表: IP、端口、用户名相同的条目只保留第一个.
+// 保留文件中配置的序号, 未配置 num 或与先加载的序号重复时按顺序分配未占用的序号.
+// 跳板机引用在各自的文件内按文件中的序号(参见 numbers)解析, 不受重新编号影响
+func mergeFiles(fs []string) (MachineList, error) {
+	var (
+		merged = make(MachineList, 0, 128)
+		seen   = make(map[string]*Machine)
+	)
+	for _, path := range fs {
+		machines, err := loadFile(path)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("load %v failure, nest error: %v", path, err)
 		}
-
-		fs := make([]string, 0, len(entries))
-		for _, entry := range entries {
-			name := entry.Name()
-			if strings.HasSuffix(name, ".xlsx") || strings.HasSuffix(name, ".conf") {
-				fs = append(fs, filepath.Join(system.Directory.RootDir, "etc", name))
+		for _, machine := range machines {
+			key := machineKey(machine.IP, machine.Port, machine.Username)
+			if first, ok := seen[key]; ok {
+				Warnf("duplicate machine %s@%s in [%s], already loaded from [%s], ignored", machine.Username, net.JoinHostPort(machine.IP, strconv.Itoa(machine.Port)), path, first.Source)
+				continue
 			}
+			seen[key] = machine
+			merged = append(merged, machine)
 		}
+	}
 
-		if len(fs) == 0 {
-			return nil, fmt.Errorf("no valid machines file")
+	// 先保留配置的序号, 再为其余的 machine 按顺序分配未占用的序号
+	used := make(map[int]bool, len(merged))
+	kept := make(map[*Machine]bool, len(merged))
+	for _, machine := range merged {
+		if machine.Num > 0 && !used[machine.Num] {
+			used[machine.Num], kept[machine] = true, true
 		}
-		machineFile = fs[0]
 	}
-	if strings.HasSuffix(machineFile, ".xlsx") {
-		return loadExcelFile(machineFile)
+	next := 1
+	for _, machine := range merged {
+		if kept[machine] {
+			continue
+		}
+		for used[next] {
+			next++
+		}
+		if machine.Num > 0 {
+			Warnf("duplicate num %d of %s in [%s], renumbered to %d", machine.Num, machine.IP, machine.Source, next)
+		}
+		machine.Num, used[next] = next, true
 	}
-	if strings.HasSuffix(machineFile, ".conf") {
-		return LoadTomlFile(machineFile)
+	return merged, nil
+}
+
+// machineKey IP、端口、用户名相同的条目视为同一台 machine
+func machineKey(ip string, port int, username string) string {
+	return net.JoinHostPort(ip, strconv.Itoa(port)) + "/" + username
+}
+
+func loadFile(machineFile string) (MachineList, error) {
+	var (
+		machines MachineList
+		err      error
+	)
+	switch strings.ToLower(filepath.Ext(machineFile)) {
+	case ".xlsx":
+		machines, err = loadExcelFile(machineFile)
+	case ".conf", ".toml":
+		machines, err = LoadTomlFile(machineFile)
+	case ".json":
+		machines, err = LoadJSONFile(machineFile)
+	case ".yaml", ".yml":
+		machines, err = LoadYAMLFile(machineFile)
+	case ".csv":
+		machines, err = LoadCSVFile(machineFile)
+	case ".vault":
+		machines, _, err = loadVaultFile(machineFile)
+	default:
+		return nil, fmt.Errorf("not support file, path: %v", machineFile)
+	}
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("not support file, path: %v, file: %v", path, machineFile)
+	return attachSource(machines, machineFile)
+}
+
+// attachSource 记录 machines 所在的文件与位置, 并解析跳板机
+func attachSource(machines MachineList, machineFile string) (MachineList, error) {
+	for i, machine := range machines {
+		machine.Source, machine.index = machineFile, i
+	}
+
+	if err := machines.resolveJump(); err != nil {
+		return nil, err
+	}
+	return machines, nil
 }
 
 func LoadTomlFile(path string) ([]*Machine, error) {
@@ -85,109 +311,111 @@ func LoadTomlFile(path string) ([]*Machine, error) {
 	return f.Machines, nil
 }
 
-func loadExcelFile(path string) ([]*Machine, error) {
-	f, err := excelize.OpenFile(path)
+// Find 按查询条件查找 machine, 语法参见 Query
+func (m MachineList) Find(cond string) ([]*Machine, error) {
+	query, err := ParseQuery(cond)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
 
-	rows, err := f.GetRows("Sheet1")
-	if err != nil {
-		return nil, err
+	machines := query.Filter(m)
+	if len(machines) == 0 {
+		return nil, ErrNotFound
 	}
+	return machines, nil
+}
 
-	var (
-		rowCount, colCount int
-		line               = make([]string, 0, 16)
-		machines           = make([]*Machine, 0, 128)
-	)
-loop:
-	for i, row := range rows {
-		rowCount++
-		if len(row) > 0 && row[0] == "" {
-			continue
+func (m MachineList) resolveJump() error {
+	for i, machine := range m {
+		chain, err := m.jumpChain(machine, map[*Machine]bool{})
+		if err != nil {
+			return fmt.Errorf("resolve jump failure, nest error: %v, machine: %d", err, i+1)
 		}
+		machine.JumpChain = chain
+	}
+	return nil
+}
 
-		for _, col := range row {
-			col = strings.TrimSpace(col)
-			if rowCount == 1 || rowCount == 2 {
-				continue loop
-			}
-			if colCount >= 8 {
-				break
-			}
+// jumpChain 递归展开跳板机, 跳板机自身配置的 jump 排在其前面
+func (m MachineList) jumpChain(machine *Machine, visiting map[*Machine]bool) ([]*Machine, error) {
+	if len(machine.Jump) == 0 {
+		return nil, nil
+	}
+	if visiting[machine] {
+		return nil, fmt.Errorf("jump loop detected, ip: %v", machine.IP)
+	}
+	visiting[machine] = true
+	defer delete(visiting, machine)
 
-			line = append(line, col)
-			colCount++
-		}
-		colCount = 0
-		port, err := strconv.ParseInt(line[2], 10, 32)
+	chain := make([]*Machine, 0, len(machine.Jump))
+	for _, ref := range machine.Jump {
+		hop, err := m.findJump(ref)
 		if err != nil {
 			return nil, err
 		}
-
-		machine := &Machine{
-			Num:            i - 1,
-			IP:             line[0],
-			NatIP:          line[1],
-			Port:           int(port),
-			Username:       line[3],
-			Password:       line[4],
-			PrivateKeyPath: line[5],
-			Device:         line[6],
-			Remark:         line[7],
+		if hop == machine {
+			return nil, fmt.Errorf("jump to itself, ref: %v", ref)
 		}
-		machines = append(machines, machine)
-
-		line = line[:0]
+		hops, err := m.jumpChain(hop, visiting)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, hops...)
+		chain = append(chain, hop)
 	}
-	return machines, nil
+	return chain, nil
 }
 
-func (m MachineList) Find(cond string) ([]*Machine, error) {
-	if no, err := strconv.Atoi(cond); err == nil {
-		if no <= 0 {
-			goto final
-		}
-		if len(m) < no {
-			goto final
+// numbers 返回各 machine 的序号: 配置的 num, 未配置时为在列表中的位置(从 1 开始)
+func (m MachineList) numbers() []int {
+	nos := make([]int, 0, len(m))
+	for i, machine := range m {
+		if machine.Num > 0 {
+			nos = append(nos, machine.Num)
+		} else {
+			nos = append(nos, i+1)
 		}
-		return []*Machine{m[no-1]}, nil
 	}
+	return nos
+}
 
-	if IP := net.ParseIP(cond); IP != nil {
-		machines := make([]*Machine, 0, 4)
-		for _, machine := range m {
-			if machine.NatIP == IP.String() {
-				machines = append(machines, machine)
-				continue
-			}
-			if machine.IP == IP.String() {
-				machines = append(machines, machine)
-				continue
-			}
-		}
-		if len(machines) == 0 {
-			return nil, ErrNotFound
-		}
-		return machines, nil
+// findJump 按序号(参见 numbers)或 IP(含 NAT IP) 查找唯一的跳板机
+func (m MachineList) findJump(ref string) (*Machine, error) {
+	no, err := strconv.Atoi(ref)
+	if err != nil {
+		no = 0
 	}
+	nos := m.numbers()
 
-final:
-	machines := make([]*Machine, 0, 4)
-	for _, machine := range m {
-		if strings.Contains(machine.IP, cond) {
-			machines = append(machines, machine)
+	var found *Machine
+	for i, machine := range m {
+		if no != 0 && nos[i] != no || no == 0 && machine.IP != ref && machine.NatIP != ref {
 			continue
 		}
-		if strings.Contains(machine.NatIP, cond) {
-			machines = append(machines, machine)
-			continue
+		if found != nil {
+			return nil, fmt.Errorf("jump is ambiguous, ref: %v", ref)
 		}
+		found = machine
 	}
-	if len(machines) == 0 {
-		return nil, ErrNotFound
+	if found == nil {
+		return nil, fmt.Errorf("jump not found, ref: %v", ref)
 	}
-	return machines, nil
+	return found, nil
+}
+
+// parseBool 解析表格中的布尔值, 支持 true/yes/y/1/是
+func parseBool(s string) bool {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "true", "yes", "y", "1", "是":
+		return true
+	}
+	return false
+}
+
+// splitList 分割以逗号或空格分隔的列表
+func splitList(s string) []string {
+	if s == "" || s == NotExist {
+		return nil
+	}
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '，' || r == ' ' })
 }
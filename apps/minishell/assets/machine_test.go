@@ -1,9 +1,16 @@
 package assets
 
-import "testing"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
 
 func TestLoadTomlFile(t *testing.T) {
-	machines, err := LoadTomlFile("../etc/machines.conf")
+	machines, err := LoadTomlFile("../conf/etc/machines.conf")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -11,3 +18,107 @@ func TestLoadTomlFile(t *testing.T) {
 		t.Logf("machine: %v\r\n", machine)
 	}
 }
+
+func TestResolveJump(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "machines.conf")
+	assert.Nil(os.WriteFile(path, []byte(`machines = [
+    {ip = "10.0.0.1", nat-ip = "1.1.1.1", port = 22, username = "root"},
+    {ip = "10.0.0.2", port = 22, username = "root", jump = ["1"]},
+    {ip = "10.0.0.3", port = 2222, username = "root", jump = ["10.0.0.2"]},
+]`), 0o644))
+
+	machines, err := LoadFile(path)
+	assert.Nil(err)
+	assert.Len(machines[0].JumpChain, 0)
+	assert.Equal("1.1.1.1:22", machines[0].Addr())
+	assert.Equal([]*Machine{machines[0]}, machines[1].JumpChain)
+	assert.Equal([]*Machine{machines[0], machines[1]}, machines[2].JumpChain)
+	assert.Equal("10.0.0.3:2222", machines[2].Addr())
+
+	assert.Nil(os.WriteFile(path, []byte(`machines = [
+    {ip = "10.0.0.1", port = 22, username = "root", jump = ["2"]},
+    {ip = "10.0.0.2", port = 22, username = "root", jump = ["1"]},
+]`), 0o644))
+	_, err = LoadFile(path)
+	assert.NotNil(err)
+}
+
+func TestMergeFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.conf"), filepath.Join(dir, "b.csv")
+	assert.Nil(os.WriteFile(a, []byte(`machines = [
+    {ip = "10.0.0.1", port = 22, username = "root"},
+    {ip = "10.0.0.2", port = 22, username = "root", jump = ["1"]},
+]`), 0o644))
+	assert.Nil(os.WriteFile(b, []byte("ip,port,username,jump\n10.0.1.1,22,root,\n10.0.0.2,22,root,\n10.0.1.2,22,admin,1\n"), 0o644))
+
+	var warnings []string
+	defer func(warnf func(string, ...interface{})) { Warnf = warnf }(Warnf)
+	Warnf = func(format string, args ...interface{}) { warnings = append(warnings, fmt.Sprintf(format, args...)) }
+
+	machines, err := mergeFiles([]string{a, b})
+	assert.Nil(err)
+	assert.Len(warnings, 1)
+	if !assert.Len(machines, 4) {
+		return
+	}
+	for i, machine := range machines {
+		assert.Equal(i+1, machine.Num)
+	}
+	assert.Equal([]string{a, a, b, b}, []string{machines[0].Source, machines[1].Source, machines[2].Source, machines[3].Source})
+	// 跳板机序号在各自的文件内解析
+	assert.Equal([]*Machine{machines[0]}, machines[1].JumpChain)
+	assert.Equal("10.0.1.1", machines[3].JumpChain[0].IP)
+
+	inv, found, err := OpenInventoryOf(machines[3:])
+	assert.Nil(err)
+	assert.Equal(b, inv.Path)
+	assert.Equal("10.0.1.2", found[0].IP)
+	assert.Equal(inv.Machines[2], found[0])
+
+	_, _, err = OpenInventoryOf(machines[1:3])
+	assert.NotNil(err)
+}
+
+func TestMergeFilesKeepNum(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.conf"), filepath.Join(dir, "b.conf")
+	assert.Nil(os.WriteFile(a, []byte(`machines = [
+    {num = 10, ip = "10.0.0.1", port = 22, username = "root"},
+    {num = 20, ip = "10.0.0.2", port = 22, username = "root", jump = ["10"]},
+]`), 0o644))
+	assert.Nil(os.WriteFile(b, []byte(`machines = [
+    {num = 20, ip = "10.0.1.1", port = 22, username = "root"},
+    {num = 30, ip = "10.0.1.2", port = 22, username = "root", jump = ["20"]},
+]`), 0o644))
+
+	var warnings []string
+	defer func(warnf func(string, ...interface{})) { Warnf = warnf }(Warnf)
+	Warnf = func(format string, args ...interface{}) { warnings = append(warnings, fmt.Sprintf(format, args...)) }
+
+	machines, err := mergeFiles([]string{a, b})
+	assert.Nil(err)
+	if !assert.Len(machines, 4) {
+		return
+	}
+	assert.Equal([]int{10, 20, 1, 30}, []int{machines[0].Num, machines[1].Num, machines[2].Num, machines[3].Num})
+	assert.Len(warnings, 1)
+
+	// 跳板机按文件中的序号解析
+	assert.Equal([]*Machine{machines[0]}, machines[1].JumpChain)
+	assert.Equal([]*Machine{machines[2]}, machines[3].JumpChain)
+
+	// 按显示的序号查找
+	found, err := machines.Find("30")
+	assert.Nil(err)
+	assert.Equal([]*Machine{machines[3]}, found)
+	found, err = machines.Find("1")
+	assert.Nil(err)
+	assert.Equal([]*Machine{machines[2]}, found)
+}
@@ -0,0 +1,345 @@
+package assets
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Query 查询条件, 语法:
+//
+//	query   = or
+//	or      = and { ("OR" | "||") and }
+//	and     = not { ["AND" | "&&"] not }      相邻的条件默认为 AND
+//	not     = ("NOT" | "!") not | primary      "!" 可以直接写在条件前, 如 !tag:prod
+//	primary = "(" or ")" | term
+//	term    = field ":" value                 字段等于 value(忽略大小写)
+//	        | field "~" value                 字段包含 value(忽略大小写)
+//	        | value                           兼容旧的写法: 序号、IP、CIDR 或 IP 子串
+//
+// field 可以是 ip、nat-ip、port、user、device、remark、tag、group, ip/nat-ip 的 value 可以是 CIDR,
+// value 中包含空格时使用双引号包裹. 例如:
+//
+//	tag:prod device:linux remark~gobgp 10.0.0.0/8
+//	(group:db OR group:cache) AND NOT tag:deprecated
+type Query struct {
+	root queryNode
+}
+
+type queryNode interface {
+	match(row queryRow, machine *Machine) bool
+}
+
+// queryRow machine 的序号(参见 MachineList.numbers)与列表中所有的序号, 用于按序号匹配
+type queryRow struct {
+	no  int
+	nos map[int]bool
+}
+
+// ParseQuery 解析查询条件
+func ParseQuery(query string) (*Query, error) {
+	tokens, err := tokenizeQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty query")
+	}
+
+	p := &queryParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in query", p.tokens[p.pos].text)
+	}
+	return &Query{root: root}, nil
+}
+
+// Filter 返回 machines 中满足条件的 machine, 保持原有顺序
+func (q *Query) Filter(machines MachineList) MachineList {
+	nos := machines.numbers()
+	exists := make(map[int]bool, len(nos))
+	for _, no := range nos {
+		exists[no] = true
+	}
+
+	matched := make(MachineList, 0, 4)
+	for i, machine := range machines {
+		if q.root.match(queryRow{no: nos[i], nos: exists}, machine) {
+			matched = append(matched, machine)
+		}
+	}
+	return matched
+}
+
+type queryToken struct {
+	text   string
+	quoted bool
+}
+
+func tokenizeQuery(query string) ([]queryToken, error) {
+	var (
+		tokens []queryToken
+		runes  = []rune(query)
+	)
+	for i := 0; i < len(runes); {
+		switch c := runes[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, queryToken{text: string(c)})
+			i++
+		default:
+			var (
+				word   strings.Builder
+				quoted bool
+			)
+			for i < len(runes) && runes[i] != ' ' && runes[i] != '\t' && runes[i] != '(' && runes[i] != ')' {
+				if runes[i] != '"' {
+					word.WriteRune(runes[i])
+					i++
+					continue
+				}
+				end := i + 1
+				for end < len(runes) && runes[end] != '"' {
+					end++
+				}
+				if end == len(runes) {
+					return nil, fmt.Errorf("unclosed '\"' in query")
+				}
+				word.WriteString(string(runes[i+1 : end]))
+				quoted = true
+				i = end + 1
+			}
+			tokens = append(tokens, queryToken{text: word.String(), quoted: quoted})
+		}
+	}
+	return tokens, nil
+}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+// keyword 判断当前 token 是否是未加引号的关键字
+func (p *queryParser) keyword(words ...string) bool {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].quoted {
+		return false
+	}
+	for _, word := range words {
+		if strings.EqualFold(p.tokens[p.pos].text, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR", "||") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.pos < len(p.tokens) && !p.keyword("OR", "||", ")") {
+		if p.keyword("AND", "&&") {
+			p.pos++
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseNot() (queryNode, error) {
+	if p.keyword("NOT", "!") {
+		p.pos++
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node}, nil
+	}
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of query")
+	}
+	// 兼容 !tag:prod 的写法
+	if token := p.tokens[p.pos]; !token.quoted && len(token.text) > 1 && token.text[0] == '!' {
+		p.tokens[p.pos].text = token.text[1:]
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (queryNode, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of query")
+	}
+	if p.keyword("(") {
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword(")") {
+			return nil, fmt.Errorf("missing ')' in query")
+		}
+		p.pos++
+		return node, nil
+	}
+	if p.keyword(")", "AND", "&&", "OR", "||") {
+		return nil, fmt.Errorf("unexpected %q in query", p.tokens[p.pos].text)
+	}
+
+	token := p.tokens[p.pos]
+	p.pos++
+	return parseTerm(token)
+}
+
+func parseTerm(token queryToken) (queryNode, error) {
+	text := token.text
+	if i := strings.IndexAny(text, ":~"); i > 0 {
+		field := strings.ToLower(text[:i])
+		if _, ok := queryFields[field]; ok {
+			value := text[i+1:]
+			if value == "" {
+				return nil, fmt.Errorf("empty value for field %q in query", field)
+			}
+			node := &fieldNode{field: field, value: strings.ToLower(value), contains: text[i] == '~'}
+			if (field == "ip" || field == "nat-ip") && !node.contains {
+				if _, ipnet, err := net.ParseCIDR(value); err == nil {
+					node.ipnet = ipnet
+				}
+			}
+			return node, nil
+		}
+		// 兼容 IPv6 地址中的 ':'
+		if net.ParseIP(text) == nil {
+			if _, _, err := net.ParseCIDR(text); err != nil {
+				return nil, fmt.Errorf("unknown field %q in query", text[:i])
+			}
+		}
+	}
+	return newBareNode(text), nil
+}
+
+// queryFields 可查询的字段, 返回 machine 中对应的值
+var queryFields = map[string]func(machine *Machine) []string{
+	"ip":     func(m *Machine) []string { return []string{m.IP} },
+	"nat-ip": func(m *Machine) []string { return []string{m.NatIP} },
+	"port":   func(m *Machine) []string { return []string{strconv.Itoa(m.Port)} },
+	"user":   func(m *Machine) []string { return []string{m.Username} },
+	"device": func(m *Machine) []string { return []string{m.Device} },
+	"remark": func(m *Machine) []string { return []string{m.Remark} },
+	"tag":    func(m *Machine) []string { return m.Tags },
+	"group":  func(m *Machine) []string { return []string{m.Group} },
+}
+
+type fieldNode struct {
+	field    string
+	value    string
+	contains bool
+	ipnet    *net.IPNet
+}
+
+func (n *fieldNode) match(_ queryRow, machine *Machine) bool {
+	for _, v := range queryFields[n.field](machine) {
+		switch {
+		case n.ipnet != nil:
+			if ip := net.ParseIP(v); ip != nil && n.ipnet.Contains(ip) {
+				return true
+			}
+		case n.contains:
+			if strings.Contains(strings.ToLower(v), n.value) {
+				return true
+			}
+		default:
+			if strings.EqualFold(v, n.value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bareNode 不带字段的条件, 与旧版 Find 一致: 序号(不存在时按 IP 子串)、IP 精确匹配或 IP 子串, 另外支持 CIDR
+type bareNode struct {
+	no    int
+	ip    net.IP
+	ipnet *net.IPNet
+	text  string
+}
+
+func newBareNode(text string) *bareNode {
+	node := &bareNode{text: text}
+	if no, err := strconv.Atoi(text); err == nil && no > 0 {
+		node.no = no
+	}
+	if ip := net.ParseIP(text); ip != nil {
+		node.ip = ip
+	} else if _, ipnet, err := net.ParseCIDR(text); err == nil {
+		node.ipnet = ipnet
+	}
+	return node
+}
+
+func (n *bareNode) match(row queryRow, machine *Machine) bool {
+	switch {
+	case n.no != 0 && row.nos[n.no]:
+		return row.no == n.no
+	case n.ip != nil:
+		return machine.IP == n.ip.String() || machine.NatIP == n.ip.String()
+	case n.ipnet != nil:
+		for _, v := range []string{machine.IP, machine.NatIP} {
+			if ip := net.ParseIP(v); ip != nil && n.ipnet.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	default:
+		return strings.Contains(machine.IP, n.text) || strings.Contains(machine.NatIP, n.text)
+	}
+}
+
+type andNode [2]queryNode
+
+func (n andNode) match(row queryRow, machine *Machine) bool {
+	return n[0].match(row, machine) && n[1].match(row, machine)
+}
+
+type orNode [2]queryNode
+
+func (n orNode) match(row queryRow, machine *Machine) bool {
+	return n[0].match(row, machine) || n[1].match(row, machine)
+}
+
+type notNode [1]queryNode
+
+func (n notNode) match(row queryRow, machine *Machine) bool {
+	return !n[0].match(row, machine)
+}
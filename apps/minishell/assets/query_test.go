@@ -0,0 +1,58 @@
+package assets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFind(t *testing.T) {
+	assert := assert.New(t)
+
+	machines := MachineList{
+		{IP: "10.0.0.1", NatIP: "192.168.1.1", Port: 22, Device: "Linux", Remark: "gobgp route", Group: "net", Tags: []string{"prod", "bgp"}},
+		{IP: "10.0.0.2", NatIP: NotExist, Port: 22, Device: "linux", Remark: "mysql master", Group: "db", Tags: []string{"prod"}},
+		{IP: "172.16.0.3", NatIP: NotExist, Port: 2222, Device: "h3c", Remark: "core switch", Group: "net", Tags: []string{"test"}},
+	}
+
+	for _, c := range []struct {
+		query string
+		want  []int
+	}{
+		{"1", []int{0}},
+		{"2", []int{1}},
+		{"10.0.0.2", []int{1}},
+		{"192.168.1.1", []int{0}},
+		{"10.0", []int{0, 1}},
+		{"172", []int{2}},
+		{"10.0.0.0/8", []int{0, 1}},
+		{"tag:prod", []int{0, 1}},
+		{"TAG:PROD device:linux remark~gobgp 10.0.0.0/8", []int{0}},
+		{"group:net OR tag:prod", []int{0, 1, 2}},
+		{"group:net AND NOT tag:prod", []int{2}},
+		{"group:net !tag:prod", []int{2}},
+		{"(group:db OR group:net) && port:2222", []int{2}},
+		{"ip:172.16.0.0/12", []int{2}},
+		{`remark~"core sw"`, []int{2}},
+		{"tag:none", nil},
+	} {
+		found, err := machines.Find(c.query)
+		if len(c.want) == 0 {
+			assert.Equal(ErrNotFound, err, c.query)
+			continue
+		}
+		assert.Nil(err, c.query)
+
+		want := make([]*Machine, 0, len(c.want))
+		for _, i := range c.want {
+			want = append(want, machines[i])
+		}
+		assert.Equal(want, found, c.query)
+	}
+
+	for _, query := range []string{"", "(tag:prod", "tag:prod)", "OR tag:prod", "color:red", "tag:", `remark~"gobgp`, "NOT", "!", "tag:prod AND", "tag:prod OR", "("} {
+		_, err := machines.Find(query)
+		assert.NotNil(err, query)
+		assert.NotEqual(ErrNotFound, err, query)
+	}
+}
@@ -0,0 +1,106 @@
+package assets
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/eviltomorrow/toolbox/lib/system"
+)
+
+const (
+	// DefaultPrompt 未配置 expect 时等待的提示符: 以 $、#、>、% 结尾
+	DefaultPrompt = `[$#>%]\s*$`
+	// DefaultStepTimeout 未配置 timeout 时等待提示符的时长
+	DefaultStepTimeout = 10 * time.Second
+	// implicitStepTimeout linux 默认步骤等待提示符的时长, 此时尚未转发键盘输入, 提示符无法识别时不能长时间等待
+	implicitStepTimeout = time.Second
+)
+
+// Step 登录后执行的步骤: 等待输出匹配 Expect 后发送 Send 并回车.
+// Send 中的 {{host}}、{{ip}}、{{user}}、{{remark}} 替换为 machine 对应的值
+type Step struct {
+	Expect  string        `toml:"expect" json:"expect,omitempty" yaml:"expect,omitempty"`
+	Send    string        `toml:"send" json:"send" yaml:"send"`
+	Timeout time.Duration `toml:"timeout" json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+// Prompt 编译 Expect, 为空时使用 DefaultPrompt
+func (s *Step) Prompt() (*regexp.Regexp, error) {
+	expect := s.Expect
+	if expect == "" {
+		expect = DefaultPrompt
+	}
+	re, err := regexp.Compile(expect)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expect %q, nest error: %v", s.Expect, err)
+	}
+	return re, nil
+}
+
+// Wait 等待提示符的时长
+func (s *Step) Wait() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return DefaultStepTimeout
+}
+
+// Line 返回发送给 machine 的内容
+func (s *Step) Line(m *Machine) string {
+	return strings.NewReplacer(
+		"{{host}}", m.Host(),
+		"{{ip}}", m.IP,
+		"{{user}}", m.Username,
+		"{{remark}}", m.Remark,
+	).Replace(s.Send)
+}
+
+// linuxSteps 未配置登录步骤的 linux 设备默认在提示符中显示 host
+var linuxSteps = []*Step{{Send: `export PS1="[{{host}}] $PS1"`, Timeout: implicitStepTimeout}}
+
+// LoginSteps 返回 machine 登录后执行的步骤, 优先级: machine 的 steps > etc/devices/<device>.toml 的 steps > linux 默认步骤
+func LoginSteps(m *Machine) ([]*Step, error) {
+	if len(m.Steps) != 0 {
+		return m.Steps, nil
+	}
+	if m.Device == "" || m.Device == NotExist {
+		return nil, nil
+	}
+
+	steps, err := loadDeviceSteps(filepath.Join(system.Directory.RootDir, "etc", "devices", strings.ToLower(m.Device)+".toml"))
+	if err == nil {
+		return steps, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("load device profile %v failure, nest error: %v", m.Device, err)
+	}
+	if strings.EqualFold(m.Device, "linux") {
+		return linuxSteps, nil
+	}
+	return nil, nil
+}
+
+// loadDeviceSteps 加载设备配置文件, 如:
+//
+//	[[steps]]
+//	expect = 'login:\s*$'
+//	send = "admin"
+func loadDeviceSteps(path string) ([]*Step, error) {
+	var f struct {
+		Steps []*Step `toml:"steps"`
+	}
+	if _, err := toml.DecodeFile(path, &f); err != nil {
+		return nil, err
+	}
+	for _, step := range f.Steps {
+		if _, err := step.Prompt(); err != nil {
+			return nil, err
+		}
+	}
+	return f.Steps, nil
+}
@@ -0,0 +1,78 @@
+package assets
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eviltomorrow/toolbox/lib/system"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginSteps(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	defer func(root string) { system.Directory.RootDir = root }(system.Directory.RootDir)
+	system.Directory.RootDir = dir
+	assert.Nil(os.MkdirAll(filepath.Join(dir, "etc", "devices"), 0o755))
+	assert.Nil(os.WriteFile(filepath.Join(dir, "etc", "devices", "h3c.toml"), []byte(`
+[[steps]]
+expect = '>\s*$'
+send = "screen-length disable"
+timeout = "3s"
+`), 0o644))
+
+	path := filepath.Join(dir, "machines.conf")
+	assert.Nil(os.WriteFile(path, []byte(`machines = [
+    {ip = "10.0.0.1", port = 22, username = "root", device = "linux", remark = "web", steps = [{expect = 'password:\s*$', send = "{{user}}@{{remark}}"}, {send = "tmux new -A -s main"}]},
+    {ip = "10.0.0.2", port = 22, username = "admin", device = "H3C"},
+    {ip = "10.0.0.3", port = 22, username = "root", device = "linux"},
+    {ip = "10.0.0.4", port = 22, username = "root", device = "cisco"},
+]`), 0o644))
+	machines, err := LoadFile(path)
+	assert.Nil(err)
+	if !assert.Len(machines, 4) {
+		return
+	}
+
+	steps, err := LoginSteps(machines[0])
+	assert.Nil(err)
+	if assert.Len(steps, 2) {
+		assert.Equal("root@web", steps[0].Line(machines[0]))
+		assert.Equal(DefaultStepTimeout, steps[0].Wait())
+		re, err := steps[1].Prompt()
+		assert.Nil(err)
+		assert.True(re.MatchString("[root@web ~]# "))
+	}
+
+	steps, err = LoginSteps(machines[1])
+	assert.Nil(err)
+	assert.Equal([]*Step{{Expect: `>\s*$`, Send: "screen-length disable", Timeout: 3 * time.Second}}, steps)
+
+	steps, err = LoginSteps(machines[2])
+	assert.Nil(err)
+	if assert.Len(steps, 1) {
+		assert.Equal(`export PS1="[10.0.0.3] $PS1"`, steps[0].Line(machines[2]))
+		assert.Equal(time.Second, steps[0].Wait())
+	}
+
+	steps, err = LoginSteps(machines[3])
+	assert.Nil(err)
+	assert.Empty(steps)
+
+	// 修改其它字段时保留 steps
+	inv, err := OpenInventory(path)
+	assert.Nil(err)
+	inv.Machines[0].Remark = "web-01"
+	inv.Machines[0].Steps[1].Timeout = time.Second
+	_, err = inv.Save()
+	assert.Nil(err)
+	machines, err = LoadFile(path)
+	assert.Nil(err)
+	assert.Equal([]*Step{{Expect: `password:\s*$`, Send: "{{user}}@{{remark}}"}, {Send: "tmux new -A -s main", Timeout: time.Second}}, machines[0].Steps)
+
+	machines[3].Steps = []*Step{{Expect: "(", Send: "x"}}
+	assert.NotNil(machines[3].Validate())
+}
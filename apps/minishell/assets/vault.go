@@ -0,0 +1,348 @@
+package assets
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// vault 文件格式:
+//
+//	MINISHELL-VAULT;1;SCRYPT-AES256-GCM
+//	base64(salt || nonce || ciphertext), 每行 76 个字符
+//
+// 明文为与 .conf 相同的 TOML 内容.
+const (
+	vaultHeader  = "MINISHELL-VAULT;1;SCRYPT-AES256-GCM"
+	vaultSaltLen = 16
+
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+var ErrVaultPassphrase = errors.New("vault passphrase is incorrect or file is damaged")
+
+// VaultPassphrase 获取 vault 主密码, 优先读取环境变量 MINISHELL_VAULT_PASSPHRASE, 否则在终端提示输入
+var VaultPassphrase = func() ([]byte, error) {
+	if passphrase := os.Getenv("MINISHELL_VAULT_PASSPHRASE"); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+	return ReadPassphrase("Vault passphrase: ")
+}
+
+// ReadPassphrase 在终端提示输入, 不回显
+func ReadPassphrase(prompt string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("stdin is not a terminal, cannot read passphrase")
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("empty passphrase")
+	}
+	return passphrase, nil
+}
+
+func EncryptVault(machines MachineList, passphrase []byte) ([]byte, error) {
+	machines, err := machines.reveal()
+	if err != nil {
+		return nil, err
+	}
+
+	var plain bytes.Buffer
+	if err := encodeToml(&plain, machines); err != nil {
+		return nil, err
+	}
+	defer wipe(plain.Bytes())
+
+	salt := make([]byte, vaultSaltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	aead, err := vaultAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	payload := append(append(salt, nonce...), aead.Seal(nil, nonce, plain.Bytes(), []byte(vaultHeader))...)
+	encoded := base64.StdEncoding.EncodeToString(payload)
+
+	var buf bytes.Buffer
+	buf.WriteString(vaultHeader)
+	buf.WriteByte('\n')
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76])
+		buf.WriteByte('\n')
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded)
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// DecryptVault 解密 vault 内容, 返回的 machines 中密码为明文
+func DecryptVault(data, passphrase []byte) (MachineList, error) {
+	header, body, _ := strings.Cut(string(data), "\n")
+	if strings.TrimSpace(header) != vaultHeader {
+		return nil, fmt.Errorf("not a vault file, header: %q", header)
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(body), ""))
+	if err != nil {
+		return nil, fmt.Errorf("decode vault failure, nest error: %v", err)
+	}
+	if len(payload) < vaultSaltLen {
+		return nil, ErrVaultPassphrase
+	}
+
+	salt := payload[:vaultSaltLen]
+	aead, err := vaultAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	payload = payload[vaultSaltLen:]
+	if len(payload) < aead.NonceSize() {
+		return nil, ErrVaultPassphrase
+	}
+
+	plain, err := aead.Open(nil, payload[:aead.NonceSize()], payload[aead.NonceSize():], []byte(vaultHeader))
+	if err != nil {
+		return nil, ErrVaultPassphrase
+	}
+	defer wipe(plain)
+
+	return decodeToml(plain)
+}
+
+// RekeyVault 使用新的主密码重新加密 vault 内容
+func RekeyVault(data, oldPassphrase, newPassphrase []byte) ([]byte, error) {
+	machines, err := DecryptVault(data, oldPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	return EncryptVault(machines, newPassphrase)
+}
+
+func WriteVaultFile(path string, machines MachineList, passphrase []byte) error {
+	data, err := EncryptVault(machines, passphrase)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, data, 0o600)
+}
+
+func RekeyVaultFile(path string, oldPassphrase, newPassphrase []byte) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	data, err = RekeyVault(data, oldPassphrase, newPassphrase)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, data, 0o600)
+}
+
+// DecryptVaultFile 读取并解密 vault 文件, 返回的 machines 中密码为明文
+func DecryptVaultFile(path string, passphrase []byte) (MachineList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return DecryptVault(data, passphrase)
+}
+
+// vaultPassphrases 本次运行中已解密的 vault 文件(绝对路径)及其主密码, 主密码以进程内密钥加密保存
+var vaultPassphrases sync.Map
+
+// loadVaultFile 解密 vault 文件, 返回 machines 与加密保存的主密码; 同一文件在一次运行中只需输入一次主密码
+func loadVaultFile(path string) (MachineList, *Sealed, error) {
+	key := path
+	if abs, err := filepath.Abs(path); err == nil {
+		key = abs
+	}
+
+	var passphrase []byte
+	if v, ok := vaultPassphrases.Load(key); ok {
+		p, err := v.(*Sealed).Open()
+		if err != nil {
+			return nil, nil, err
+		}
+		passphrase = []byte(p)
+	} else {
+		p, err := VaultPassphrase()
+		if err != nil {
+			return nil, nil, err
+		}
+		passphrase = p
+	}
+	defer wipe(passphrase)
+
+	machines, err := openVaultFile(path, passphrase)
+	if err != nil {
+		vaultPassphrases.Delete(key)
+		return nil, nil, err
+	}
+	sealed, err := Seal(string(passphrase))
+	if err != nil {
+		return nil, nil, err
+	}
+	vaultPassphrases.Store(key, sealed)
+	return machines, sealed, nil
+}
+
+// openVaultFile 解密 vault 文件, machines 中的敏感信息以进程内密钥加密保存
+func openVaultFile(path string, passphrase []byte) (MachineList, error) {
+	machines, err := DecryptVaultFile(path, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	for _, machine := range machines {
+		if err := machine.seal(); err != nil {
+			return nil, err
+		}
+	}
+	return machines, nil
+}
+
+// loadVaultInventory 加载 vault 文件, 同时返回加密保存的主密码, 写回时无需再次输入
+func loadVaultInventory(path string) (MachineList, *Sealed, error) {
+	machines, passphrase, err := loadVaultFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if machines, err = attachSource(machines, path); err != nil {
+		return nil, nil, err
+	}
+	return machines, passphrase, nil
+}
+
+func vaultAEAD(passphrase, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(key)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encodeToml(w io.Writer, machines MachineList) error {
+	type F struct {
+		Machines []*Machine `toml:"machines"`
+	}
+	return toml.NewEncoder(w).Encode(&F{Machines: machines})
+}
+
+func decodeToml(data []byte) (MachineList, error) {
+	type F struct {
+		Machines []*Machine `toml:"machines"`
+	}
+
+	f := new(F)
+	if _, err := toml.Decode(string(data), f); err != nil {
+		return nil, err
+	}
+	return f.Machines, nil
+}
+
+// Sealed 使用进程内随机密钥加密的敏感信息, 仅在需要时解密
+type Sealed struct {
+	nonce []byte
+	data  []byte
+}
+
+var (
+	sessionOnce sync.Once
+	sessionAEAD cipher.AEAD
+	sessionErr  error
+)
+
+func sessionCipher() (cipher.AEAD, error) {
+	sessionOnce.Do(func() {
+		key := make([]byte, 32)
+		if _, sessionErr = io.ReadFull(rand.Reader, key); sessionErr != nil {
+			return
+		}
+		defer wipe(key)
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			sessionErr = err
+			return
+		}
+		sessionAEAD, sessionErr = cipher.NewGCM(block)
+	})
+	return sessionAEAD, sessionErr
+}
+
+func Seal(plain string) (*Sealed, error) {
+	aead, err := sessionCipher()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return &Sealed{nonce: nonce, data: aead.Seal(nil, nonce, []byte(plain), nil)}, nil
+}
+
+func (s *Sealed) Open() (string, error) {
+	aead, err := sessionCipher()
+	if err != nil {
+		return "", err
+	}
+	plain, err := aead.Open(nil, s.nonce, s.data, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// writeFile 先写临时文件再重命名, 避免写入中断时损坏原文件
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,98 @@
+package assets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVault(t *testing.T) {
+	assert := assert.New(t)
+
+	machines, err := LoadTomlFile("../conf/etc/machines.conf")
+	assert.Nil(err)
+
+	path := filepath.Join(t.TempDir(), "machines.vault")
+	assert.Nil(WriteVaultFile(path, machines, []byte("old-passphrase")))
+
+	data, err := os.ReadFile(path)
+	assert.Nil(err)
+	assert.True(strings.HasPrefix(string(data), vaultHeader))
+	assert.False(strings.Contains(string(data), machines[0].Password))
+
+	_, err = DecryptVaultFile(path, []byte("wrong-passphrase"))
+	assert.Equal(ErrVaultPassphrase, err)
+
+	assert.Nil(RekeyVaultFile(path, []byte("old-passphrase"), []byte("new-passphrase")))
+	_, err = DecryptVaultFile(path, []byte("old-passphrase"))
+	assert.Equal(ErrVaultPassphrase, err)
+
+	vaultPassphrase := VaultPassphrase
+	t.Cleanup(func() { VaultPassphrase = vaultPassphrase })
+	VaultPassphrase = func() ([]byte, error) { return []byte("new-passphrase"), nil }
+	loaded, err := LoadFile(path)
+	assert.Nil(err)
+	assert.Len(loaded, len(machines))
+	assert.Equal("", loaded[0].Password)
+	assert.True(loaded[0].HasPassword())
+
+	password, err := loaded[0].RevealPassword()
+	assert.Nil(err)
+	assert.Equal(machines[0].Password, password)
+	assert.Equal(machines[0].IP, loaded[0].IP)
+}
+
+func TestVaultSealsSecrets(t *testing.T) {
+	assert := assert.New(t)
+
+	machines := MachineList{
+		{IP: "10.0.0.1", Port: 22, Username: "root", Password: "login-secret", Passphrase: "key-secret", Become: &Become{Method: BecomeSudo, Password: "become-secret"}},
+		{IP: "10.0.0.2", Port: 22, Username: "root", Password: NotExist},
+	}
+	path := filepath.Join(t.TempDir(), "machines.vault")
+	assert.Nil(WriteVaultFile(path, machines, []byte("passphrase")))
+
+	vaultPassphrase := VaultPassphrase
+	t.Cleanup(func() { VaultPassphrase = vaultPassphrase })
+	VaultPassphrase = func() ([]byte, error) { return []byte("passphrase"), nil }
+	loaded, err := LoadFile(path)
+	assert.Nil(err)
+	assert.Len(loaded, 2)
+
+	m := loaded[0]
+	for _, s := range []string{m.Password, m.Passphrase, m.Become.Password, m.String()} {
+		for _, secret := range []string{"login-secret", "key-secret", "become-secret"} {
+			assert.NotContains(s, secret)
+		}
+	}
+	assert.True(m.HasPassword())
+	assert.True(m.HasPassphrase())
+	assert.True(m.Become.HasPassword())
+
+	password, err := m.RevealPassword()
+	assert.Nil(err)
+	assert.Equal("login-secret", password)
+	passphrase, err := m.RevealPassphrase()
+	assert.Nil(err)
+	assert.Equal("key-secret", passphrase)
+	password, err = m.BecomePassword()
+	assert.Nil(err)
+	assert.Equal("become-secret", password)
+
+	assert.False(loaded[1].HasPassword())
+	assert.False(loaded[1].HasPassphrase())
+
+	// 写出时恢复为明文, 内存中的 machine 保持加密
+	out := filepath.Join(t.TempDir(), "machines.conf")
+	assert.Nil(WriteFile(out, loaded))
+	converted, err := LoadFile(out)
+	assert.Nil(err)
+	assert.Equal("login-secret", converted[0].Password)
+	assert.Equal("key-secret", converted[0].Passphrase)
+	assert.Equal("become-secret", converted[0].Become.Password)
+	assert.Equal("", m.Passphrase)
+	assert.Equal("", m.Become.Password)
+}
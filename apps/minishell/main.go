@@ -1,19 +1,29 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/eviltomorrow/toolbox/apps/minishell/adapter"
 	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
 	"github.com/eviltomorrow/toolbox/apps/minishell/terminal"
 	"github.com/eviltomorrow/toolbox/lib/buildinfo"
+	"github.com/eviltomorrow/toolbox/lib/fs"
 	"github.com/eviltomorrow/toolbox/lib/system"
 	"github.com/fatih/color"
 	"github.com/urfave/cli/v2"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
 )
 
 var (
@@ -52,20 +62,751 @@ func main() {
 		},
 		Copyright: "(c) 2023~2023 By Liarsa, All rights reserved.",
 		Usage:     "快速登录 ssh server 工具",
+		UsageText: "./minishell [options] <cond>\n\n" +
+			"cond 为查询条件, 如: 3, 10.0.0.1, 10.0.0.0/8, tag:prod device:linux remark~gobgp,\n" +
+			"(group:db OR group:cache) AND NOT tag:deprecated; 可查询 ip、nat-ip、port、user、device、remark、tag、group",
 
 		Commands: []*cli.Command{
 			{
 				Name:      "show",
 				Usage:     "显示所有机器列表",
-				UsageText: "./minishell show",
+				UsageText: "./minishell show [--output table|wide|json|csv|yaml] [--sort group,-port] [--columns ip,port,remark] [--filter <cond>]",
 				Flags: []cli.Flag{
 					&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "the machines file path"},
 					&cli.BoolFlag{Name: "print", Aliases: []string{"p"}, Usage: "show password"},
+					&cli.StringFlag{Name: "output", Aliases: []string{"o"}, Value: terminal.OutputTable, Usage: "output format: " + strings.Join(terminal.Outputs, "|")},
+					&cli.StringFlag{Name: "sort", Usage: "sort by comma separated columns, prefix \"-\" for descending, e.g. group,-port"},
+					&cli.StringFlag{Name: "columns", Aliases: []string{"c"}, Usage: "comma separated columns to show, e.g. ip,port,remark,attr:owner"},
+					&cli.StringFlag{Name: "filter", Usage: "only show machines matching the cond, same syntax as login"},
 				},
 				Action: func(cCtx *cli.Context) error {
-					path := cCtx.String("file")
-					print := cCtx.Bool("print")
-					return terminal.RenderTableFromFile(path, print)
+					machines, err := assets.LoadFile(cCtx.String("file"))
+					if err != nil {
+						return err
+					}
+					if cond := cCtx.String("filter"); cond != "" {
+						query, err := assets.ParseQuery(cond)
+						if err != nil {
+							return err
+						}
+						machines = query.Filter(machines)
+					}
+					if spec := cCtx.String("sort"); spec != "" {
+						if err := terminal.SortMachines(machines, spec); err != nil {
+							return err
+						}
+					}
+
+					option := terminal.Option{ShowFooter: true, ShowPassword: cCtx.Bool("print")}
+					if spec := cCtx.String("columns"); spec != "" {
+						if option.Columns, err = terminal.ParseColumns(spec); err != nil {
+							return err
+						}
+					}
+					return terminal.RenderMachines(os.Stdout, machines, cCtx.String("output"), option)
+				},
+			},
+
+			{
+				Name:      "exec",
+				Usage:     "在匹配的多台 machine 上并发执行命令",
+				UsageText: "./minishell exec [options] <cond> -- <cmd>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "the machines file path"},
+					&cli.IntFlag{Name: "concurrency", Aliases: []string{"c"}, Value: 10, Usage: "maximum number of machines executing at the same time"},
+					&cli.DurationFlag{Name: "timeout", Aliases: []string{"t"}, Value: 60 * time.Second, Usage: "timeout for each machine"},
+					&cli.BoolFlag{Name: "collect", Usage: "collect output per machine instead of streaming with prefix"},
+				},
+				Action: func(cCtx *cli.Context) error {
+					// "--" 之前的参数都属于查询条件, 如 exec tag:prod device:linux -- uptime
+					cond, args := cCtx.Args().First(), cCtx.Args().Tail()
+					for i, arg := range cCtx.Args().Slice() {
+						if arg == "--" {
+							cond, args = strings.Join(cCtx.Args().Slice()[:i], " "), cCtx.Args().Slice()[i+1:]
+							break
+						}
+					}
+					if cond == "" || len(args) == 0 {
+						return fmt.Errorf("usage: %s", cCtx.Command.UsageText)
+					}
+					command := strings.Join(args, " ")
+
+					machines, err := assets.LoadFile(cCtx.String("file"))
+					if err != nil {
+						return err
+					}
+					machines, err = machines.Find(cond)
+					if err != nil {
+						return err
+					}
+					dialer, err := newDialer(cCtx)
+					if err != nil {
+						return err
+					}
+
+					var (
+						mu      sync.Mutex
+						flushes = make([]func() error, 0, len(machines))
+						outputs = make(map[*assets.Machine]*bytes.Buffer, len(machines))
+					)
+					option := adapter.ExecOption{
+						Concurrency: cCtx.Int("concurrency"),
+						Timeout:     cCtx.Duration("timeout"),
+						Output: func(machine *assets.Machine) (io.Writer, io.Writer) {
+							mu.Lock()
+							defer mu.Unlock()
+
+							var stdout, stderr *terminal.PrefixWriter
+							if cCtx.Bool("collect") {
+								buf := &bytes.Buffer{}
+								outputs[machine] = buf
+								bufMu := &sync.Mutex{}
+								stdout = terminal.NewPrefixWriter(buf, bufMu, "")
+								stderr = terminal.NewPrefixWriter(buf, bufMu, "")
+							} else {
+								prefix := greenbold.Sprintf("[%s]", machine.IP) + " "
+								stdout = terminal.NewPrefixWriter(os.Stdout, &mu, prefix)
+								stderr = terminal.NewPrefixWriter(os.Stderr, &mu, prefix)
+							}
+							flushes = append(flushes, stdout.Flush, stderr.Flush)
+							return stdout, stderr
+						},
+					}
+
+					results := adapter.ExecOnMachines(dialer, machines, command, option)
+					for _, flush := range flushes {
+						flush()
+					}
+					for _, machine := range machines {
+						if buf, ok := outputs[machine]; ok {
+							greenbold.Printf("==> [%s]\r\n", machine.IP)
+							os.Stdout.Write(buf.Bytes())
+							fmt.Println()
+						}
+					}
+					terminal.RenderExecResults(results)
+					return nil
+				},
+			},
+
+			{
+				Name:      "ping",
+				Usage:     "检查机器的连通性: tcp 连接、ssh banner、host key, 可选检查认证",
+				UsageText: "./minishell ping [options] [cond]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "the machines file path"},
+					&cli.IntFlag{Name: "concurrency", Aliases: []string{"c"}, Value: 20, Usage: "maximum number of machines checking at the same time"},
+					&cli.DurationFlag{Name: "timeout", Aliases: []string{"t"}, Value: 5 * time.Second, Usage: "timeout for each machine"},
+					&cli.BoolFlag{Name: "auth", Aliases: []string{"a"}, Usage: "also check authentication"},
+					&cli.StringFlag{Name: "output", Aliases: []string{"o"}, Value: "table", Usage: "output format: table or json"},
+				},
+				Action: func(cCtx *cli.Context) error {
+					output := cCtx.String("output")
+					if output != "table" && output != "json" {
+						return fmt.Errorf("invalid output: %v", output)
+					}
+
+					machines, err := assets.LoadFile(cCtx.String("file"))
+					if err != nil {
+						return err
+					}
+					if cond := strings.Join(cCtx.Args().Slice(), " "); cond != "" {
+						if machines, err = machines.Find(cond); err != nil {
+							return err
+						}
+					}
+					dialer, err := newDialer(cCtx)
+					if err != nil {
+						return err
+					}
+					// 不重试, 避免不可达的机器拖慢检查、影响耗时; 不询问 host key, 未知主机按 strict 处理
+					dialer.Timeout, dialer.Retries = cCtx.Duration("timeout"), 0
+					if dialer.KnownHosts.Policy == adapter.HostKeyAsk {
+						dialer.KnownHosts.Policy = adapter.HostKeyStrict
+					}
+
+					results := adapter.PingMachines(dialer, machines, adapter.PingOption{
+						Concurrency: cCtx.Int("concurrency"),
+						Timeout:     cCtx.Duration("timeout"),
+						Auth:        cCtx.Bool("auth"),
+					})
+					if output == "json" {
+						return terminal.WritePingResultsJSON(os.Stdout, results)
+					}
+					terminal.RenderPingResults(results)
+					return nil
+				},
+			},
+
+			{
+				Name:      "push",
+				Usage:     "使用 SCP 上传文件到匹配的 machine",
+				UsageText: "./minishell push [options] <cond> <local> <remote>",
+				Flags:     transferFlags,
+				Action: func(cCtx *cli.Context) error {
+					return transfer(cCtx, true)
+				},
+			},
+
+			{
+				Name:      "pull",
+				Usage:     "使用 SCP 从匹配的 machine 下载文件, 多台 machine 时保存在 <local>/<user>@<ip>-<port> 下",
+				UsageText: "./minishell pull [options] <cond> <remote> <local>",
+				Flags:     transferFlags,
+				Action: func(cCtx *cli.Context) error {
+					return transfer(cCtx, false)
+				},
+			},
+
+			{
+				Name:      "multi",
+				Usage:     "同时登录匹配的多台 machine, 以标签页切换, 可将输入广播到所有 machine",
+				UsageText: "./minishell multi [options] <cond>\n\n" + adapter.MultiHelpText,
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "the machines file path"},
+					&cli.IntFlag{Name: "concurrency", Aliases: []string{"c"}, Value: 10, Usage: "maximum number of machines logging in at the same time"},
+					&cli.StringFlag{Name: "log-dir", Usage: "directory for per-machine output logs, default var/multi/<time>"},
+				},
+				Action: func(cCtx *cli.Context) error {
+					cond := strings.Join(cCtx.Args().Slice(), " ")
+					if cond == "" {
+						return fmt.Errorf("usage: %s", cCtx.Command.UsageText)
+					}
+					machines, err := assets.LoadFile(cCtx.String("file"))
+					if err != nil {
+						return err
+					}
+					machines, err = machines.Find(cond)
+					if err != nil {
+						return err
+					}
+					dialer, err := newDialer(cCtx)
+					if err != nil {
+						return err
+					}
+
+					logDir := cCtx.String("log-dir")
+					if logDir == "" {
+						logDir = filepath.Join(system.Directory.VarDir, "multi", time.Now().Format("20060102-150405"))
+					}
+					greenbold.Printf("==> Logging in to %d machines\r\n", len(machines))
+					results, err := adapter.InteractiveWithTerminalForMulti(dialer, machines, adapter.MultiOption{
+						Concurrency: cCtx.Int("concurrency"),
+						LogDir:      logDir,
+						Steps:       assets.LoginSteps,
+					})
+					fmt.Println()
+					for _, result := range results {
+						recordLogin(result.Machine, result.Start, result.End, result.Err)
+						if result.Err != nil {
+							red.Printf("==> [%s] %v\r\n", result.Machine.IP, result.Err)
+						} else {
+							greenbold.Printf("==> [%s] closed, log: %s\r\n", result.Machine.IP, result.LogPath)
+						}
+					}
+					return err
+				},
+			},
+
+			{
+				Name:      "recent",
+				Usage:     "显示最近登录的机器",
+				UsageText: "./minishell recent [options]",
+				Flags:     historyFlags,
+				Action: func(cCtx *cli.Context) error {
+					return renderHistory(cCtx, false)
+				},
+			},
+
+			{
+				Name:      "top",
+				Usage:     "显示登录次数最多的机器",
+				UsageText: "./minishell top [options]",
+				Flags:     historyFlags,
+				Action: func(cCtx *cli.Context) error {
+					return renderHistory(cCtx, true)
+				},
+			},
+
+			{
+				Name:      "forward",
+				Usage:     "登录匹配的 machine 并转发端口(不打开 shell), 等同于 ssh -N -L/-R",
+				UsageText: "./minishell forward [options] <cond> -L [bind_address:]port:host:hostport -R [bind_address:]port:host:hostport",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "the machines file path"},
+					&cli.StringSliceFlag{Name: "local", Aliases: []string{"L"}, Usage: "forward local [bind_address:]port to host:hostport via the machine"},
+					&cli.StringSliceFlag{Name: "remote", Aliases: []string{"R"}, Usage: "forward remote [bind_address:]port on the machine to local host:hostport"},
+				},
+				Action: func(cCtx *cli.Context) error {
+					cond := cCtx.Args().First()
+					if cond == "" {
+						return fmt.Errorf("usage: %s", cCtx.Command.UsageText)
+					}
+					forwards, err := parseForwards(cCtx)
+					if err != nil {
+						return err
+					}
+					if len(forwards) == 0 {
+						return fmt.Errorf("usage: %s", cCtx.Command.UsageText)
+					}
+
+					machine, err := findOneMachine(cCtx, cond)
+					if err != nil {
+						return err
+					}
+
+					dialer, err := newDialer(cCtx)
+					if err != nil {
+						return err
+					}
+
+					forwarder, err := adapter.StartForward(dialer, machine, forwards, newLogf(machine))
+					if err != nil {
+						return err
+					}
+					greenbold.Printf("==> Forwarding via [%s/%s], press Ctrl+C to stop\r\n", machine.NatIP, machine.IP)
+
+					closeOnSignal(forwarder.Close)
+					if err := forwarder.Wait(); err != nil {
+						return fmt.Errorf("connection closed, nest error: %v", err)
+					}
+					greenbold.Println("==> Stopped")
+					return nil
+				},
+			},
+
+			{
+				Name:      "socks",
+				Usage:     "登录匹配的 machine 并在本地启动 SOCKS5 代理, 等同于 ssh -N -D",
+				UsageText: "./minishell socks [options] <cond>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "the machines file path"},
+					&cli.StringFlag{Name: "listen", Aliases: []string{"l"}, Value: "127.0.0.1:1080", Usage: "the socks5 listen address"},
+					&cli.StringFlag{Name: "username", Aliases: []string{"u"}, Usage: "require socks5 username/password auth with this username"},
+					&cli.StringFlag{Name: "password", EnvVars: []string{"MINISHELL_SOCKS_PASSWORD"}, Usage: "the socks5 auth password"},
+				},
+				Action: func(cCtx *cli.Context) error {
+					if cCtx.Args().Len() != 1 {
+						return fmt.Errorf("usage: %s", cCtx.Command.UsageText)
+					}
+					machine, err := findOneMachine(cCtx, cCtx.Args().First())
+					if err != nil {
+						return err
+					}
+					dialer, err := newDialer(cCtx)
+					if err != nil {
+						return err
+					}
+
+					option := adapter.SocksOption{
+						Username: cCtx.String("username"),
+						Password: cCtx.String("password"),
+						Logf:     newLogf(machine),
+					}
+					socks, err := adapter.StartSocks(dialer, machine, cCtx.String("listen"), option)
+					if err != nil {
+						return err
+					}
+					greenbold.Printf("==> SOCKS5 proxy on %s via [%s/%s], press Ctrl+C to stop\r\n", socks.Addr(), machine.NatIP, machine.IP)
+
+					closeOnSignal(socks.Close)
+					if err := socks.Wait(); err != nil {
+						return fmt.Errorf("connection closed, nest error: %v", err)
+					}
+					greenbold.Println("==> Stopped")
+					return nil
+				},
+			},
+
+			{
+				Name:      "hostkey",
+				Usage:     "管理 known_hosts 中的 host key",
+				UsageText: "./minishell hostkey list|pin|forget|ca",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "list",
+						Usage:     "显示已记录的 host key",
+						UsageText: "./minishell hostkey list",
+						Action: func(cCtx *cli.Context) error {
+							knownHosts, err := openKnownHosts(cCtx)
+							if err != nil {
+								return err
+							}
+							hosts, err := knownHosts.List()
+							if err != nil {
+								return err
+							}
+							terminal.RenderKnownHosts(hosts)
+							return nil
+						},
+					},
+					{
+						Name:      "pin",
+						Usage:     "获取并记录指定 machine 当前的 host key, 替换已有记录",
+						UsageText: "./minishell hostkey pin <cond>",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "the machines file path"},
+							&cli.BoolFlag{Name: "hash", Usage: "hash the host name like HashKnownHosts"},
+						},
+						Action: func(cCtx *cli.Context) error {
+							if cCtx.Args().Len() == 0 {
+								return fmt.Errorf("missing cond")
+							}
+							machines, err := assets.LoadFile(cCtx.String("file"))
+							if err != nil {
+								return err
+							}
+							machines, err = machines.Find(cCtx.Args().First())
+							if err != nil {
+								return err
+							}
+							dialer, err := newDialer(cCtx)
+							if err != nil {
+								return err
+							}
+							for _, machine := range machines {
+								addr := machine.Addr()
+								key, err := dialer.ScanHostKey(machine)
+								if err != nil {
+									redbold.Printf("==> Error: 获取 host key 失败, nest error: %v, resource: %v\r\n", err, addr)
+									continue
+								}
+								if err := dialer.KnownHosts.Pin(addr, key, cCtx.Bool("hash")); err != nil {
+									return err
+								}
+								greenbold.Printf("==> Pinned [%s] %s %s\r\n", addr, key.Type(), ssh.FingerprintSHA256(key))
+							}
+							return nil
+						},
+					},
+					{
+						Name:      "forget",
+						Usage:     "删除指定 machine 或 host[:port] 的 host key",
+						UsageText: "./minishell hostkey forget <cond|host[:port]>",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "the machines file path"},
+						},
+						Action: func(cCtx *cli.Context) error {
+							if cCtx.Args().Len() == 0 {
+								return fmt.Errorf("missing cond")
+							}
+							cond := cCtx.Args().First()
+
+							addrs := []string{cond}
+							if machines, err := assets.LoadFile(cCtx.String("file")); err == nil {
+								if machines, err := machines.Find(cond); err == nil {
+									addrs = addrs[:0]
+									for _, machine := range machines {
+										addrs = append(addrs, machine.Addr())
+									}
+								}
+							}
+
+							knownHosts, err := openKnownHosts(cCtx)
+							if err != nil {
+								return err
+							}
+							for _, addr := range addrs {
+								n, err := knownHosts.Forget(addr)
+								if err != nil {
+									return err
+								}
+								greenbold.Printf("==> Forget [%s], removed %d entries\r\n", addr, n)
+							}
+							return nil
+						},
+					},
+					{
+						Name:  "ca",
+						Usage: "以 @cert-authority 信任 host CA, 匹配的主机使用该 CA 签发的 host 证书时无需确认",
+						UsageText: "./minishell hostkey ca <host-pattern[,host-pattern]> <ca.pub>\n\n" +
+							"host-pattern 与 known_hosts 相同, 如 *.example.com、10.0.0.*; 非 22 端口需写为 [10.0.0.*]:2222",
+						Action: func(cCtx *cli.Context) error {
+							if cCtx.Args().Len() != 2 {
+								return fmt.Errorf("usage: %s", cCtx.Command.UsageText)
+							}
+							buf, err := os.ReadFile(assets.ExpandHome(cCtx.Args().Get(1)))
+							if err != nil {
+								return err
+							}
+							key, _, _, _, err := ssh.ParseAuthorizedKey(buf)
+							if err != nil {
+								return fmt.Errorf("parse ca public key failure, nest error: %v", err)
+							}
+
+							knownHosts, err := openKnownHosts(cCtx)
+							if err != nil {
+								return err
+							}
+							patterns := strings.Split(cCtx.Args().First(), ",")
+							if err := knownHosts.TrustCA(patterns, key); err != nil {
+								return err
+							}
+							greenbold.Printf("==> Trusted CA [%s] %s %s\r\n", strings.Join(patterns, ","), key.Type(), ssh.FingerprintSHA256(key))
+							return nil
+						},
+					},
+				},
+			},
+
+			{
+				Name:      "vault",
+				Usage:     "加密存储机器列表",
+				UsageText: "./minishell vault encrypt|decrypt|rekey",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "encrypt",
+						Usage:     "将 .conf/.xlsx 机器列表加密为 .vault 文件",
+						UsageText: "./minishell vault encrypt <in.conf|in.xlsx|in.json|in.yaml|in.csv> [out.vault]",
+						Action: func(cCtx *cli.Context) error {
+							in := cCtx.Args().Get(0)
+							if in == "" {
+								return fmt.Errorf("missing input file")
+							}
+							if strings.HasSuffix(in, ".vault") {
+								return fmt.Errorf("input file is already a vault")
+							}
+							out := cCtx.Args().Get(1)
+							if out == "" {
+								out = strings.TrimSuffix(in, filepath.Ext(in)) + ".vault"
+							}
+
+							machines, err := assets.LoadFile(in)
+							if err != nil {
+								return err
+							}
+							passphrase, err := newVaultPassphrase("MINISHELL_VAULT_PASSPHRASE")
+							if err != nil {
+								return err
+							}
+							if err := assets.WriteVaultFile(out, machines, passphrase); err != nil {
+								return err
+							}
+							greenbold.Printf("==> Encrypted %d machines to [%s], please remove the plaintext file [%s]\r\n", len(machines), out, in)
+							return nil
+						},
+					},
+					{
+						Name:      "decrypt",
+						Usage:     "将 .vault 文件解密为机器列表, 默认输出 .conf",
+						UsageText: "./minishell vault decrypt <in.vault> [out.conf|out.xlsx|out.json|out.yaml|out.csv]",
+						Action: func(cCtx *cli.Context) error {
+							in := cCtx.Args().Get(0)
+							if !strings.HasSuffix(in, ".vault") {
+								return fmt.Errorf("input file must be a vault")
+							}
+							out := cCtx.Args().Get(1)
+							if out == "" {
+								out = strings.TrimSuffix(in, ".vault") + ".conf"
+							}
+
+							passphrase, err := assets.VaultPassphrase()
+							if err != nil {
+								return err
+							}
+							machines, err := assets.DecryptVaultFile(in, passphrase)
+							if err != nil {
+								return err
+							}
+							if err := assets.WriteFile(out, machines); err != nil {
+								return err
+							}
+							greenbold.Printf("==> Decrypted %d machines to [%s]\r\n", len(machines), out)
+							return nil
+						},
+					},
+					{
+						Name:      "rekey",
+						Usage:     "修改 .vault 文件的主密码",
+						UsageText: "./minishell vault rekey <file.vault>",
+						Action: func(cCtx *cli.Context) error {
+							path := cCtx.Args().First()
+							if !strings.HasSuffix(path, ".vault") {
+								return fmt.Errorf("input file must be a vault")
+							}
+
+							oldPassphrase, err := assets.VaultPassphrase()
+							if err != nil {
+								return err
+							}
+							newPassphrase, err := newVaultPassphrase("MINISHELL_VAULT_NEW_PASSPHRASE")
+							if err != nil {
+								return err
+							}
+							if err := assets.RekeyVaultFile(path, oldPassphrase, newPassphrase); err != nil {
+								return err
+							}
+							greenbold.Printf("==> Rekeyed [%s]\r\n", path)
+							return nil
+						},
+					},
+				},
+			},
+
+			{
+				Name:      "convert",
+				Usage:     "转换机器列表文件格式, 支持 xlsx、conf(toml)、json、yaml、csv、vault",
+				UsageText: "./minishell convert <in> <out>",
+				Action: func(cCtx *cli.Context) error {
+					if cCtx.Args().Len() != 2 {
+						return fmt.Errorf("usage: %s", cCtx.Command.UsageText)
+					}
+					in, out := cCtx.Args().Get(0), cCtx.Args().Get(1)
+					if !assets.IsMachineFile(out) {
+						return fmt.Errorf("not support output file: %v", out)
+					}
+
+					machines, err := assets.LoadFile(in)
+					if err != nil {
+						return err
+					}
+					if strings.EqualFold(filepath.Ext(out), ".vault") {
+						passphrase, err := newVaultPassphrase("MINISHELL_VAULT_PASSPHRASE")
+						if err != nil {
+							return err
+						}
+						err = assets.WriteVaultFile(out, machines, passphrase)
+					} else {
+						err = assets.WriteFile(out, machines)
+					}
+					if err != nil {
+						return err
+					}
+					greenbold.Printf("==> Converted %d machines from [%s] to [%s]\r\n", len(machines), in, out)
+					return nil
+				},
+			},
+
+			{
+				Name:      "add",
+				Usage:     "添加机器, 按原格式写回机器列表文件, 写入前备份原文件",
+				UsageText: "./minishell add --ip <ip> [options]",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "the machines file path"},
+				}, machineFlags()...),
+				Action: func(cCtx *cli.Context) error {
+					if !cCtx.IsSet("ip") {
+						return fmt.Errorf("missing flag: --ip")
+					}
+					inv, err := assets.OpenInventory(cCtx.String("file"))
+					if err != nil {
+						return err
+					}
+
+					machine := &assets.Machine{Port: 22, Username: "root", Device: "linux"}
+					if err := applyMachineFlags(cCtx, machine); err != nil {
+						return err
+					}
+					if err := inv.Add(machine); err != nil {
+						return err
+					}
+					backup, err := inv.Save()
+					if err != nil {
+						return err
+					}
+					greenbold.Printf("==> Added [%s] to [%s], backup: [%s]\r\n", machine.Addr(), inv.Path, backup)
+					return nil
+				},
+			},
+
+			{
+				Name:      "edit",
+				Usage:     "修改匹配的机器, 未指定的字段保持不变",
+				UsageText: "./minishell edit [options] <cond>",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "the machines file path"},
+					&cli.BoolFlag{Name: "all", Aliases: []string{"a"}, Usage: "edit all matched machines"},
+				}, machineFlags()...),
+				Action: func(cCtx *cli.Context) error {
+					machines, inv, err := findInventoryMachines(cCtx)
+					if err != nil {
+						return err
+					}
+					if len(machines) != 1 && !cCtx.Bool("all") {
+						return fmt.Errorf("matched %d machines, please specify one machine or use --all", len(machines))
+					}
+
+					for _, machine := range machines {
+						if err := applyMachineFlags(cCtx, machine); err != nil {
+							return err
+						}
+					}
+					backup, err := inv.Save()
+					if err != nil {
+						return err
+					}
+					greenbold.Printf("==> Edited %d machines in [%s], backup: [%s]\r\n", len(machines), inv.Path, backup)
+					return nil
+				},
+			},
+
+			{
+				Name:      "rm",
+				Usage:     "删除匹配的机器",
+				UsageText: "./minishell rm [options] <cond>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "the machines file path"},
+					&cli.BoolFlag{Name: "yes", Aliases: []string{"y"}, Usage: "do not ask for confirmation"},
+				},
+				Action: func(cCtx *cli.Context) error {
+					machines, inv, err := findInventoryMachines(cCtx)
+					if err != nil {
+						return err
+					}
+
+					if !cCtx.Bool("yes") {
+						terminal.RenderTable(machines, terminal.Option{})
+						ok, err := adapter.PromptYesNo(fmt.Sprintf("Remove %d machines from [%s] (yes/no)? ", len(machines), inv.Path))
+						if err != nil {
+							return err
+						}
+						if !ok {
+							return nil
+						}
+					}
+					n := inv.Remove(machines)
+					backup, err := inv.Save()
+					if err != nil {
+						return err
+					}
+					greenbold.Printf("==> Removed %d machines from [%s], backup: [%s]\r\n", n, inv.Path, backup)
+					return nil
+				},
+			},
+
+			{
+				Name:      "replay",
+				Usage:     "在终端回放 asciicast 录像",
+				UsageText: "./minishell replay [options] <file>",
+				Flags: []cli.Flag{
+					&cli.Float64Flag{Name: "speed", Aliases: []string{"s"}, Value: 1, Usage: "playback speed multiplier"},
+					&cli.DurationFlag{Name: "idle-limit", Aliases: []string{"i"}, Usage: "limit idle time between outputs, e.g. 2s"},
+				},
+				Action: func(cCtx *cli.Context) error {
+					if cCtx.Args().Len() != 1 {
+						return fmt.Errorf("usage: %s", cCtx.Command.UsageText)
+					}
+					f, err := os.Open(cCtx.Args().First())
+					if err != nil {
+						return err
+					}
+					defer f.Close()
+
+					reader, err := adapter.NewCastReader(f)
+					if err != nil {
+						return err
+					}
+					if w, h, err := term.GetSize(int(os.Stdout.Fd())); err == nil && (w < reader.Header.Width || h < reader.Header.Height) {
+						greenbold.Printf("==> Warn: terminal size %dx%d is smaller than recording %dx%d\r\n", w, h, reader.Header.Width, reader.Header.Height)
+					}
+
+					option := terminal.ReplayOption{Speed: cCtx.Float64("speed"), IdleLimit: cCtx.Duration("idle-limit")}
+					if err := terminal.Replay(reader, os.Stdout, option); err != nil {
+						return err
+					}
+					fmt.Println()
+					greenbold.Println("==> Replay finished")
+					return nil
 				},
 			},
 
@@ -79,6 +820,15 @@ func main() {
 				},
 			},
 		},
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "the machines file path"},
+			&cli.StringFlag{Name: "host-key-policy", Value: "ask", Usage: "unknown host key policy: ask|accept-new|strict"},
+			&cli.StringFlag{Name: "record", EnvVars: []string{"MINISHELL_RECORD_DIR"}, Usage: "record interactive sessions as asciicast v2 files in the directory"},
+			&cli.DurationFlag{Name: "keepalive", Value: 30 * time.Second, EnvVars: []string{"MINISHELL_KEEPALIVE"}, Usage: "interval of keepalive requests, 0 to disable"},
+			&cli.IntFlag{Name: "keepalive-max", Value: 3, Usage: "disconnect after this many unanswered keepalive requests"},
+			&cli.IntFlag{Name: "retries", Value: 2, Usage: "retry times on network errors when connecting"},
+			&cli.DurationFlag{Name: "retry-backoff", Value: time.Second, Usage: "wait time before the first retry, doubled on each retry"},
+		},
 		EnableBashCompletion: true,
 		HideHelpCommand:      true,
 		Action: func(cCtx *cli.Context) error {
@@ -86,7 +836,27 @@ func main() {
 			switch args {
 			case 0:
 				path := cCtx.String("file")
-				return terminal.RenderTableFromFile(path, false)
+				if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+					return terminal.RenderTableFromFile(path, false)
+				}
+
+				// 有登录记录时选择 machine, 最近登录的排在最前
+				machines, err := assets.LoadFile(path)
+				if err != nil {
+					return err
+				}
+				entries, err := assets.OpenHistory("").Load()
+				if err != nil || len(entries) == 0 {
+					return terminal.RenderTableFromFile(path, false)
+				}
+				machine, err := terminal.Pick(assets.RecentFirst(machines, assets.SummarizeHistory(entries, machines)))
+				if err == terminal.ErrPickCanceled {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				return interactiveLogin(cCtx, machine)
 
 			default:
 				path := cCtx.String("file")
@@ -94,7 +864,7 @@ func main() {
 				if err != nil {
 					return err
 				}
-				cond := cCtx.Args().First()
+				cond := strings.Join(cCtx.Args().Slice(), " ")
 				machines, err = machines.Find(cond)
 
 				if err == assets.ErrNotFound {
@@ -105,47 +875,26 @@ func main() {
 					redbold.Printf("==> Error: 查找主机失败, nest error: %v\r\n", err)
 					return nil
 				}
-				if len(machines) == 1 {
-					machine := machines[0]
-					greenbold.Printf("==> Prepare to login [%s/%s]\r\n", machine.NatIP, machine.IP)
-					fmt.Println()
-
-					ip := machine.IP
-					if machine.NatIP != "" && machine.NatIP != "无" {
-						ip = machine.NatIP
+				if len(machines) > 1 && term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd())) {
+					machine, err := terminal.Pick(machines)
+					if err == terminal.ErrPickCanceled {
+						return nil
 					}
-					var privateKeyPath string
-					if machine.PrivateKeyPath != "" && machine.PrivateKeyPath != assets.NotExist {
-						privateKeyPath = machine.PrivateKeyPath
-					}
-					var password string
-					if machine.Password != "" && machine.Password != assets.NotExist {
-						password = machine.Password
-					}
-
-					if err := adapter.InteractiveWithTerminalForSSH(machine.Username, password, privateKeyPath, ip, machine.Port, 10*time.Second, strings.EqualFold(machine.Device, "linux")); err != nil {
-						greenbold.Printf("==> Fatal: Login resource failure, nest error: %v, resource: %v\r\n", err, ip)
-						fmt.Println()
-						os.Exit(1)
+					if err != nil {
+						return err
 					}
-					greenbold.Println("==> Logout")
-					return nil
+					machines = []*assets.Machine{machine}
+				}
+				if len(machines) == 1 {
+					return interactiveLogin(cCtx, machines[0])
 				}
 
 				machinesWrapper := make([]*assets.Machine, 0, len(machines))
 				for _, machine := range machines {
-					machinesWrapper = append(machinesWrapper, &assets.Machine{
-						Num:            machine.Num,
-						NatIP:          strings.ReplaceAll(machine.NatIP, cond, red.Sprintf("%s", cond)),
-						IP:             strings.ReplaceAll(machine.IP, cond, red.Sprintf("%s", cond)),
-						Username:       machine.Username,
-						Password:       machine.Password,
-						Port:           machine.Port,
-						Timeout:        machine.Timeout,
-						PrivateKeyPath: machine.PrivateKeyPath,
-						Device:         machine.Device,
-						Remark:         machine.Remark,
-					})
+					wrapper := *machine
+					wrapper.NatIP = strings.ReplaceAll(machine.NatIP, cond, red.Sprintf("%s", cond))
+					wrapper.IP = strings.ReplaceAll(machine.IP, cond, red.Sprintf("%s", cond))
+					machinesWrapper = append(machinesWrapper, &wrapper)
 				}
 				terminal.RenderTable(machinesWrapper, terminal.Option{FooterContent: greenbold.Sprintf("==> Warn: 包含多台 machine, 请指定一台 machine")})
 				return nil
@@ -157,3 +906,480 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+var transferFlags = []cli.Flag{
+	&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "the machines file path"},
+	&cli.IntFlag{Name: "concurrency", Aliases: []string{"c"}, Value: 5, Usage: "maximum number of machines transferring at the same time"},
+	&cli.BoolFlag{Name: "recursive", Aliases: []string{"r"}, Usage: "recursively copy entire directories"},
+	&cli.BoolFlag{Name: "preserve", Aliases: []string{"p"}, Usage: "preserve modification times and modes"},
+}
+
+func transfer(cCtx *cli.Context, push bool) error {
+	if cCtx.Args().Len() != 3 {
+		return fmt.Errorf("usage: %s", cCtx.Command.UsageText)
+	}
+	cond, src, dst := cCtx.Args().Get(0), cCtx.Args().Get(1), cCtx.Args().Get(2)
+
+	machines, err := assets.LoadFile(cCtx.String("file"))
+	if err != nil {
+		return err
+	}
+	machines, err = machines.Find(cond)
+	if err != nil {
+		return err
+	}
+	dialer, err := newDialer(cCtx)
+	if err != nil {
+		return err
+	}
+
+	option := adapter.TransferOption{
+		CopyOption: adapter.CopyOption{
+			Recursive: cCtx.Bool("recursive"),
+			Preserve:  cCtx.Bool("preserve"),
+		},
+		Concurrency: cCtx.Int("concurrency"),
+	}
+
+	var progress *terminal.Progress
+	if term.IsTerminal(int(os.Stdout.Fd())) {
+		hosts := make([]string, 0, len(machines))
+		for _, machine := range machines {
+			hosts = append(hosts, machine.FileName())
+		}
+		progress = terminal.NewProgress(os.Stdout, hosts)
+		option.Progress = func(machine *assets.Machine, file string, written, size int64) {
+			progress.Update(machine.FileName(), file, written, size)
+		}
+		option.Done = func(result *adapter.TransferResult) {
+			progress.Complete(result.Machine.FileName(), result.Err)
+		}
+	}
+
+	var results []*adapter.TransferResult
+	if push {
+		results = adapter.PushToMachines(dialer, machines, src, dst, option)
+	} else {
+		results = adapter.PullFromMachines(dialer, machines, src, dst, option)
+	}
+	if progress != nil {
+		progress.Stop()
+	}
+	terminal.RenderTransferResults(results)
+	return nil
+}
+
+var historyFlags = []cli.Flag{
+	&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "the machines file path"},
+	&cli.IntFlag{Name: "limit", Aliases: []string{"n"}, Value: 10, Usage: "maximum number of machines to show, 0 for all"},
+}
+
+// renderHistory 显示登录统计, byCount 为 true 时按登录次数排序, 否则按最近登录时间排序
+func renderHistory(cCtx *cli.Context, byCount bool) error {
+	entries, err := assets.OpenHistory("").Load()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		greenbold.Println("==> No login history")
+		return nil
+	}
+	// 机器列表加载失败时仍显示记录, 只是无法显示序号
+	machines, err := assets.LoadFile(cCtx.String("file"))
+	if err != nil {
+		red.Printf("==> Warning: load machines failure, nest error: %v\r\n", err)
+	}
+
+	stats := assets.SummarizeHistory(entries, machines)
+	if byCount {
+		assets.SortByCount(stats)
+	}
+	if limit := cCtx.Int("limit"); limit > 0 && len(stats) > limit {
+		stats = stats[:limit]
+	}
+	terminal.RenderHistory(stats)
+	return nil
+}
+
+// interactiveLogin 登录 machine, 连接意外断开时询问是否重连
+func interactiveLogin(cCtx *cli.Context, machine *assets.Machine) error {
+	greenbold.Printf("==> Prepare to login [%s/%s]\r\n", machine.NatIP, machine.IP)
+	fmt.Println()
+
+	dialer, err := newDialer(cCtx)
+	if err != nil {
+		return err
+	}
+
+	for {
+		err := login(cCtx, dialer, machine)
+		if err != adapter.ErrConnectionLost {
+			if err != nil {
+				greenbold.Printf("==> Fatal: Login resource failure, nest error: %v, resource: %v\r\n", err, machine.Addr())
+				fmt.Println()
+				os.Exit(1)
+			}
+			greenbold.Println("==> Logout")
+			return nil
+		}
+
+		fmt.Println()
+		redbold.Printf("==> Connection to [%s] lost\r\n", machine.Addr())
+		ok, err := adapter.PromptYesNo("Reconnect (yes/no)? ")
+		if err != nil || !ok {
+			return err
+		}
+	}
+}
+
+// recordLogin 记录一次登录, 记录失败不影响登录
+func recordLogin(machine *assets.Machine, start, end time.Time, err error) {
+	exit := assets.ExitLogout
+	switch {
+	case errors.Is(err, adapter.ErrConnectionLost):
+		exit = assets.ExitConnectionLost
+	case err != nil:
+		exit = err.Error()
+	}
+	if err := assets.OpenHistory("").Append(assets.NewHistoryEntry(machine, start, end, exit)); err != nil {
+		red.Printf("==> Warning: record login history failure, nest error: %v\r\n", err)
+	}
+}
+
+// login 打开交互式会话, 开启录像时每次登录(包括重连)写入新的录像文件
+func login(cCtx *cli.Context, dialer *adapter.Dialer, machine *assets.Machine) error {
+	var cast io.Writer
+	if f, err := openCastFile(cCtx.String("record"), machine); err != nil {
+		return err
+	} else if f != nil {
+		defer f.Close()
+		greenbold.Printf("==> Recording to %s\r\n", f.Name())
+		cast = f
+	}
+	steps, err := assets.LoginSteps(machine)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	if machine.IsTelnet() {
+		err = adapter.InteractiveWithTerminalForTelnet(dialer, machine, steps, cast)
+	} else {
+		err = adapter.InteractiveWithTerminalForSSH(dialer, machine, steps, cast)
+	}
+	recordLogin(machine, start, time.Now(), err)
+	return err
+}
+
+// findOneMachine 查找 cond 匹配的 machine, 必须恰好匹配一台
+func findOneMachine(cCtx *cli.Context, cond string) (*assets.Machine, error) {
+	machines, err := assets.LoadFile(cCtx.String("file"))
+	if err != nil {
+		return nil, err
+	}
+	machines, err = machines.Find(cond)
+	if err != nil {
+		return nil, err
+	}
+	if len(machines) != 1 {
+		return nil, fmt.Errorf("matched %d machines, please specify one machine", len(machines))
+	}
+	return machines[0], nil
+}
+
+// newLogf 返回带时间与 machine 前缀的日志输出
+func newLogf(machine *assets.Machine) func(format string, args ...interface{}) {
+	return func(format string, args ...interface{}) {
+		fmt.Printf("%s [%s] %s\r\n", time.Now().Format("2006-01-02 15:04:05"), machine.IP, fmt.Sprintf(format, args...))
+	}
+}
+
+// closeOnSignal 收到 Ctrl+C 或 SIGTERM 时调用 close
+func closeOnSignal(close func() error) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close()
+	}()
+}
+
+// openCastFile 在 dir 下创建 machine 的会话录像文件 <user>@<ip>-<port>-<时间>.cast, 文件已存在时加序号重试; dir 为空时不录像
+func openCastFile(dir string, machine *assets.Machine) (*os.File, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if err := fs.MkdirAll(dir); err != nil {
+		return nil, err
+	}
+	base := fmt.Sprintf("%s-%s", machine.FileName(), time.Now().Format("20060102-150405.000"))
+	for i := 0; ; i++ {
+		name := base + ".cast"
+		if i != 0 {
+			name = fmt.Sprintf("%s-%d.cast", base, i)
+		}
+		f, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+		if os.IsExist(err) && i < 100 {
+			continue
+		}
+		return f, err
+	}
+}
+
+// parseForwards 解析 -L/-R 参数, 兼容写在 <cond> 之后的 -L/-R
+func parseForwards(cCtx *cli.Context) ([]*adapter.Forward, error) {
+	var (
+		locals  = cCtx.StringSlice("local")
+		remotes = cCtx.StringSlice("remote")
+		args    = cCtx.Args().Tail()
+	)
+	for i := 0; i < len(args); i++ {
+		var specs *[]string
+		switch flag := args[i]; {
+		case flag == "-L" || flag == "--local":
+			specs = &locals
+		case flag == "-R" || flag == "--remote":
+			specs = &remotes
+		case strings.HasPrefix(flag, "-L"):
+			locals = append(locals, strings.TrimPrefix(flag, "-L"))
+			continue
+		case strings.HasPrefix(flag, "-R"):
+			remotes = append(remotes, strings.TrimPrefix(flag, "-R"))
+			continue
+		default:
+			return nil, fmt.Errorf("unexpected argument: %v", flag)
+		}
+		if i+1 == len(args) {
+			return nil, fmt.Errorf("flag needs an argument: %v", args[i])
+		}
+		i++
+		*specs = append(*specs, args[i])
+	}
+
+	forwards := make([]*adapter.Forward, 0, len(locals)+len(remotes))
+	for _, spec := range locals {
+		forward, err := adapter.ParseForward(spec, false)
+		if err != nil {
+			return nil, err
+		}
+		forwards = append(forwards, forward)
+	}
+	for _, spec := range remotes {
+		forward, err := adapter.ParseForward(spec, true)
+		if err != nil {
+			return nil, err
+		}
+		forwards = append(forwards, forward)
+	}
+	return forwards, nil
+}
+
+func openKnownHosts(cCtx *cli.Context) (*adapter.KnownHosts, error) {
+	knownHosts, err := adapter.OpenKnownHosts(filepath.Join(system.Directory.VarDir, "known_hosts"))
+	if err != nil {
+		return nil, err
+	}
+
+	switch policy := cCtx.String("host-key-policy"); policy {
+	case "", "ask":
+		knownHosts.Policy = adapter.HostKeyAsk
+	case "accept-new":
+		knownHosts.Policy = adapter.HostKeyAcceptNew
+	case "strict":
+		knownHosts.Policy = adapter.HostKeyStrict
+	default:
+		return nil, fmt.Errorf("invalid host-key-policy: %v", policy)
+	}
+	return knownHosts, nil
+}
+
+// machineFlags add/edit 共用的 machine 字段参数
+func machineFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "ip", Usage: "ip or hostname"},
+		&cli.StringFlag{Name: "nat-ip", Usage: "nat ip, used to login first"},
+		&cli.IntFlag{Name: "port", Aliases: []string{"p"}, Value: 22, Usage: "ssh port"},
+		&cli.StringFlag{Name: "user", Aliases: []string{"u"}, Value: "root", Usage: "ssh username"},
+		&cli.StringFlag{Name: "password", Usage: "ssh password, prefer --ask-password to keep it out of shell history"},
+		&cli.BoolFlag{Name: "ask-password", Usage: "read ssh password from terminal"},
+		&cli.StringFlag{Name: "key", Aliases: []string{"i"}, Usage: "private key path"},
+		&cli.StringFlag{Name: "passphrase-command", Usage: "command that prints the private key passphrase"},
+		&cli.StringFlag{Name: "cert", Usage: "OpenSSH user certificate (-cert.pub) signed for the private key"},
+		&cli.StringFlag{Name: "device", Value: "linux", Usage: "device type"},
+		&cli.StringFlag{Name: "remark", Usage: "remark"},
+		&cli.StringFlag{Name: "group", Usage: "group"},
+		&cli.StringSliceFlag{Name: "tag", Usage: "tags, can be repeated"},
+		&cli.StringSliceFlag{Name: "jump", Usage: "jump hosts in connection order, can be repeated"},
+		&cli.DurationFlag{Name: "timeout", Usage: "connect timeout, e.g. 5s"},
+		&cli.BoolFlag{Name: "forward-agent", Aliases: []string{"A"}, Usage: "forward local ssh-agent to the machine"},
+		&cli.StringFlag{Name: "protocol", Value: "ssh", Usage: "login protocol: ssh or telnet, port defaults to 23 for telnet"},
+		&cli.StringFlag{Name: "become", Usage: "switch user after login: sudo or su, optionally with target user like su:oracle, empty to disable"},
+		&cli.BoolFlag{Name: "ask-become-password", Usage: "read become password from terminal, login password is used if not set"},
+	}
+}
+
+// applyMachineFlags 将命令行中指定的字段写入 machine, 未指定的字段保持不变
+func applyMachineFlags(cCtx *cli.Context, machine *assets.Machine) error {
+	if cCtx.IsSet("ip") {
+		machine.IP = cCtx.String("ip")
+	}
+	if cCtx.IsSet("nat-ip") {
+		machine.NatIP = cCtx.String("nat-ip")
+	}
+	if cCtx.IsSet("port") {
+		machine.Port = cCtx.Int("port")
+	}
+	if cCtx.IsSet("user") {
+		machine.Username = cCtx.String("user")
+	}
+	if cCtx.IsSet("password") {
+		machine.SetPassword(cCtx.String("password"))
+	}
+	if cCtx.Bool("ask-password") {
+		password, err := assets.ReadPassphrase(fmt.Sprintf("Password for %s: ", machine.IP))
+		if err != nil {
+			return err
+		}
+		machine.SetPassword(string(password))
+	}
+	if cCtx.IsSet("key") {
+		machine.PrivateKeyPath = cCtx.String("key")
+	}
+	if cCtx.IsSet("passphrase-command") {
+		machine.PassphraseCommand = cCtx.String("passphrase-command")
+	}
+	if cCtx.IsSet("cert") {
+		machine.Certificate = cCtx.String("cert")
+	}
+	if cCtx.IsSet("device") {
+		machine.Device = cCtx.String("device")
+	}
+	if cCtx.IsSet("remark") {
+		machine.Remark = cCtx.String("remark")
+	}
+	if cCtx.IsSet("group") {
+		machine.Group = cCtx.String("group")
+	}
+	if cCtx.IsSet("tag") {
+		machine.Tags = cCtx.StringSlice("tag")
+	}
+	if cCtx.IsSet("jump") {
+		machine.Jump = cCtx.StringSlice("jump")
+	}
+	if cCtx.IsSet("timeout") {
+		machine.Timeout = cCtx.Duration("timeout")
+	}
+	if cCtx.IsSet("forward-agent") {
+		machine.ForwardAgent = cCtx.Bool("forward-agent")
+	}
+	if cCtx.IsSet("protocol") {
+		machine.Protocol = strings.ToLower(cCtx.String("protocol"))
+		if machine.Protocol == assets.ProtocolSSH {
+			machine.Protocol = ""
+		}
+		if machine.IsTelnet() && !cCtx.IsSet("port") && machine.Port == 22 {
+			machine.Port = 23
+		}
+	}
+	if cCtx.IsSet("become") {
+		if v := cCtx.String("become"); v == "" {
+			machine.Become = nil
+		} else {
+			become, err := assets.ParseBecome(v)
+			if err != nil {
+				return err
+			}
+			if machine.Become != nil {
+				machine.Become.Method, machine.Become.User = become.Method, become.User
+			} else {
+				machine.Become = become
+			}
+		}
+	}
+	if cCtx.Bool("ask-become-password") {
+		if machine.Become == nil {
+			return fmt.Errorf("--ask-become-password requires --become")
+		}
+		password, err := assets.ReadPassphrase(fmt.Sprintf("Become password for %s: ", machine.IP))
+		if err != nil {
+			return err
+		}
+		machine.Become.SetPassword(string(password))
+	}
+	return nil
+}
+
+// findInventoryMachines 返回 cond 匹配的 machine 及其所在的机器列表文件; 未指定文件时在合并后的列表中查找
+func findInventoryMachines(cCtx *cli.Context) (assets.MachineList, *assets.Inventory, error) {
+	cond := strings.Join(cCtx.Args().Slice(), " ")
+	if cond == "" {
+		return nil, nil, fmt.Errorf("usage: %s", cCtx.Command.UsageText)
+	}
+	if path := cCtx.String("file"); path != "" {
+		inv, err := assets.OpenInventory(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		machines, err := inv.Machines.Find(cond)
+		if err != nil {
+			return nil, nil, err
+		}
+		return machines, inv, nil
+	}
+
+	machines, err := assets.LoadFile("")
+	if err != nil {
+		return nil, nil, err
+	}
+	if machines, err = machines.Find(cond); err != nil {
+		return nil, nil, err
+	}
+	inv, machines, err := assets.OpenInventoryOf(machines)
+	if err != nil {
+		return nil, nil, err
+	}
+	return machines, inv, nil
+}
+
+// newVaultPassphrase 读取环境变量 env 中的新主密码, 未设置时在终端输入两次确认
+func newVaultPassphrase(env string) ([]byte, error) {
+	if passphrase := os.Getenv(env); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+
+	passphrase, err := assets.ReadPassphrase("New vault passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	confirm, err := assets.ReadPassphrase("Confirm vault passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if string(passphrase) != string(confirm) {
+		return nil, fmt.Errorf("passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+func newDialer(cCtx *cli.Context) (*adapter.Dialer, error) {
+	knownHosts, err := openKnownHosts(cCtx)
+	if err != nil {
+		return nil, err
+	}
+	// 与 OpenSSH 一样, ssh-agent 不可用时只提示, 不使用 agent 继续登录
+	agent, agentErr := adapter.ConnectAgent()
+	if agentErr != nil {
+		red.Printf("==> Warning: %v\r\n", agentErr)
+	}
+	return &adapter.Dialer{
+		KnownHosts:       knownHosts,
+		Timeout:          10 * time.Second,
+		Retries:          cCtx.Int("retries"),
+		Backoff:          cCtx.Duration("retry-backoff"),
+		Keepalive:        cCtx.Duration("keepalive"),
+		KeepaliveMax:     cCtx.Int("keepalive-max"),
+		Agent:            agent,
+		AgentErr:         agentErr,
+		PassphrasePrompt: assets.ReadPassphrase,
+	}, nil
+}
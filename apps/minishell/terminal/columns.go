@@ -0,0 +1,384 @@
+package terminal
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	OutputTable = "table"
+	OutputWide  = "wide"
+	OutputJSON  = "json"
+	OutputCSV   = "csv"
+	OutputYAML  = "yaml"
+)
+
+// Outputs show 支持的输出格式
+var Outputs = []string{OutputTable, OutputWide, OutputJSON, OutputCSV, OutputYAML}
+
+// column 一列: key 用于 --columns、--sort 以及 json/csv/yaml 的字段名, header 为表格的列名
+type column struct {
+	key     string
+	aliases []string
+	header  string
+	// text 表格、csv 中显示的内容, reveal 为 true 时显示明文密码
+	text func(m *assets.Machine, reveal bool) string
+	// value json/yaml 中的值, 为空时使用 text
+	value func(m *assets.Machine, reveal bool) interface{}
+}
+
+const masked = "********"
+
+var columns = []*column{
+	{key: "num", header: "No",
+		text:  func(m *assets.Machine, _ bool) string { return fmt.Sprintf("%3d", m.Num) },
+		value: func(m *assets.Machine, _ bool) interface{} { return m.Num },
+	},
+	{key: "ip", header: "IP", text: func(m *assets.Machine, _ bool) string { return m.IP }},
+	{key: "nat-ip", header: "NAT-IP", text: func(m *assets.Machine, _ bool) string { return m.NatIP }},
+	{key: "port", header: "Port",
+		text:  func(m *assets.Machine, _ bool) string { return strconv.Itoa(m.Port) },
+		value: func(m *assets.Machine, _ bool) interface{} { return m.Port },
+	},
+	{key: "username", aliases: []string{"user"}, header: "User", text: func(m *assets.Machine, _ bool) string { return m.Username }},
+	{key: "password", header: "Password", text: func(m *assets.Machine, reveal bool) string {
+		if reveal {
+			password, _ := m.RevealPassword()
+			return password
+		}
+		if m.HasPassword() {
+			return masked
+		}
+		return m.Password
+	}},
+	{key: "private-key", header: "PrivateKey-Path", text: func(m *assets.Machine, reveal bool) string {
+		if reveal || m.PrivateKeyPath == "" || m.PrivateKeyPath == assets.NotExist {
+			return m.PrivateKeyPath
+		}
+		return masked
+	}},
+	{key: "device", header: "Device", text: func(m *assets.Machine, _ bool) string { return m.Device }},
+	{key: "remark", header: "Remark", text: func(m *assets.Machine, _ bool) string { return m.Remark }},
+	{key: "group", header: "Group", text: func(m *assets.Machine, _ bool) string { return m.Group }},
+	{key: "tags", aliases: []string{"tag"}, header: "Tags",
+		text:  func(m *assets.Machine, _ bool) string { return strings.Join(m.Tags, ",") },
+		value: func(m *assets.Machine, _ bool) interface{} { return nonNil(m.Tags) },
+	},
+	{key: "jump", header: "Jump",
+		text:  func(m *assets.Machine, _ bool) string { return jumpPath(m) },
+		value: func(m *assets.Machine, _ bool) interface{} { return nonNil(m.Jump) },
+	},
+	{key: "source", header: "Source", text: func(m *assets.Machine, _ bool) string { return filepath.Base(m.Source) }},
+	{key: "protocol", header: "Protocol", text: func(m *assets.Machine, _ bool) string {
+		if m.Protocol == "" {
+			return assets.ProtocolSSH
+		}
+		return m.Protocol
+	}},
+	{key: "timeout", header: "Timeout", text: func(m *assets.Machine, _ bool) string {
+		if m.Timeout == 0 {
+			return ""
+		}
+		return m.Timeout.String()
+	}},
+	{key: "forward-agent", header: "Forward-Agent",
+		text:  func(m *assets.Machine, _ bool) string { return strconv.FormatBool(m.ForwardAgent) },
+		value: func(m *assets.Machine, _ bool) interface{} { return m.ForwardAgent },
+	},
+	{key: "certificate", header: "Certificate", text: func(m *assets.Machine, _ bool) string { return m.Certificate }},
+	{key: "become", header: "Become", text: func(m *assets.Machine, _ bool) string { return m.Become.String() }},
+	{key: "attrs", header: "Attrs",
+		text: func(m *assets.Machine, _ bool) string {
+			keys := attrKeys(m)
+			pairs := make([]string, 0, len(keys))
+			for _, k := range keys {
+				pairs = append(pairs, k+"="+m.Attrs[k])
+			}
+			return strings.Join(pairs, ",")
+		},
+		value: func(m *assets.Machine, _ bool) interface{} {
+			if m.Attrs == nil {
+				return map[string]string{}
+			}
+			return m.Attrs
+		},
+	},
+}
+
+// DefaultColumns table 输出的列
+var DefaultColumns = []string{"num", "ip", "nat-ip", "port", "username", "password", "private-key", "device", "remark", "group", "tags", "jump", "source"}
+
+// WideColumns wide、json、csv、yaml 输出的列
+var WideColumns = []string{"num", "ip", "nat-ip", "port", "username", "password", "private-key", "device", "remark", "group", "tags", "jump", "source", "protocol", "timeout", "forward-agent", "certificate", "become", "attrs"}
+
+// lookupColumn 按 key 或别名查找列(忽略大小写), attr:<name> 为自定义属性 name
+func lookupColumn(key string) (*column, error) {
+	key = strings.TrimSpace(key)
+	if name, ok := cutPrefixFold(key, "attr:"); ok && name != "" {
+		return &column{
+			key:    "attr:" + name,
+			header: name,
+			text:   func(m *assets.Machine, _ bool) string { return m.Attrs[name] },
+		}, nil
+	}
+	for _, c := range columns {
+		if strings.EqualFold(c.key, key) {
+			return c, nil
+		}
+		for _, alias := range c.aliases {
+			if strings.EqualFold(alias, key) {
+				return c, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unknown column %q, available: %s, attr:<name>", key, strings.Join(WideColumns, ", "))
+}
+
+// ParseColumns 解析以逗号分隔的列名, 如 "ip,port,remark,attr:owner"
+func ParseColumns(spec string) ([]string, error) {
+	keys := make([]string, 0, 8)
+	for _, key := range strings.Split(spec, ",") {
+		if strings.TrimSpace(key) == "" {
+			continue
+		}
+		c, err := lookupColumn(key)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, c.key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no column in %q", spec)
+	}
+	return keys, nil
+}
+
+func resolveColumns(keys []string) ([]*column, error) {
+	resolved := make([]*column, 0, len(keys))
+	for _, key := range keys {
+		c, err := lookupColumn(key)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, c)
+	}
+	return resolved, nil
+}
+
+// SortMachines 按 spec 对 machines 稳定排序, spec 为以逗号分隔的列名, 前缀 "-" 表示倒序, 如 "group,-port".
+// 数字、IP、时长按值比较, 其余按字符串比较(忽略大小写)
+func SortMachines(machines []*assets.Machine, spec string) error {
+	type sortKey struct {
+		column *column
+		desc   bool
+	}
+
+	keys := make([]sortKey, 0, 2)
+	for _, key := range strings.Split(spec, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		desc := strings.HasPrefix(key, "-")
+		c, err := lookupColumn(strings.TrimPrefix(key, "-"))
+		if err != nil {
+			return err
+		}
+		keys = append(keys, sortKey{column: c, desc: desc})
+	}
+
+	sort.SliceStable(machines, func(i, j int) bool {
+		for _, key := range keys {
+			n := compareText(key.column.text(machines[i], false), key.column.text(machines[j], false))
+			if n == 0 {
+				continue
+			}
+			if key.desc {
+				return n > 0
+			}
+			return n < 0
+		}
+		return false
+	})
+	return nil
+}
+
+func compareText(a, b string) int {
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	if x, err := strconv.Atoi(a); err == nil {
+		if y, err := strconv.Atoi(b); err == nil {
+			return compareInt(x, y)
+		}
+	}
+	if x, y := net.ParseIP(a), net.ParseIP(b); x != nil && y != nil {
+		return bytes.Compare(x.To16(), y.To16())
+	}
+	if x, err := time.ParseDuration(a); err == nil {
+		if y, err := time.ParseDuration(b); err == nil {
+			return compareInt(int(x), int(y))
+		}
+	}
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
+func compareInt(x, y int) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// RenderMachines 按 output 格式将 machines 写入 w, option.Columns 为空时 table 使用 DefaultColumns, 其余格式使用 WideColumns
+func RenderMachines(w io.Writer, machines []*assets.Machine, output string, option Option) error {
+	keys := option.Columns
+	if len(keys) == 0 {
+		keys = WideColumns
+		if output == OutputTable || output == "" {
+			keys = DefaultColumns
+		}
+	}
+	cols, err := resolveColumns(keys)
+	if err != nil {
+		return err
+	}
+
+	switch output {
+	case OutputTable, OutputWide, "":
+		renderTable(w, machines, cols, option)
+		return nil
+	case OutputCSV:
+		return writeCSV(w, machines, cols, option.ShowPassword)
+	case OutputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "    ")
+		return encoder.Encode(records(machines, cols, option.ShowPassword))
+	case OutputYAML:
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(records(machines, cols, option.ShowPassword)); err != nil {
+			return err
+		}
+		return encoder.Close()
+	default:
+		return fmt.Errorf("not support output %q, available: %s", output, strings.Join(Outputs, ", "))
+	}
+}
+
+func writeCSV(w io.Writer, machines []*assets.Machine, cols []*column, reveal bool) error {
+	writer := csv.NewWriter(w)
+	header := make([]string, 0, len(cols))
+	for _, c := range cols {
+		header = append(header, c.key)
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, machine := range machines {
+		line := make([]string, 0, len(cols))
+		for _, c := range cols {
+			line = append(line, strings.TrimSpace(c.text(machine, reveal)))
+		}
+		if err := writer.Write(line); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// record json/yaml 中的一台 machine, 字段按列的顺序输出
+type record struct {
+	keys   []string
+	values []interface{}
+}
+
+func records(machines []*assets.Machine, cols []*column, reveal bool) []*record {
+	list := make([]*record, 0, len(machines))
+	for _, machine := range machines {
+		r := &record{keys: make([]string, 0, len(cols)), values: make([]interface{}, 0, len(cols))}
+		for _, c := range cols {
+			r.keys = append(r.keys, c.key)
+			if c.value != nil {
+				r.values = append(r.values, c.value(machine, reveal))
+			} else {
+				r.values = append(r.values, c.text(machine, reveal))
+			}
+		}
+		list = append(list, r)
+	}
+	return list
+}
+
+func (r *record) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range r.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(r.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (r *record) MarshalYAML() (interface{}, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for i, key := range r.keys {
+		value := &yaml.Node{}
+		if err := value.Encode(r.values[i]); err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+	}
+	return node, nil
+}
+
+func attrKeys(m *assets.Machine) []string {
+	keys := make([]string, 0, len(m.Attrs))
+	for k := range m.Attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
+
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+		return s[len(prefix):], true
+	}
+	return "", false
+}
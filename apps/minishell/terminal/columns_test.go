@@ -0,0 +1,53 @@
+package terminal
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderMachines(t *testing.T) {
+	assert := assert.New(t)
+
+	machines := []*assets.Machine{
+		{Num: 1, IP: "10.0.0.10", Port: 22, Username: "root", Password: "pw", Group: "prod", Tags: []string{"a", "b"}, Attrs: map[string]string{"owner": "ops"}},
+		{Num: 2, IP: "10.0.0.9", Port: 2222, Username: "ops", Group: "dev"},
+		{Num: 3, IP: "10.0.0.11", Port: 22, Username: "ops", Group: "prod"},
+	}
+
+	assert.Nil(SortMachines(machines, "ip"))
+	assert.Equal([]int{2, 1, 3}, nums(machines))
+	assert.Nil(SortMachines(machines, "group,-port,user"))
+	assert.Equal([]int{2, 3, 1}, nums(machines))
+	assert.NotNil(SortMachines(machines, "unknown"))
+
+	keys, err := ParseColumns("IP, user,attr:owner")
+	assert.Nil(err)
+	assert.Equal([]string{"ip", "username", "attr:owner"}, keys)
+	_, err = ParseColumns("ip,unknown")
+	assert.NotNil(err)
+
+	var buf bytes.Buffer
+	assert.Nil(RenderMachines(&buf, machines[2:], OutputJSON, Option{Columns: []string{"num", "password", "tags", "attr:owner"}}))
+	assert.JSONEq(`[{"num":1,"password":"********","tags":["a","b"],"attr:owner":"ops"}]`, buf.String())
+
+	buf.Reset()
+	assert.Nil(RenderMachines(&buf, machines[2:], OutputCSV, Option{Columns: []string{"ip", "password"}, ShowPassword: true}))
+	assert.Equal("ip,password\n10.0.0.10,pw\n", buf.String())
+
+	buf.Reset()
+	assert.Nil(RenderMachines(&buf, machines[:1], OutputYAML, Option{Columns: []string{"port", "username"}}))
+	assert.Equal("- port: 2222\n  username: ops\n", buf.String())
+
+	assert.NotNil(RenderMachines(&buf, machines, "xml", Option{}))
+}
+
+func nums(machines []*assets.Machine) []int {
+	list := make([]int, 0, len(machines))
+	for _, machine := range machines {
+		list = append(list, machine.Num)
+	}
+	return list
+}
@@ -0,0 +1,96 @@
+package terminal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/adapter"
+	"github.com/olekukonko/tablewriter"
+)
+
+// PrefixWriter 按行为输出添加前缀, 多个 PrefixWriter 共享同一把锁以免行之间交错
+type PrefixWriter struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	prefix string
+	buf    bytes.Buffer
+}
+
+func NewPrefixWriter(w io.Writer, mu *sync.Mutex, prefix string) *PrefixWriter {
+	return &PrefixWriter{w: w, mu: mu, prefix: prefix}
+}
+
+func (p *PrefixWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.buf.Write(b)
+	for {
+		i := bytes.IndexByte(p.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		if err := p.writeLine(p.buf.Next(i + 1)); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Flush 输出最后一行不以换行结尾的内容
+func (p *PrefixWriter) Flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.buf.Len() == 0 {
+		return nil
+	}
+	return p.writeLine(append(p.buf.Next(p.buf.Len()), '\n'))
+}
+
+func (p *PrefixWriter) writeLine(line []byte) error {
+	if _, err := io.WriteString(p.w, p.prefix); err != nil {
+		return err
+	}
+	_, err := p.w.Write(line)
+	return err
+}
+
+func RenderExecResults(results []*adapter.ExecResult) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"No", "IP", "Exit", "Duration", "Error"})
+
+	var failed int
+	data := [][]string{}
+	for _, result := range results {
+		errMsg := ""
+		if result.Err != nil {
+			errMsg = result.Err.Error()
+		}
+		if result.Err != nil || result.ExitCode != 0 {
+			failed++
+		}
+
+		line := make([]string, 0, 5)
+		line = append(line, fmt.Sprintf("%3d", result.Machine.Num))
+		line = append(line, result.Machine.IP)
+		line = append(line, fmt.Sprintf("%d", result.ExitCode))
+		line = append(line, result.Duration.Round(time.Millisecond).String())
+		line = append(line, errMsg)
+		data = append(data, line)
+	}
+
+	table.SetFooter([]string{"", "", "", "Failed/Total", fmt.Sprintf("%d/%d", failed, len(results))})
+	table.SetFooterAlignment(tablewriter.ALIGN_RIGHT)
+	table.SetBorder(true)
+	table.SetAlignment(tablewriter.ALIGN_RIGHT)
+	for _, v := range data {
+		table.Append(v)
+	}
+	table.Render()
+	fmt.Println()
+}
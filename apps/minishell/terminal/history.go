@@ -0,0 +1,49 @@
+package terminal
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+	"github.com/olekukonko/tablewriter"
+)
+
+// RenderHistory 显示登录统计, No 为 machine 在当前机器列表中的序号, 已不在列表中的 machine 显示为 -
+func RenderHistory(stats []*assets.HistoryStat) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"No", "IP", "Port", "User", "Remark", "Logins", "Last-Login", "Last-Duration", "Total-Duration", "Last-Exit"})
+
+	data := [][]string{}
+	for _, stat := range stats {
+		no := "-"
+		if stat.Machine != nil {
+			no = fmt.Sprintf("%3d", stat.Machine.Num)
+		}
+		remark := stat.Last.Remark
+		if stat.Machine != nil {
+			remark = stat.Machine.Remark
+		}
+
+		line := make([]string, 0, 10)
+		line = append(line, no)
+		line = append(line, stat.Last.IP)
+		line = append(line, strconv.Itoa(stat.Last.Port))
+		line = append(line, stat.Last.Username)
+		line = append(line, remark)
+		line = append(line, strconv.Itoa(stat.Count))
+		line = append(line, stat.Last.Start.Format("2006-01-02 15:04:05"))
+		line = append(line, stat.Last.Duration.String())
+		line = append(line, stat.Total.String())
+		line = append(line, stat.Last.Exit)
+		data = append(data, line)
+	}
+
+	table.SetBorder(true)
+	table.SetAlignment(tablewriter.ALIGN_RIGHT)
+	for _, v := range data {
+		table.Append(v)
+	}
+	table.Render()
+	fmt.Println()
+}
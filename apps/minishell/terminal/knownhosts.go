@@ -0,0 +1,49 @@
+package terminal
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/adapter"
+	"github.com/olekukonko/tablewriter"
+	"golang.org/x/crypto/ssh"
+)
+
+func RenderKnownHosts(hosts []*adapter.KnownHost) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Line", "Marker", "Host", "Type", "Fingerprint"})
+
+	data := [][]string{}
+	if len(hosts) == 0 {
+		data = append(data, []string{"Null", "Null", "Null", "Null", "Null"})
+	} else {
+		for _, host := range hosts {
+			patterns := make([]string, 0, len(host.Hosts))
+			for _, pattern := range host.Hosts {
+				if strings.HasPrefix(pattern, "|1|") {
+					pattern = "(hashed)"
+				}
+				patterns = append(patterns, pattern)
+			}
+
+			line := make([]string, 0, 5)
+			line = append(line, fmt.Sprintf("%3d", host.Line))
+			line = append(line, host.Marker)
+			line = append(line, strings.Join(patterns, ","))
+			line = append(line, host.Key.Type())
+			line = append(line, ssh.FingerprintSHA256(host.Key))
+			data = append(data, line)
+		}
+	}
+
+	table.SetFooter([]string{"", "", "", "Total", fmt.Sprintf("%3d", len(hosts))})
+	table.SetFooterAlignment(tablewriter.ALIGN_RIGHT)
+	table.SetBorder(true)
+	table.SetAlignment(tablewriter.ALIGN_RIGHT)
+	for _, v := range data {
+		table.Append(v)
+	}
+	table.Render()
+	fmt.Println()
+}
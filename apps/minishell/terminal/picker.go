@@ -0,0 +1,235 @@
+package terminal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+	"github.com/mattn/go-runewidth"
+	"golang.org/x/term"
+)
+
+var ErrPickCanceled = errors.New("pick canceled")
+
+const (
+	keyCtrlC     = 0x03
+	keyCtrlN     = 0x0e
+	keyCtrlP     = 0x10
+	keyCtrlU     = 0x15
+	keyBackspace = 0x7f
+	keyCtrlH     = 0x08
+	keyEnter     = '\r'
+	keyEscape    = 0x1b
+)
+
+// Pick 全屏显示 machines, 支持方向键选择与输入过滤(匹配 IP、NAT-IP、Device、Remark、Group、Tags), 回车返回选中的 machine,
+// Esc 或 Ctrl+C 返回 ErrPickCanceled. stdin/stdout 必须是终端.
+func Pick(machines []*assets.Machine) (*assets.Machine, error) {
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, err
+	}
+	defer term.Restore(fd, state)
+
+	// 使用备用屏幕, 退出后恢复原有内容
+	os.Stdout.WriteString("\x1b[?1049h\x1b[?25l")
+	defer os.Stdout.WriteString("\x1b[?25h\x1b[?1049l")
+
+	p := &picker{machines: machines, matched: machines}
+	buf := make([]byte, 64)
+	for {
+		p.render()
+
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		for keys := buf[:n]; len(keys) != 0; {
+			var (
+				selected *assets.Machine
+				done     bool
+			)
+			keys, selected, done = p.handle(keys)
+			if done {
+				if selected == nil {
+					return nil, ErrPickCanceled
+				}
+				return selected, nil
+			}
+		}
+	}
+}
+
+type picker struct {
+	machines []*assets.Machine
+	matched  []*assets.Machine
+	query    []rune
+	cursor   int
+	offset   int
+}
+
+// handle 处理 keys 中的第一个按键, 返回剩余的输入; done 为 true 时结束选择
+func (p *picker) handle(keys []byte) ([]byte, *assets.Machine, bool) {
+	switch keys[0] {
+	case keyEnter, '\n':
+		if len(p.matched) == 0 {
+			return keys[1:], nil, false
+		}
+		return nil, p.matched[p.cursor], true
+	case keyCtrlC:
+		return nil, nil, true
+	case keyCtrlP:
+		p.move(-1)
+		return keys[1:], nil, false
+	case keyCtrlN:
+		p.move(1)
+		return keys[1:], nil, false
+	case keyCtrlU:
+		p.setQuery(nil)
+		return keys[1:], nil, false
+	case keyBackspace, keyCtrlH:
+		if len(p.query) != 0 {
+			p.setQuery(p.query[:len(p.query)-1])
+		}
+		return keys[1:], nil, false
+	case keyEscape:
+		if len(keys) == 1 {
+			return nil, nil, true
+		}
+		// CSI 序列: ESC [ ... final, 或 SS3: ESC O final
+		if keys[1] == '[' || keys[1] == 'O' {
+			end := 2
+			for end < len(keys) && (keys[end] < 0x40 || keys[end] > 0x7e) {
+				end++
+			}
+			if end == len(keys) {
+				return nil, nil, false
+			}
+			switch string(keys[2 : end+1]) {
+			case "A":
+				p.move(-1)
+			case "B":
+				p.move(1)
+			case "5~":
+				p.move(-p.pageSize())
+			case "6~":
+				p.move(p.pageSize())
+			}
+			return keys[end+1:], nil, false
+		}
+		return keys[1:], nil, false
+	}
+
+	r, size := utf8.DecodeRune(keys)
+	if r != utf8.RuneError && unicode.IsPrint(r) {
+		p.setQuery(append(p.query, r))
+	}
+	return keys[size:], nil, false
+}
+
+func (p *picker) setQuery(query []rune) {
+	p.query = query
+	p.matched = FilterMachines(p.machines, string(query))
+	p.cursor, p.offset = 0, 0
+}
+
+func (p *picker) move(delta int) {
+	if len(p.matched) == 0 {
+		return
+	}
+	p.cursor += delta
+	if p.cursor < 0 {
+		p.cursor = 0
+	}
+	if p.cursor >= len(p.matched) {
+		p.cursor = len(p.matched) - 1
+	}
+}
+
+func (p *picker) pageSize() int {
+	_, h, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || h <= 3 {
+		return 1
+	}
+	return h - 3
+}
+
+func (p *picker) render() {
+	width, _, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 {
+		width = 80
+	}
+	rows := p.pageSize()
+	if p.cursor < p.offset {
+		p.offset = p.cursor
+	}
+	if p.cursor >= p.offset+rows {
+		p.offset = p.cursor - rows + 1
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("\x1b[H\x1b[2J")
+	buf.WriteString("\x1b[1;32m> \x1b[0m" + string(p.query) + "\r\n")
+	buf.WriteString(fmt.Sprintf("  %d/%d  (↑/↓ 选择, 输入过滤, Enter 登录, Esc 退出)\r\n", len(p.matched), len(p.machines)))
+	for i := p.offset; i < len(p.matched) && i < p.offset+rows; i++ {
+		machine := p.matched[i]
+		line := fmt.Sprintf("%3d  %-15s  %-15s  %-8s  %s", machine.Num, machine.IP, machine.NatIP, machine.Device, machine.Remark)
+		line = runewidth.Truncate(line, width-2, "…")
+		if i == p.cursor {
+			buf.WriteString("\x1b[7m> " + line + "\x1b[0m\r\n")
+		} else {
+			buf.WriteString("  " + line + "\r\n")
+		}
+	}
+	os.Stdout.Write(buf.Bytes())
+}
+
+// FilterMachines 按 query 过滤 machines, query 以空格分隔多个关键字, 每个关键字都需在
+// IP、NAT-IP、Device、Remark、Group、Tags 中模糊匹配(按顺序出现即可, 忽略大小写). 连续匹配的结果排在前面.
+func FilterMachines(machines []*assets.Machine, query string) []*assets.Machine {
+	terms := strings.Fields(strings.ToLower(query))
+	if len(terms) == 0 {
+		return machines
+	}
+
+	var exact, fuzzy []*assets.Machine
+	for _, machine := range machines {
+		text := strings.ToLower(strings.Join(append([]string{machine.IP, machine.NatIP, machine.Device, machine.Remark, machine.Group}, machine.Tags...), " "))
+
+		contains, matched := true, true
+		for _, t := range terms {
+			if strings.Contains(text, t) {
+				continue
+			}
+			contains = false
+			if !subsequence(text, t) {
+				matched = false
+				break
+			}
+		}
+		switch {
+		case contains:
+			exact = append(exact, machine)
+		case matched:
+			fuzzy = append(fuzzy, machine)
+		}
+	}
+	return append(exact, fuzzy...)
+}
+
+func subsequence(text, pattern string) bool {
+	for _, r := range pattern {
+		i := strings.IndexRune(text, r)
+		if i < 0 {
+			return false
+		}
+		text = text[i+utf8.RuneLen(r):]
+	}
+	return true
+}
@@ -0,0 +1,25 @@
+package terminal
+
+import (
+	"testing"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterMachines(t *testing.T) {
+	assert := assert.New(t)
+
+	machines := []*assets.Machine{
+		{IP: "10.0.0.1", NatIP: "192.168.1.1", Device: "linux", Remark: "数据库主库"},
+		{IP: "10.0.0.2", NatIP: "192.168.1.2", Device: "linux", Remark: "web-nginx"},
+		{IP: "10.0.1.3", NatIP: "无", Device: "h3c", Remark: "core switch"},
+	}
+
+	assert.Equal(machines, FilterMachines(machines, ""))
+	assert.Equal(machines[2:], FilterMachines(machines, "H3C"))
+	assert.Equal(machines[:1], FilterMachines(machines, "数据库"))
+	assert.Equal(machines[1:2], FilterMachines(machines, "linux ngx"))
+	assert.Equal([]*assets.Machine{machines[1], machines[0]}, FilterMachines(machines, "nx"))
+	assert.Empty(FilterMachines(machines, "oracle"))
+}
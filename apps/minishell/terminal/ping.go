@@ -0,0 +1,94 @@
+package terminal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/adapter"
+	"github.com/olekukonko/tablewriter"
+)
+
+func RenderPingResults(results []*adapter.PingResult) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"No", "IP", "Latency", "Server-Version", "Host-Key", "Auth", "Error"})
+
+	var unreachable int
+	data := [][]string{}
+	for _, result := range results {
+		var latency, hostKey, errMsg string
+		if result.Latency > 0 {
+			latency = result.Latency.Round(100 * time.Microsecond).String()
+		}
+		if !result.Reachable() {
+			unreachable++
+		}
+		if result.Fingerprint != "" {
+			hostKey = result.HostKeyType + " " + result.Fingerprint
+		}
+		if result.Err != nil {
+			errMsg = result.Err.Error()
+		}
+
+		line := make([]string, 0, 7)
+		line = append(line, fmt.Sprintf("%3d", result.Machine.Num))
+		line = append(line, result.Machine.IP)
+		line = append(line, latency)
+		line = append(line, result.ServerVersion)
+		line = append(line, hostKey)
+		line = append(line, result.Auth)
+		line = append(line, errMsg)
+		data = append(data, line)
+	}
+
+	table.SetFooter([]string{"", "", "", "", "", "Unreachable/Total", fmt.Sprintf("%d/%d", unreachable, len(results))})
+	table.SetFooterAlignment(tablewriter.ALIGN_RIGHT)
+	table.SetBorder(true)
+	table.SetAlignment(tablewriter.ALIGN_RIGHT)
+	for _, v := range data {
+		table.Append(v)
+	}
+	table.Render()
+	fmt.Println()
+}
+
+type pingResultJSON struct {
+	Num           int     `json:"num"`
+	IP            string  `json:"ip"`
+	Addr          string  `json:"addr"`
+	Reachable     bool    `json:"reachable"`
+	LatencyMS     float64 `json:"latency_ms"`
+	ServerVersion string  `json:"server_version"`
+	HostKeyType   string  `json:"host_key_type"`
+	Fingerprint   string  `json:"fingerprint"`
+	Auth          string  `json:"auth"`
+	Error         string  `json:"error"`
+}
+
+// WritePingResultsJSON 以 JSON 数组输出结果, 便于脚本处理
+func WritePingResultsJSON(w io.Writer, results []*adapter.PingResult) error {
+	data := make([]pingResultJSON, 0, len(results))
+	for _, result := range results {
+		r := pingResultJSON{
+			Num:           result.Machine.Num,
+			IP:            result.Machine.IP,
+			Addr:          result.Machine.Addr(),
+			Reachable:     result.Reachable(),
+			LatencyMS:     float64(result.Latency.Microseconds()) / 1000,
+			ServerVersion: result.ServerVersion,
+			HostKeyType:   result.HostKeyType,
+			Fingerprint:   result.Fingerprint,
+			Auth:          result.Auth,
+		}
+		if result.Err != nil {
+			r.Error = result.Err.Error()
+		}
+		data = append(data, r)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
@@ -0,0 +1,54 @@
+package terminal
+
+import (
+	"io"
+	"time"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/adapter"
+)
+
+type ReplayOption struct {
+	// Speed 播放倍速, <= 0 时按 1 倍速播放
+	Speed float64
+	// IdleLimit 两次输出之间的最大等待时间, 为 0 时使用文件头中的 idle_time_limit
+	IdleLimit time.Duration
+}
+
+// Replay 按录制时的时间间隔将 asciicast 中的输出事件写入 w
+func Replay(r *adapter.CastReader, w io.Writer, option ReplayOption) error {
+	speed := option.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+	idleLimit := option.IdleLimit
+	if idleLimit == 0 && r.Header.IdleTimeLimit > 0 {
+		idleLimit = time.Duration(r.Header.IdleTimeLimit * float64(time.Second))
+	}
+
+	var last float64
+	for {
+		event, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if event.Type != adapter.CastEventOutput {
+			continue
+		}
+
+		delay := time.Duration((event.Time - last) * float64(time.Second))
+		if idleLimit > 0 && delay > idleLimit {
+			delay = idleLimit
+		}
+		last = event.Time
+		if delay > 0 {
+			time.Sleep(time.Duration(float64(delay) / speed))
+		}
+
+		if _, err := io.WriteString(w, event.Data); err != nil {
+			return err
+		}
+	}
+}
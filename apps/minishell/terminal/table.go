@@ -2,7 +2,9 @@ package terminal
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"strings"
 
 	"github.com/eviltomorrow/toolbox/apps/minishell/assets"
 	"github.com/olekukonko/tablewriter"
@@ -12,6 +14,8 @@ type Option struct {
 	ShowPassword  bool
 	ShowFooter    bool
 	FooterContent string
+	// Columns 显示的列, 参见 ParseColumns; 为空时使用 DefaultColumns
+	Columns []string
 }
 
 func RenderTableFromFile(path string, showPassword bool) error {
@@ -25,46 +29,46 @@ func RenderTableFromFile(path string, showPassword bool) error {
 }
 
 func RenderTable(machines []*assets.Machine, option Option) {
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"No", "IP", "NAT-IP", "Port", "User", "Password", "PrivateKey-Path", "Device", "Remark"})
+	cols, err := resolveColumns(option.Columns)
+	if err != nil || len(cols) == 0 {
+		cols, _ = resolveColumns(DefaultColumns)
+	}
+	renderTable(os.Stdout, machines, cols, option)
+}
+
+func renderTable(w io.Writer, machines []*assets.Machine, cols []*column, option Option) {
+	table := tablewriter.NewWriter(w)
+	header := make([]string, 0, len(cols))
+	for _, c := range cols {
+		header = append(header, c.header)
+	}
+	table.SetHeader(header)
 
 	data := [][]string{}
 	if len(machines) == 0 {
-		data = append(data, []string{"Null", "Null", "Null", "Null", "Null", "Null", "Null", "Null", "Null"})
+		line := make([]string, 0, len(cols))
+		for range cols {
+			line = append(line, "Null")
+		}
+		data = append(data, line)
 	} else {
 		for _, machine := range machines {
-			var (
-				password       = "********"
-				privateKeyPath = "********"
-			)
-			if machine.Password == "" || machine.Password == assets.NotExist {
-				password = machine.Password
-			}
-			if machine.PrivateKeyPath == "" || machine.PrivateKeyPath == assets.NotExist {
-				privateKeyPath = machine.PrivateKeyPath
-			}
-
-			if option.ShowPassword {
-				password = machine.Password
-				privateKeyPath = machine.PrivateKeyPath
+			line := make([]string, 0, len(cols))
+			for _, c := range cols {
+				line = append(line, c.text(machine, option.ShowPassword))
 			}
-
-			line := make([]string, 0, 7)
-			line = append(line, fmt.Sprintf("%3d", machine.Num))
-			line = append(line, machine.IP)
-			line = append(line, machine.NatIP)
-			line = append(line, fmt.Sprintf("%d", machine.Port))
-			line = append(line, machine.Username)
-			line = append(line, password)
-			line = append(line, privateKeyPath)
-			line = append(line, machine.Device)
-			line = append(line, machine.Remark)
 			data = append(data, line)
 		}
 	}
 
 	if option.ShowFooter {
-		table.SetFooter([]string{"", "", "", "", "", "", "", "Total", fmt.Sprintf("%3d", len(machines))})
+		footer := make([]string, len(cols))
+		if len(cols) > 1 {
+			footer[len(cols)-2], footer[len(cols)-1] = "Total", fmt.Sprintf("%3d", len(machines))
+		} else {
+			footer[0] = fmt.Sprintf("Total %d", len(machines))
+		}
+		table.SetFooter(footer)
 		table.SetFooterAlignment(tablewriter.ALIGN_RIGHT)
 	}
 
@@ -76,7 +80,19 @@ func RenderTable(machines []*assets.Machine, option Option) {
 	table.Render()
 
 	if option.FooterContent != "" {
-		fmt.Println(option.FooterContent)
+		fmt.Fprintln(w, option.FooterContent)
+	}
+	fmt.Fprintln(w)
+}
+
+func jumpPath(machine *assets.Machine) string {
+	if len(machine.JumpChain) == 0 {
+		return ""
+	}
+
+	hops := make([]string, 0, len(machine.JumpChain))
+	for _, hop := range machine.JumpChain {
+		hops = append(hops, hop.IP)
 	}
-	fmt.Println()
+	return strings.Join(hops, " -> ")
 }
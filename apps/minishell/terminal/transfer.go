@@ -0,0 +1,141 @@
+package terminal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/eviltomorrow/toolbox/apps/minishell/adapter"
+	"github.com/fatih/color"
+	"github.com/olekukonko/tablewriter"
+)
+
+var red = color.New(color.FgRed)
+
+// Progress 以固定间隔在终端重绘每台机器的传输进度, 每台机器一行
+type Progress struct {
+	w     io.Writer
+	mu    sync.Mutex
+	order []string
+	lines map[string]string
+	drawn int
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewProgress(w io.Writer, hosts []string) *Progress {
+	p := &Progress{w: w, order: hosts, lines: make(map[string]string, len(hosts)), done: make(chan struct{})}
+	for _, host := range hosts {
+		p.lines[host] = "waiting"
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		ticker := time.NewTicker(200 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.draw()
+			case <-p.done:
+				p.draw()
+				return
+			}
+		}
+	}()
+	return p
+}
+
+func (p *Progress) Update(host, file string, written, size int64) {
+	line := fmt.Sprintf("%s %s/%s", filepath.Base(file), FormatBytes(written), FormatBytes(size))
+	if size > 0 {
+		line = fmt.Sprintf("%s %3d%%", line, written*100/size)
+	}
+
+	p.mu.Lock()
+	p.lines[host] = line
+	p.mu.Unlock()
+}
+
+func (p *Progress) Complete(host string, err error) {
+	line := "done"
+	if err != nil {
+		line = red.Sprintf("failed: %v", err)
+	}
+
+	p.mu.Lock()
+	p.lines[host] = line
+	p.mu.Unlock()
+}
+
+func (p *Progress) Stop() {
+	close(p.done)
+	p.wg.Wait()
+	fmt.Fprintln(p.w)
+}
+
+func (p *Progress) draw() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.drawn > 0 {
+		fmt.Fprintf(p.w, "\033[%dA", p.drawn)
+	}
+	for _, host := range p.order {
+		fmt.Fprintf(p.w, "\r\033[K[%s] %s\n", host, p.lines[host])
+	}
+	p.drawn = len(p.order)
+}
+
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func RenderTransferResults(results []*adapter.TransferResult) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"No", "IP", "Files", "Bytes", "Duration", "Error"})
+
+	var failed int
+	data := [][]string{}
+	for _, result := range results {
+		errMsg := ""
+		if result.Err != nil {
+			errMsg = result.Err.Error()
+			failed++
+		}
+
+		line := make([]string, 0, 6)
+		line = append(line, fmt.Sprintf("%3d", result.Machine.Num))
+		line = append(line, result.Machine.IP)
+		line = append(line, fmt.Sprintf("%d", result.Stat.Files))
+		line = append(line, FormatBytes(result.Stat.Bytes))
+		line = append(line, result.Duration.Round(time.Millisecond).String())
+		line = append(line, errMsg)
+		data = append(data, line)
+	}
+
+	table.SetFooter([]string{"", "", "", "", "Failed/Total", fmt.Sprintf("%d/%d", failed, len(results))})
+	table.SetFooterAlignment(tablewriter.ALIGN_RIGHT)
+	table.SetBorder(true)
+	table.SetAlignment(tablewriter.ALIGN_RIGHT)
+	for _, v := range data {
+		table.Append(v)
+	}
+	table.Render()
+	fmt.Println()
+}